@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// this package defines the typed tokens the master hands out. every token
+// carries a role, and the audience is derived from the role, so a token of one
+// kind is rejected wherever another kind is expected. it has no dependencies on
+// the database so hosts and tools can use it too.
+
+// roles
+const RolePlayer string = "player"
+const RoleMachine string = "machine"
+const RoleGameServer string = "gameserver"
+const RoleAdmin string = "admin"
+
+// a join ticket holds a seat in a game for one character. it is redeemed
+// once, by the game server the player connects to.
+const RoleJoinTicket string = "join"
+
+const Issuer string = "thorium-master"
+
+// scopes
+const ScopeAccount string = "account"
+const ScopeCharacters string = "characters"
+const ScopeGames string = "games"
+const ScopeMachineStatus string = "machine:status"
+const ScopeGameReport string = "game:report"
+const ScopeGamePlayers string = "game:players"
+const ScopeGameCharacters string = "game:characters"
+const ScopeAdmin string = "admin"
+
+// DefaultScopes are granted to a new token of each role.
+var DefaultScopes = map[string][]string{
+	RolePlayer:     {ScopeAccount, ScopeCharacters, ScopeGames},
+	RoleMachine:    {ScopeMachineStatus},
+	RoleGameServer: {ScopeGameReport, ScopeGamePlayers, ScopeGameCharacters},
+	RoleAdmin:      {ScopeAdmin},
+	RoleJoinTicket: {},
+}
+
+var ErrInvalidToken = errors.New("auth: invalid token")
+var ErrWrongRole = errors.New("auth: wrong token role")
+var ErrMissingScope = errors.New("auth: missing token scope")
+
+type Claims struct {
+	KeyId     string
+	Role      string
+	Subject   string
+	Audience  string
+	Issuer    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Scopes    []string
+
+	// set depending on the role
+	UserId        int
+	MachineId     int
+	GameId        int
+	CharacterId   int
+	ReservationId int
+}
+
+// Audience is the audience a token of role is issued for.
+func Audience(role string) string {
+
+	return "thorium:" + role
+}
+
+func NewClaims(role string, subject string, ttl time.Duration) *Claims {
+
+	now := time.Now()
+	scopes := append([]string{}, DefaultScopes[role]...)
+
+	return &Claims{
+		Role:      role,
+		Subject:   subject,
+		Audience:  Audience(role),
+		Issuer:    Issuer,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+		Scopes:    scopes,
+	}
+}
+
+func PlayerClaims(uid int, ttl time.Duration) *Claims {
+
+	c := NewClaims(RolePlayer, fmt.Sprintf("user/%d", uid), ttl)
+	c.UserId = uid
+	return c
+}
+
+func MachineClaims(machineId int, ttl time.Duration) *Claims {
+
+	c := NewClaims(RoleMachine, fmt.Sprintf("machine/%d", machineId), ttl)
+	c.MachineId = machineId
+	return c
+}
+
+func GameServerClaims(gameId int, machineId int, ttl time.Duration) *Claims {
+
+	c := NewClaims(RoleGameServer, fmt.Sprintf("game/%d", gameId), ttl)
+	c.GameId = gameId
+	c.MachineId = machineId
+	return c
+}
+
+func JoinTicketClaims(reservationId int, uid int, characterId int, gameId int, ttl time.Duration) *Claims {
+
+	c := NewClaims(RoleJoinTicket, fmt.Sprintf("reservation/%d", reservationId), ttl)
+	c.ReservationId = reservationId
+	c.UserId = uid
+	c.CharacterId = characterId
+	c.GameId = gameId
+	return c
+}
+
+func AdminClaims(name string, ttl time.Duration) *Claims {
+
+	return NewClaims(RoleAdmin, name, ttl)
+}
+
+func (c *Claims) HasScope(scope string) bool {
+
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Require checks the token has role and, unless scope is empty, scope.
+func (c *Claims) Require(role string, scope string) error {
+
+	if c.Role != role {
+		return ErrWrongRole
+	}
+
+	if scope != "" && !c.HasScope(scope) {
+		return ErrMissingScope
+	}
+
+	return nil
+}
+
+// Sign encodes the claims as an RS256 jwt, naming the key in the kid header.
+func Sign(c *Claims, key *SigningKey) (string, error) {
+
+	t := jwt.New(jwt.SigningMethodRS256)
+	t.Header["kid"] = key.Id
+	t.Claims["role"] = c.Role
+	t.Claims["sub"] = c.Subject
+	t.Claims["aud"] = c.Audience
+	t.Claims["iss"] = c.Issuer
+	t.Claims["iat"] = c.IssuedAt.Unix()
+	t.Claims["exp"] = c.ExpiresAt.Unix()
+	t.Claims["scope"] = strings.Join(c.Scopes, " ")
+
+	if c.UserId != 0 {
+		t.Claims["uid"] = c.UserId
+	}
+	if c.MachineId != 0 {
+		t.Claims["machineId"] = c.MachineId
+	}
+	if c.GameId != 0 {
+		t.Claims["gameId"] = c.GameId
+	}
+	if c.CharacterId != 0 {
+		t.Claims["characterId"] = c.CharacterId
+	}
+	if c.ReservationId != 0 {
+		t.Claims["reservationId"] = c.ReservationId
+	}
+
+	return t.SignedString(key.Key)
+}
+
+// Parse verifies a token signed by Sign with a key from keys and returns its
+// claims. the signature, issuer, expiry and the audience matching the role are
+// all checked; the caller still has to check the role is the one it expects.
+func Parse(tokenStr string, keys KeySource) (*Claims, error) {
+
+	var kid string
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, ErrInvalidToken
+		}
+		kid, _ = t.Header["kid"].(string)
+		return keys.PublicKey(kid)
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	c := &Claims{KeyId: kid}
+	c.Role, _ = token.Claims["role"].(string)
+	c.Subject, _ = token.Claims["sub"].(string)
+	c.Audience, _ = token.Claims["aud"].(string)
+	c.Issuer, _ = token.Claims["iss"].(string)
+
+	scope, _ := token.Claims["scope"].(string)
+	c.Scopes = strings.Fields(scope)
+
+	iat, _ := token.Claims["iat"].(float64)
+	c.IssuedAt = time.Unix(int64(iat), 0)
+
+	// exp is checked by jwt.Parse, but only if it is there
+	exp, ok := token.Claims["exp"].(float64)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	c.ExpiresAt = time.Unix(int64(exp), 0)
+
+	if _, ok := DefaultScopes[c.Role]; !ok || c.Issuer != Issuer || c.Audience != Audience(c.Role) {
+		return nil, ErrInvalidToken
+	}
+
+	uid, _ := token.Claims["uid"].(float64)
+	c.UserId = int(uid)
+	machineId, _ := token.Claims["machineId"].(float64)
+	c.MachineId = int(machineId)
+	gameId, _ := token.Claims["gameId"].(float64)
+	c.GameId = int(gameId)
+	characterId, _ := token.Claims["characterId"].(float64)
+	c.CharacterId = int(characterId)
+	reservationId, _ := token.Claims["reservationId"].(float64)
+	c.ReservationId = int(reservationId)
+
+	return c, nil
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func testKey(t *testing.T) *SigningKey {
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSigningKey(key)
+}
+
+func keySet(keys ...*SigningKey) KeySet {
+
+	set := make(KeySet)
+	for _, k := range keys {
+		set[k.Id] = &k.Key.PublicKey
+	}
+
+	return set
+}
+
+func TestSignAndParse(t *testing.T) {
+
+	key := testKey(t)
+
+	token, err := Sign(GameServerClaims(7, 3, time.Hour), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Parse(token, keySet(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.KeyId != key.Id || c.Role != RoleGameServer || c.GameId != 7 || c.MachineId != 3 || c.Subject != "game/7" {
+		t.Fatalf("unexpected claims %+v", c)
+	}
+
+	if err = c.Require(RoleGameServer, ScopeGamePlayers); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinTicket(t *testing.T) {
+
+	key := testKey(t)
+
+	token, err := Sign(JoinTicketClaims(12, 5, 9, 7, time.Minute), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Parse(token, keySet(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Role != RoleJoinTicket || c.ReservationId != 12 || c.UserId != 5 || c.CharacterId != 9 || c.GameId != 7 || c.Subject != "reservation/12" {
+		t.Fatalf("unexpected claims %+v", c)
+	}
+
+	// a ticket is not a session
+	if err = c.Require(RolePlayer, ""); err != ErrWrongRole {
+		t.Fatalf("ticket accepted as a session: %v", err)
+	}
+}
+
+func TestRoleCannotBeReplayed(t *testing.T) {
+
+	key := testKey(t)
+
+	token, _ := Sign(PlayerClaims(12, time.Hour), key)
+	c, err := Parse(token, keySet(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Require(RoleMachine, ""); err != ErrWrongRole {
+		t.Fatalf("player token accepted as machine token: %v", err)
+	}
+
+	if err = c.Require(RolePlayer, ScopeAdmin); err != ErrMissingScope {
+		t.Fatalf("player token has admin scope: %v", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+
+	key := testKey(t)
+	other := testKey(t)
+
+	expired, _ := Sign(PlayerClaims(1, -time.Minute), key)
+
+	forged := PlayerClaims(1, time.Hour)
+	forged.Audience = Audience(RoleAdmin)
+	mismatched, _ := Sign(forged, key)
+
+	wrongKey, _ := Sign(PlayerClaims(1, time.Hour), other)
+
+	// the old unscoped session tokens
+	legacy := jwt.New(jwt.SigningMethodRS256)
+	legacy.Claims["uid"] = 1
+	legacyToken, _ := legacy.SignedString(key.Key)
+
+	cases := map[string]string{
+		"expired":        expired,
+		"audience":       mismatched,
+		"wrong key":      wrongKey,
+		"legacy session": legacyToken,
+		"garbage":        "not.a.token",
+	}
+
+	for name, token := range cases {
+		if _, err := Parse(token, keySet(key)); err != ErrInvalidToken {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestJWKSRoundTrip(t *testing.T) {
+
+	old := testKey(t)
+	current := testKey(t)
+
+	jwks := JWKS{Keys: []JWK{NewJWK(&old.Key.PublicKey), NewJWK(&current.Key.PublicKey)}}
+	data, err := json.Marshal(&jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched JWKS
+	err = json.Unmarshal(data, &fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := fetched.KeySet()
+	if len(set) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set))
+	}
+
+	// tokens from before and after a rotation both verify
+	for _, key := range []*SigningKey{old, current} {
+		token, _ := Sign(PlayerClaims(1, time.Hour), key)
+		if _, err := Parse(token, set); err != nil {
+			t.Fatalf("token signed with %s rejected: %v", key.Id, err)
+		}
+	}
+
+	// a key published under someone else's kid is dropped
+	jwks.Keys[0].Kid = current.Id
+	if len(jwks.KeySet()) != 1 {
+		t.Fatal("mislabelled key accepted")
+	}
+}
+
+func TestRemoteKeySetFetchesUnknownKeys(t *testing.T) {
+
+	first := testKey(t)
+	second := testKey(t)
+
+	published := []*SigningKey{first}
+	fetches := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		jwks := JWKS{}
+		for _, k := range published {
+			jwks.Keys = append(jwks.Keys, NewJWK(&k.Key.PublicKey))
+		}
+		json.NewEncoder(w).Encode(&jwks)
+	}))
+	defer srv.Close()
+
+	keys := NewRemoteKeySet(srv.URL)
+	keys.MinRefresh = 0
+
+	token, _ := Sign(PlayerClaims(1, time.Hour), first)
+	if _, err := Parse(token, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// the master rotates, the host learns of the new key on the first token using it
+	published = append(published, second)
+	token, _ = Sign(PlayerClaims(1, time.Hour), second)
+	if _, err := Parse(token, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if fetches != 2 {
+		t.Fatalf("fetched %d times, want 2", fetches)
+	}
+}
+
+func TestRemoteKeySetDoesNotBlockOnCachedKeys(t *testing.T) {
+
+	known := testKey(t)
+	unknown := testKey(t)
+
+	stalled := make(chan struct{})
+	release := make(chan struct{})
+	fetches := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fetches > 1 {
+			// the master is slow to answer
+			close(stalled)
+			<-release
+		}
+		jwks := JWKS{Keys: []JWK{NewJWK(&known.Key.PublicKey)}}
+		json.NewEncoder(w).Encode(&jwks)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	keys := NewRemoteKeySet(srv.URL)
+	keys.MinRefresh = 0
+	if err := keys.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token naming a key the host hasn't seen waits for the master
+	go keys.PublicKey(unknown.Id)
+	<-stalled
+
+	done := make(chan error)
+	go func() {
+		_, err := keys.PublicKey(known.Id)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup of a cached key waited for the fetch")
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// signing keys are named by their RFC 7638 thumbprint, so the same key always
+// gets the same kid wherever it is loaded.
+
+var ErrUnknownKey = errors.New("auth: unknown signing key")
+
+type SigningKey struct {
+	Id  string
+	Key *rsa.PrivateKey
+}
+
+func NewSigningKey(key *rsa.PrivateKey) *SigningKey {
+
+	return &SigningKey{Id: Thumbprint(&key.PublicKey), Key: key}
+}
+
+// KeySource finds the public key a token names in its kid header.
+type KeySource interface {
+	PublicKey(kid string) (*rsa.PublicKey, error)
+}
+
+// KeySet is a fixed set of public keys by kid.
+type KeySet map[string]*rsa.PublicKey
+
+func (s KeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+
+	key, ok := s[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewJWK(key *rsa.PublicKey) JWK {
+
+	return JWK{
+		Kty: "RSA",
+		Kid: Thumbprint(key),
+		Use: "sig",
+		Alg: "RS256",
+		N:   b64(key.N.Bytes()),
+		E:   b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// KeySet decodes the RSA signing keys of the set. keys whose kid doesn't match
+// their thumbprint are skipped.
+func (jwks *JWKS) KeySet() KeySet {
+
+	set := make(KeySet)
+
+	for _, k := range jwks.Keys {
+
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if Thumbprint(key) != k.Kid {
+			continue
+		}
+
+		set[k.Kid] = key
+	}
+
+	return set
+}
+
+// Thumbprint is the RFC 7638 SHA-256 thumbprint of key.
+func Thumbprint(key *rsa.PublicKey) string {
+
+	// members in lexicographic order, no whitespace
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{b64(big.NewInt(int64(key.E)).Bytes()), "RSA", b64(key.N.Bytes())})
+
+	sum := sha256.Sum256(canonical)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RemoteKeySet verifies tokens offline with the keys the master publishes at
+// /.well-known/jwks.json. keys are fetched again every MaxAge, or sooner when a
+// token names a key the set hasn't seen, but never more often than MinRefresh.
+// if the master can't be reached the last keys fetched stay in use. only a
+// token naming an unknown key waits for a fetch, and concurrent lookups share
+// one.
+type RemoteKeySet struct {
+	URL        string
+	MinRefresh time.Duration
+	MaxAge     time.Duration
+
+	// replace it to fetch over tls
+	Client *http.Client
+
+	mu       sync.RWMutex
+	keys     KeySet
+	fetched  time.Time
+	fetching chan struct{}
+	fetchErr error
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+
+	return &RemoteKeySet{
+		URL:        url,
+		MinRefresh: 10 * time.Second,
+		MaxAge:     5 * time.Minute,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+		keys:       KeySet{},
+	}
+}
+
+func (r *RemoteKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+
+	r.mu.RLock()
+	keys := r.keys
+	age := time.Since(r.fetched)
+	r.mu.RUnlock()
+
+	_, known := keys[kid]
+
+	switch {
+	case age < r.MinRefresh:
+	case !known:
+		err := r.refresh(r.MinRefresh)
+		if err != nil {
+			log.Print("auth: unable to refresh signing keys: ", err)
+		}
+
+		r.mu.RLock()
+		keys = r.keys
+		r.mu.RUnlock()
+
+	case age >= r.MaxAge:
+		// the key in hand is good meanwhile
+		go func() {
+			err := r.refresh(r.MinRefresh)
+			if err != nil {
+				log.Print("auth: unable to refresh signing keys: ", err)
+			}
+		}()
+	}
+
+	return keys.PublicKey(kid)
+}
+
+// Refresh fetches the keys now.
+func (r *RemoteKeySet) Refresh() error {
+
+	return r.refresh(0)
+}
+
+// refresh fetches the keys unless they were fetched less than minAge ago. a
+// call made while a fetch is under way waits for that fetch instead.
+func (r *RemoteKeySet) refresh(minAge time.Duration) error {
+
+	r.mu.Lock()
+
+	if wait := r.fetching; wait != nil {
+		r.mu.Unlock()
+		<-wait
+
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.fetchErr
+	}
+
+	if minAge > 0 && time.Since(r.fetched) < minAge {
+		r.mu.Unlock()
+		return nil
+	}
+
+	// count failures too, so a down master isn't asked on every token
+	r.fetched = time.Now()
+	done := make(chan struct{})
+	r.fetching = done
+	r.mu.Unlock()
+
+	keys, err := r.fetch()
+
+	r.mu.Lock()
+	if err == nil {
+		r.keys = keys
+	}
+	r.fetchErr = err
+	r.fetching = nil
+	r.mu.Unlock()
+
+	close(done)
+	return err
+}
+
+func (r *RemoteKeySet) fetch() (KeySet, error) {
+
+	resp, err := r.Client.Get(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("auth: jwks fetch returned %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwks.KeySet(), nil
+}
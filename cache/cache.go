@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// TTL is a small in-process cache whose entries expire a fixed time after they
+// are set. when it is full the entry closest to expiring makes room.
+type TTL struct {
+	ttl     time.Duration
+	max     int
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func New(ttl time.Duration, max int) *TTL {
+
+	return &TTL{ttl: ttl, max: max, entries: make(map[string]entry)}
+}
+
+func (c *TTL) Get(key string) (interface{}, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !time.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+func (c *TTL) Set(key string, value interface{}) {
+
+	c.SetFor(key, value, c.ttl)
+}
+
+// SetFor stores value with its own ttl.
+func (c *TTL) SetFor(key string, value interface{}, ttl time.Duration) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if _, ok := c.entries[key]; !ok && c.max > 0 && len(c.entries) >= c.max {
+		c.evict(now)
+	}
+
+	c.entries[key] = entry{value: value, expires: now.Add(ttl)}
+}
+
+func (c *TTL) Delete(key string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *TTL) Len() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// evict drops expired entries, or the one expiring first if none have
+func (c *TTL) evict(now time.Time) {
+
+	var oldestKey string
+	var oldest time.Time
+
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || e.expires.Before(oldest) {
+			oldestKey, oldest = key, e.expires
+		}
+	}
+
+	if len(c.entries) >= c.max {
+		delete(c.entries, oldestKey)
+	}
+}
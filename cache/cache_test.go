@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEntriesExpire(t *testing.T) {
+
+	c := New(20*time.Millisecond, 0)
+	c.Set("a", 1)
+
+	if v, ok := c.Get("a"); !ok || v.(int) != 1 {
+		t.Fatal("fresh entry missing")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("entry outlived its ttl")
+	}
+}
+
+func TestFullCacheEvictsSoonestToExpire(t *testing.T) {
+
+	c := New(time.Minute, 2)
+	c.SetFor("short", 1, time.Second)
+	c.Set("long", 2)
+	c.Set("new", 3)
+
+	if c.Len() != 2 {
+		t.Fatalf("cache holds %d entries, want 2", c.Len())
+	}
+
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("the entry closest to expiring should have been evicted")
+	}
+
+	if _, ok := c.Get("long"); !ok {
+		t.Fatal("long lived entry evicted")
+	}
+}
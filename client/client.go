@@ -11,16 +11,15 @@ import (
 import "bytes"
 import "io/ioutil"
 
-func GetStatus(masterEndpoint string) (int, string, error) {
+func GetStatus(masterEndpoint string, opts ...Option) (int, string, error) {
 
-	url := fmt.Sprintf("http://%s/status", masterEndpoint)
+	url := URL(masterEndpoint, "/status")
 	req, err := http.NewRequest("GET", url, bytes.NewBuffer([]byte("")))
 	if err != nil {
 		return 0, "", err
 	}
 
-	c := &http.Client{}
-	resp, err := c.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("ping master - error:\n", err)
 		return 0, "", err
@@ -35,7 +34,7 @@ func GetStatus(masterEndpoint string) (int, string, error) {
 	return resp.StatusCode, string(bodyBytes), nil
 }
 
-func Register(masterEndpoint string, username string, password string) (int, string, error) {
+func Register(masterEndpoint string, username string, password string, opts ...Option) (int, string, error) {
 
 	// create request data struct in memory
 	var loginReq request.Authentication
@@ -49,7 +48,7 @@ func Register(masterEndpoint string, username string, password string) (int, str
 	}
 
 	// create the http request struct
-	url := fmt.Sprintf("http://%s/clients/register", masterEndpoint)
+	url := URL(masterEndpoint, "/clients/register")
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		log.Print("error with request: ", err)
@@ -58,8 +57,7 @@ func Register(masterEndpoint string, username string, password string) (int, str
 	req.Header.Set("Content-Type", "application/json")
 
 	// create the http client struct and execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("error with sending request", err)
 		return 0, "", err
@@ -76,7 +74,7 @@ func Register(masterEndpoint string, username string, password string) (int, str
 	return resp.StatusCode, string(body), nil
 }
 
-func Login(masterEndpoint string, username string, password string) (int, string, error) {
+func Login(masterEndpoint string, username string, password string, opts ...Option) (int, string, error) {
 
 	// create request data struct in memory
 	var loginReq request.Authentication
@@ -90,7 +88,7 @@ func Login(masterEndpoint string, username string, password string) (int, string
 	}
 
 	// create the http request struct
-	url := fmt.Sprintf("http://%s/clients/login", masterEndpoint)
+	url := URL(masterEndpoint, "/clients/login")
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		log.Print("error with request: ", err)
@@ -99,8 +97,7 @@ func Login(masterEndpoint string, username string, password string) (int, string
 	req.Header.Set("Content-Type", "application/json")
 
 	// create the http client struct and execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("error with sending request", err)
 		return 0, "", err
@@ -117,7 +114,56 @@ func Login(masterEndpoint string, username string, password string) (int, string
 	return resp.StatusCode, string(body), nil
 }
 
-func Disconnect(masterEndpoint string, token string) (int, string, error) {
+func ForgotPassword(masterEndpoint string, username string, opts ...Option) (int, string, error) {
+
+	forgotReq := request.ForgotPassword{Username: username}
+
+	jsonBytes, err := json.Marshal(&forgotReq)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(masterEndpoint, "/clients/password/forgot"), jsonBytes, opts)
+}
+
+func ResetPassword(masterEndpoint string, token string, password string, opts ...Option) (int, string, error) {
+
+	resetReq := request.ResetPassword{Token: token, Password: password}
+
+	jsonBytes, err := json.Marshal(&resetReq)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(masterEndpoint, "/clients/password/reset"), jsonBytes, opts)
+}
+
+func postJSON(url string, jsonBytes []byte, opts []Option) (int, string, error) {
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		log.Print("error with request: ", err)
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := send(req, opts)
+	if err != nil {
+		log.Print("error with sending request", err)
+		return 0, "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+
+	return resp.StatusCode, string(body), nil
+}
+
+func Disconnect(masterEndpoint string, token string, opts ...Option) (int, string, error) {
 
 	var disconnectReq request.Disconnect
 	disconnectReq.SessionKey = token
@@ -128,15 +174,14 @@ func Disconnect(masterEndpoint string, token string) (int, string, error) {
 		return 0, "", err
 	}
 
-	url := fmt.Sprintf("http://%s/clients/disconnect", masterEndpoint)
+	url := URL(masterEndpoint, "/clients/disconnect")
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		log.Print("error with request: ", err)
 		return 0, "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("error with sending request", err)
 		return 0, "", err
@@ -152,7 +197,7 @@ func Disconnect(masterEndpoint string, token string) (int, string, error) {
 	return resp.StatusCode, string(body), nil
 }
 
-func CreateCharacter(masterEndpoint string, sessionKey string, name string, classId int) (int, string, error) {
+func CreateCharacter(masterEndpoint string, sessionKey string, name string, classId int, opts ...Option) (int, string, error) {
 
 	var charCreateReq request.CreateCharacter
 	charCreateReq.SessionKey = sessionKey
@@ -162,9 +207,8 @@ func CreateCharacter(masterEndpoint string, sessionKey string, name string, clas
 	if err != nil {
 		return 0, "", err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/characters/new", masterEndpoint), bytes.NewBuffer(jsonBytes))
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	req, err := http.NewRequest("POST", URL(masterEndpoint, "/characters/new"), bytes.NewBuffer(jsonBytes))
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("Error with request: ", err)
 		return 0, "", err
@@ -174,7 +218,7 @@ func CreateCharacter(masterEndpoint string, sessionKey string, name string, clas
 	return resp.StatusCode, string(body), nil
 }
 
-func SelectCharacter(masterEndpoint string, sessionKey string, characterId int) (int, string, error) {
+func SelectCharacter(masterEndpoint string, sessionKey string, characterId int, opts ...Option) (int, string, error) {
 
 	selectCharacter := request.SelectCharacter{
 
@@ -187,11 +231,10 @@ func SelectCharacter(masterEndpoint string, sessionKey string, characterId int)
 		return 0, "", err
 	}
 
-	url := fmt.Sprintf("http://%s/characters/select", masterEndpoint)
+	url := URL(masterEndpoint, "/characters/select")
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(json))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("error with sending request", err)
 		return 0, "", err
@@ -207,9 +250,16 @@ func SelectCharacter(masterEndpoint string, sessionKey string, characterId int)
 	return resp.StatusCode, string(body), nil
 }
 
-func GetGameList(masterEndpoint string) (int, string, error) {
+// GetGameList returns a page of the games matching filter as a
+// request.GameList. a nil filter lists every game, newest first.
+func GetGameList(masterEndpoint string, filter *request.GameFilter, opts ...Option) (int, string, error) {
 
-	url := fmt.Sprintf("http://%s/games", masterEndpoint)
+	url := URL(masterEndpoint, "/v2/games")
+	if filter != nil {
+		if query := filter.Query().Encode(); query != "" {
+			url += "?" + query
+		}
+	}
 
 	req, err := http.NewRequest("GET", url, bytes.NewBuffer([]byte("")))
 	if err != nil {
@@ -217,8 +267,50 @@ func GetGameList(masterEndpoint string) (int, string, error) {
 		return 0, "", err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
+	if err != nil {
+		log.Print("error with sending request", err)
+		return 0, "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+
+	return resp.StatusCode, string(body), nil
+}
+
+// GetCharacterProfile returns a character's model.CharacterProfile
+func GetCharacterProfile(masterEndpoint string, characterId int, opts ...Option) (int, string, error) {
+
+	return get(URL(masterEndpoint, fmt.Sprintf("/v2/characters/%d/profile", characterId)), opts)
+}
+
+// GetMatchHistory returns a page of a character's matches as a
+// request.MatchHistory. a nil page is the first, at the default size.
+func GetMatchHistory(masterEndpoint string, characterId int, page *request.MatchPage, opts ...Option) (int, string, error) {
+
+	url := URL(masterEndpoint, fmt.Sprintf("/v2/characters/%d/matches", characterId))
+	if page != nil {
+		if query := page.Query().Encode(); query != "" {
+			url += "?" + query
+		}
+	}
+
+	return get(url, opts)
+}
+
+func get(url string, opts []Option) (int, string, error) {
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("error with sending request", err)
 		return 0, "", err
@@ -234,7 +326,7 @@ func GetGameList(masterEndpoint string) (int, string, error) {
 	return resp.StatusCode, string(body), nil
 }
 
-func CreateNewGame(masterEndpoint string, sessionKey string, mapName string, gameMode string, minimumLevel int, maxPlayers int) (int, string, error) {
+func CreateNewGame(masterEndpoint string, sessionKey string, mapName string, gameMode string, minimumLevel int, maxPlayers int, opts ...Option) (int, string, error) {
 
 	data := request.CreateNewGame{
 		SessionKey:   sessionKey,
@@ -247,9 +339,8 @@ func CreateNewGame(masterEndpoint string, sessionKey string, mapName string, gam
 	if err != nil {
 		return 0, "", err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/games", masterEndpoint), bytes.NewBuffer(jsonBytes))
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	req, err := http.NewRequest("POST", URL(masterEndpoint, "/games"), bytes.NewBuffer(jsonBytes))
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("Error with request: ", err)
 		return 0, "", err
@@ -259,11 +350,48 @@ func CreateNewGame(masterEndpoint string, sessionKey string, mapName string, gam
 	return resp.StatusCode, string(body), nil
 }
 
-func GetServerInfo(masterEndpoint string, gameId int) (int, string, error) {
+// CreateGame places a new game through v2, which takes the regions to place
+// it in. the answer is a request.CreateNewGameResponse, or 503 when no host
+// has room.
+func CreateGame(masterEndpoint string, sessionKey string, game *request.NewGame, opts ...Option) (int, string, error) {
+
+	json, err := json.Marshal(game)
+	if err != nil {
+
+		return 0, "", err
+	}
+
+	req, err := http.NewRequest("POST", URL(masterEndpoint, "/v2/games"), bytes.NewBuffer(json))
+	if err != nil {
+
+		return 0, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sessionKey)
+
+	resp, err := send(req, opts)
+	if err != nil {
+
+		return 0, "", err
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), nil
+}
+
+// ListRegions returns the regions hosts run in as a list of
+// model.RegionCapacity, for a client to measure its latency to
+func ListRegions(masterEndpoint string, opts ...Option) (int, string, error) {
+
+	return get(URL(masterEndpoint, "/v2/regions"), opts)
+}
+
+func GetServerInfo(masterEndpoint string, gameId int, opts ...Option) (int, string, error) {
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/games/%d/server_info", masterEndpoint, gameId), bytes.NewBuffer([]byte("")))
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	req, err := http.NewRequest("GET", URL(masterEndpoint, fmt.Sprintf("/games/%d/server_info", gameId)), bytes.NewBuffer([]byte("")))
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("Error with request: ", err)
 		return 0, "", err
@@ -273,11 +401,13 @@ func GetServerInfo(masterEndpoint string, gameId int) (int, string, error) {
 	return resp.StatusCode, string(body), nil
 }
 
-func JoinGame(masterEndpoint string, gameId int, sessionKey string) (int, string, error) {
+// JoinGame reserves a seat in a running game for one of the player's
+// characters. the request.JoinGameResponse says where to connect and holds
+// the join ticket to give the game server, which has to redeem it before
+// expiresAt.
+func JoinGame(masterEndpoint string, gameId int, sessionKey string, characterId int, opts ...Option) (int, string, error) {
 
-	data := request.JoinGame{
-		GameId:     gameId,
-		SessionKey: sessionKey}
+	data := request.GameJoin{CharacterId: characterId}
 
 	json, err := json.Marshal(&data)
 	if err != nil {
@@ -285,14 +415,16 @@ func JoinGame(masterEndpoint string, gameId int, sessionKey string) (int, string
 		return 0, "", err
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/games/join", masterEndpoint), bytes.NewBuffer(json))
+	req, err := http.NewRequest("POST", URL(masterEndpoint, fmt.Sprintf("/v2/games/%d/join", gameId)), bytes.NewBuffer(json))
 	if err != nil {
 
 		return 0, "", err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sessionKey)
+
+	resp, err := send(req, opts)
 	if err != nil {
 
 		return 0, "", err
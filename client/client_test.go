@@ -16,7 +16,7 @@ import (
 var masterEndpoint = "localhost:6960"
 var sessionKey string
 var characterIds []int
-var gameList []model.Game
+var gameList []model.GameListing
 var gameId int
 var gameServerAddress string
 var gameServerPort int
@@ -195,7 +195,7 @@ func Test4A_GameGameList(t *testing.T) {
 
 	fmt.Println("Test4A: Get Game List")
 
-	rc, body, err := GetGameList(masterEndpoint)
+	rc, body, err := GetGameList(masterEndpoint, &request.GameFilter{OpenSlots: true})
 	if err != nil {
 		fmt.Println(err)
 		t.FailNow()
@@ -207,11 +207,14 @@ func Test4A_GameGameList(t *testing.T) {
 		t.FailNow()
 	}
 
-	err = json.Unmarshal([]byte(body), &gameList)
+	var list request.GameList
+	err = json.Unmarshal([]byte(body), &list)
 	if err != nil {
 		fmt.Println(err)
 		t.FailNow()
 	}
+
+	gameList = list.Games
 }
 
 // Test 4B: Create New Game
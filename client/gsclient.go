@@ -13,7 +13,7 @@ import (
 
 // this package contains game server requests
 
-func PlayerConnect(serviceEndpoint string, gameId int, machineKey string, sessionKey string, characterId int) (statusCode int, body string, err error) {
+func PlayerConnect(serviceEndpoint string, gameId int, machineKey string, sessionKey string, characterId int, opts ...Option) (statusCode int, body string, err error) {
 
 	data := request.PlayerConnect{
 		GameId:      gameId,
@@ -27,14 +27,13 @@ func PlayerConnect(serviceEndpoint string, gameId int, machineKey string, sessio
 		return
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/games/player_connect", serviceEndpoint), bytes.NewBuffer(json))
+	req, err := http.NewRequest("POST", URL(serviceEndpoint, "/games/player_connect"), bytes.NewBuffer(json))
 	if err != nil {
 
 		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 
 		return
@@ -45,7 +44,20 @@ func PlayerConnect(serviceEndpoint string, gameId int, machineKey string, sessio
 	return resp.StatusCode, string(bodyBytes), nil
 }
 
-func UpdateCharacter(serviceEndpoint string, machineKey string, character *model.Character) (statusCode int, body string, err error) {
+// JoinPlayer is PlayerConnect for a player holding a join ticket, which the
+// master redeems for the seat it reserved. the host relays it like the
+// other game server requests.
+func JoinPlayer(serviceEndpoint string, machineKey string, gameId int, join *request.PlayerJoin, opts ...Option) (int, string, error) {
+
+	jsonBytes, err := json.Marshal(join)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postBearer(URL(serviceEndpoint, fmt.Sprintf("/v2/games/%d/players", gameId)), machineKey, jsonBytes, opts)
+}
+
+func UpdateCharacter(serviceEndpoint string, machineKey string, character *model.Character, opts ...Option) (statusCode int, body string, err error) {
 
 	data := request.UpdateCharacter{
 		MachineKey: machineKey,
@@ -57,14 +69,13 @@ func UpdateCharacter(serviceEndpoint string, machineKey string, character *model
 		return
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/characters", serviceEndpoint), bytes.NewBuffer(json))
+	req, err := http.NewRequest("POST", URL(serviceEndpoint, "/characters"), bytes.NewBuffer(json))
 	if err != nil {
 
 		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 
 		return
@@ -75,7 +86,7 @@ func UpdateCharacter(serviceEndpoint string, machineKey string, character *model
 	return resp.StatusCode, string(bodyBytes), nil
 }
 
-func PlayerDisconnect(serviceEndpoint string, machineKey string, gameId int, character *model.Character) (statusCode int, body string, err error) {
+func PlayerDisconnect(serviceEndpoint string, machineKey string, gameId int, character *model.Character, opts ...Option) (statusCode int, body string, err error) {
 
 	data := request.PlayerDisconnect{
 		MachineKey: machineKey,
@@ -88,14 +99,13 @@ func PlayerDisconnect(serviceEndpoint string, machineKey string, gameId int, cha
 		return
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/games/player_disconnect", serviceEndpoint), bytes.NewBuffer(json))
+	req, err := http.NewRequest("POST", URL(serviceEndpoint, "/games/player_disconnect"), bytes.NewBuffer(json))
 	if err != nil {
 
 		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 
 		return
@@ -106,7 +116,7 @@ func PlayerDisconnect(serviceEndpoint string, machineKey string, gameId int, cha
 	return resp.StatusCode, string(bodyBytes), nil
 }
 
-func ShutdownServer(serviceEndpoint string, machineKey string, gameId int) (statusCode int, body string, err error) {
+func ShutdownServer(serviceEndpoint string, machineKey string, gameId int, opts ...Option) (statusCode int, body string, err error) {
 
 	data := request.ShutdownServer{
 		MachineKey: machineKey,
@@ -119,14 +129,13 @@ func ShutdownServer(serviceEndpoint string, machineKey string, gameId int) (stat
 		return
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/games/shutdown_server", serviceEndpoint), bytes.NewBuffer(json))
+	req, err := http.NewRequest("POST", URL(serviceEndpoint, "/games/shutdown_server"), bytes.NewBuffer(json))
 	if err != nil {
 
 		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := send(req, opts)
 	if err != nil {
 
 		return
@@ -136,3 +145,48 @@ func ShutdownServer(serviceEndpoint string, machineKey string, gameId int) (stat
 	bodyBytes, _ := ioutil.ReadAll(resp.Body)
 	return resp.StatusCode, string(bodyBytes), nil
 }
+
+func RegisterGameServer(serviceEndpoint string, data *request.RegisterGameServer, opts ...Option) (int, string, error) {
+
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(serviceEndpoint, "/games/register_server"), jsonBytes, opts)
+}
+
+// ReportMatchResults sends a game's results, once, before the game server
+// shuts down. the host checks the game server's token and relays them to the
+// master.
+func ReportMatchResults(serviceEndpoint string, machineKey string, gameId int, results *request.MatchResults, opts ...Option) (int, string, error) {
+
+	jsonBytes, err := json.Marshal(results)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postBearer(URL(serviceEndpoint, fmt.Sprintf("/v2/games/%d/results", gameId)), machineKey, jsonBytes, opts)
+}
+
+// postBearer posts a json body to a v2 route with the game server's token in
+// the Authorization header
+func postBearer(url string, machineKey string, jsonBytes []byte, opts []Option) (int, string, error) {
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+machineKey)
+
+	resp, err := send(req, opts)
+	if err != nil {
+		return 0, "", err
+	}
+
+	defer resp.Body.Close()
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(bodyBytes), nil
+}
@@ -10,10 +10,13 @@ import (
 	"github.com/jaybennett89/thorium-go/requests"
 )
 
-func NewGameServer(endpoint string, gameId int, mapName string, mode string, minLevel int, maxPlayers int) (int, string, error) {
+// NewGameServer has a host start a game server. like EndGameServer it takes
+// the host's machine key.
+func NewGameServer(endpoint string, machineKey string, gameId int, gameToken string, mapName string, mode string, minLevel int, maxPlayers int, opts ...Option) (int, string, error) {
 
 	data := request.NewGameServer{
 		GameId:         gameId,
+		GameToken:      gameToken,
 		Map:            mapName,
 		Mode:           mode,
 		MinimumLevel:   minLevel,
@@ -24,9 +27,13 @@ func NewGameServer(endpoint string, gameId int, mapName string, mode string, min
 	if err != nil {
 		return 0, "", err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/games", endpoint), bytes.NewBuffer(jsonBytes))
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	req, err := http.NewRequest("POST", URL(endpoint, "/games"), bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+machineKey)
+	resp, err := send(req, opts)
 	if err != nil {
 		log.Print("Error with request: ", err)
 		return 0, "", err
@@ -35,3 +42,73 @@ func NewGameServer(endpoint string, gameId int, mapName string, mode string, min
 	body, _ := ioutil.ReadAll(resp.Body)
 	return resp.StatusCode, string(body), nil
 }
+
+// EndGameServer has a host stop one of its game servers. the host only takes
+// this from a caller holding its machine key.
+func EndGameServer(endpoint string, gameId int, machineKey string, opts ...Option) (int, string, error) {
+
+	req, err := http.NewRequest("DELETE", URL(endpoint, fmt.Sprintf("/games/%d", gameId)), bytes.NewBuffer([]byte("")))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+machineKey)
+
+	resp, err := send(req, opts)
+	if err != nil {
+		log.Print("Error with request: ", err)
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), nil
+}
+
+// RotateGameServerKey hands a running game server its new key. the game
+// server proves the call comes from its host by the key it already has.
+func RotateGameServerKey(gameServerEndpoint string, machineKey string, newMachineKey string, opts ...Option) (int, string, error) {
+
+	data := request.RotateGameServerKey{
+		MachineKey:    machineKey,
+		NewMachineKey: newMachineKey,
+	}
+
+	jsonBytes, err := json.Marshal(&data)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(gameServerEndpoint, "/key"), jsonBytes, opts)
+}
+
+// RegisterMachine registers a host with the master, or renews it when data
+// carries the host's previous machine key.
+func RegisterMachine(masterEndpoint string, data *request.RegisterMachine, opts ...Option) (int, string, error) {
+
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(masterEndpoint, "/machines/register"), jsonBytes, opts)
+}
+
+func MachineStatus(masterEndpoint string, data *request.MachineStatus, opts ...Option) (int, string, error) {
+
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(masterEndpoint, "/machines/status"), jsonBytes, opts)
+}
+
+func UnregisterMachine(masterEndpoint string, machineId int, machineKey string, opts ...Option) (int, string, error) {
+
+	jsonBytes, err := json.Marshal(&request.UnregisterMachine{MachineKey: machineKey})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return postJSON(URL(masterEndpoint, fmt.Sprintf("/machines/%d/disconnect", machineId)), jsonBytes, opts)
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/trace"
+)
+
+// Option changes how one request is sent. every function in this package
+// takes options last.
+type Option func(*options)
+
+type options struct {
+	requestId string
+}
+
+// RequestID sends the request with id in its X-Request-Id header, so a
+// process passing a request on keeps the id it was given. without it a new
+// id is generated.
+func RequestID(id string) Option {
+
+	return func(o *options) {
+		o.requestId = id
+	}
+}
+
+// send makes req with httpClient and records it as a client span
+func send(req *http.Request, opts []Option) (*http.Response, error) {
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.requestId == "" {
+		o.requestId = trace.NewID()
+	}
+	req.Header.Set(trace.Header, o.requestId)
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	trace.Default.Record(trace.Since(o.requestId, trace.KindClient, req.Method+" "+req.URL.Host+req.URL.Path, start, status))
+
+	return resp, err
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+)
+
+// every request in this package is sent with httpClient. endpoints are
+// host:port pairs reached over http unless UseTLS was called, but an endpoint
+// may name its own scheme, as in http://localhost:10001.
+var httpClient = &http.Client{}
+var scheme = "http"
+
+// UseTLS sends requests over https with cfg. call it before making any
+// requests.
+func UseTLS(cfg *tls.Config) {
+
+	httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	scheme = "https"
+}
+
+// HTTPClient is the client this package sends requests with, for callers
+// making requests of their own to the same servers.
+func HTTPClient() *http.Client {
+
+	return httpClient
+}
+
+// URL returns the url of path on endpoint.
+func URL(endpoint string, path string) string {
+
+	if strings.Contains(endpoint, "://") {
+		return endpoint + path
+	}
+
+	return scheme + "://" + endpoint + path
+}
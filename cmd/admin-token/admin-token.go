@@ -0,0 +1,44 @@
+package main
+
+// issues an operator token for the master /admin api. run it from the
+// repository root so it finds the same keys/app.rsa the master signs with.
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jaybennett89/thorium-go/auth"
+)
+
+func main() {
+
+	name := flag.String("name", "", "operator name recorded with admin actions")
+	keyPath := flag.String("key", "keys/app.rsa", "path to the master signing key")
+	ttl := flag.Duration("ttl", 8*time.Hour, "how long the token is valid")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatal("admin-token: -name is required")
+	}
+
+	keyBytes, err := ioutil.ReadFile(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := auth.Sign(auth.AdminClaims(*name, *ttl), auth.NewSigningKey(signKey))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(token)
+}
@@ -7,15 +7,20 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"github.com/jaybennett89/thorium-go/client"
+	"github.com/jaybennett89/thorium-go/logging"
 	"github.com/jaybennett89/thorium-go/model"
 	"github.com/jaybennett89/thorium-go/requests"
+	"github.com/jaybennett89/thorium-go/trace"
 	"time"
 
 	"github.com/go-martini/martini"
 )
 
+// the host rotates the key while requests are being served
 var machineKey string
+var keyMu sync.RWMutex
 var listenPort int
 var servicePort int
 var game model.Game
@@ -68,11 +73,18 @@ func main() {
 
 	players = make(map[string]*model.Character)
 
+	// player requests carry their id through the host to the master
+	trace.Process = "gameserver"
+
 	m := martini.Classic()
+	m.Use(trace.Middleware(logging.New("gameserver")))
 	m.Get("/status", handleStatusRequest)
 	m.Post("/connect", handleConnectRequest)
 	m.Post("/move", handleMoveRequest)
 	m.Post("/disconnect", handleDisconnect)
+
+	// called by the host when it rotates this server's key
+	m.Post("/key", handleRotateKey)
 	m.RunOnAddr(fmt.Sprintf(":%d", listenPort))
 }
 
@@ -98,7 +110,7 @@ func handleConnectRequest(httpReq *http.Request) (int, string) {
 
 	serviceEndpoint := fmt.Sprintf("localhost:%d", servicePort)
 
-	rc, body, err := client.PlayerConnect(serviceEndpoint, game.GameId, machineKey, req.SessionKey, req.CharacterId)
+	rc, body, err := client.PlayerConnect(serviceEndpoint, game.GameId, currentMachineKey(), req.SessionKey, req.CharacterId, client.RequestID(trace.FromRequest(httpReq)))
 	if err != nil {
 
 		fmt.Println(err)
@@ -145,7 +157,7 @@ func handleMoveRequest(httpReq *http.Request) (int, string) {
 
 	serviceEndpoint := fmt.Sprintf("localhost:%d", servicePort)
 
-	rc, body, err := client.UpdateCharacter(serviceEndpoint, machineKey, players[req.SessionKey])
+	rc, body, err := client.UpdateCharacter(serviceEndpoint, currentMachineKey(), players[req.SessionKey], client.RequestID(trace.FromRequest(httpReq)))
 	if err != nil {
 
 		fmt.Println(err)
@@ -178,7 +190,7 @@ func handleDisconnect(httpReq *http.Request) (int, string) {
 
 	serviceEndpoint := fmt.Sprintf("localhost:%d", servicePort)
 
-	rc, body, err := client.PlayerDisconnect(serviceEndpoint, machineKey, game.GameId, players[req.SessionKey])
+	rc, body, err := client.PlayerDisconnect(serviceEndpoint, currentMachineKey(), game.GameId, players[req.SessionKey], client.RequestID(trace.FromRequest(httpReq)))
 	if err != nil {
 
 		fmt.Println(err)
@@ -193,3 +205,32 @@ func handleDisconnect(httpReq *http.Request) (int, string) {
 
 	return 200, "OK"
 }
+
+func handleRotateKey(httpReq *http.Request) (int, string) {
+
+	var req request.RotateGameServerKey
+	decoder := json.NewDecoder(httpReq.Body)
+	err := decoder.Decode(&req)
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	keyMu.Lock()
+	if req.MachineKey != machineKey || req.NewMachineKey == "" {
+		keyMu.Unlock()
+		return 403, "Invalid Key"
+	}
+
+	machineKey = req.NewMachineKey
+	keyMu.Unlock()
+
+	log.Print("machine key rotated")
+	return 200, "OK"
+}
+
+func currentMachineKey() string {
+
+	keyMu.RLock()
+	defer keyMu.RUnlock()
+	return machineKey
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/cmd/host-server/hostconf"
+	"github.com/jaybennett89/thorium-go/health"
+	"github.com/jaybennett89/thorium-go/launch"
+)
+
+// a host whose heartbeats haven't been accepted for this long may already
+// have been reaped by the master
+const heartbeatStale time.Duration = 30 * time.Second
+
+var errNotRegistered = errors.New("host: not registered with the master")
+
+// /healthz answers while the process runs. /readyz answers 200 only while the
+// host can take games: it is registered and approved, its config is usable
+// and it has a port free for another game server.
+func registerHealth(m *martini.ClassicMartini) {
+
+	health.Register("registration", checkRegistration)
+	health.Register("config", checkConfig)
+	health.Register("ports", checkPorts)
+
+	m.Get("/healthz", health.LiveHandler().ServeHTTP)
+	m.Get("/readyz", health.Handler().ServeHTTP)
+}
+
+func checkRegistration() (string, error) {
+
+	registerMu.RLock()
+	data := registerData
+	expires := machineKeyExpires
+	heartbeat := lastHeartbeat
+	registerMu.RUnlock()
+
+	if data.MachineKey == "" {
+		return "", errNotRegistered
+	}
+
+	if data.Approval == "pending" {
+		return "", fmt.Errorf("host: machine %d is waiting for an operator's approval", data.MachineId)
+	}
+
+	if !expires.IsZero() && time.Now().After(expires) {
+		return "", fmt.Errorf("host: the key of machine %d expired at %s", data.MachineId, expires.Format(time.RFC3339))
+	}
+
+	if heartbeat.IsZero() {
+		return fmt.Sprintf("machine %d, no heartbeat yet", data.MachineId), nil
+	}
+
+	since := time.Since(heartbeat)
+	if since > heartbeatStale {
+		return "", fmt.Errorf("host: the master last accepted a heartbeat %s ago", since.Truncate(time.Second))
+	}
+
+	return fmt.Sprintf("machine %d, last heartbeat %s ago", data.MachineId, since.Truncate(time.Millisecond)), nil
+}
+
+func checkConfig() (string, error) {
+
+	err := hostconf.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	if region := hostconf.Region(); region != "" {
+		return "session check " + hostconf.SessionCheck() + ", region " + region, nil
+	}
+
+	return "session check " + hostconf.SessionCheck(), nil
+}
+
+func checkPorts() (string, error) {
+
+	used, total := launch.PortUsage()
+	if used >= total {
+		return "", launch.ErrNoFreePorts
+	}
+
+	return fmt.Sprintf("%d of %d game server ports free", total-used, total), nil
+}
@@ -1,96 +1,167 @@
 package main
 
 import (
-	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jaybennett89/thorium-go/auth"
+	"github.com/jaybennett89/thorium-go/cache"
 	"github.com/jaybennett89/thorium-go/client"
+	"github.com/jaybennett89/thorium-go/cmd/host-server/hostconf"
 	"github.com/jaybennett89/thorium-go/launch"
+	"github.com/jaybennett89/thorium-go/logging"
+	"github.com/jaybennett89/thorium-go/metrics"
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/pki"
 	request "github.com/jaybennett89/thorium-go/requests"
+	"github.com/jaybennett89/thorium-go/trace"
 	"github.com/jaybennett89/thorium-go/usage"
+	"github.com/jaybennett89/thorium-go/validation"
 )
 
 import _ "github.com/lib/pq"
 import "github.com/go-martini/martini"
 
+var log = logging.New("host")
+
 // application data
 var registerData request.MachineRegisterResponse
+var machineKeyExpires time.Time
+var lastHeartbeat time.Time
+var registerMu sync.RWMutex
 var listenPort int
 
+// the port game servers reach this host on
+var localPort int
+
+// the machine key is renewed this long before it expires
+const machineKeyRenewBefore time.Duration = 7 * 24 * time.Hour
+
+// registration retries back off exponentially up to this delay
+const maxRegisterBackoff time.Duration = time.Minute
+
+// asks renewLoop to re-register, see requestRenewal
+var renew = make(chan struct{}, 1)
+
+// the key the master issued each local game server, by game id. game servers
+// present it on every call and it is passed on to the master as is.
+var gameTokens = make(map[int]string)
+
+// keys replaced by a rotation, still accepted until the game server has
+// taken its new key
+var retiredGameTokens = make(map[int]string)
+var gameTokensMu sync.Mutex
+
 var masterEndpoint string = "thorium-sky.net:6960"
 
+// player sessions are verified here with the master's published keys, so a
+// bad session key never costs a round trip to the master
+var masterKeys *auth.RemoteKeySet
+
+// characters the master recently handed out, by character id
+var characters = cache.New(time.Minute, 4096)
+
+// sessions the master refused after a lenient connect, refused here until
+// they expire
+var revokedSessions = cache.New(24*time.Hour, 4096)
+
+var heartbeatErrors = metrics.NewCounter("thorium_host_heartbeat_errors_total", "Heartbeats that failed to reach the master or were refused.")
+var runningGameServers = metrics.NewGauge("thorium_host_game_servers", "Game servers running on this host.")
+var portsInUse = metrics.NewGauge("thorium_host_ports_in_use", "Game server ports taken.")
+var portsTotal = metrics.NewGauge("thorium_host_ports_total", "Game server ports available to this host.")
+
+type cachedCharacter struct {
+	UserId       int
+	Character    *model.Character
+	Restrictions []string
+}
+
 func main() {
-	fmt.Println("hello world")
+
+	// machine keys pass through this process, the logging package keeps them
+	// out of the logs
+	err := logging.Configure()
+	if err != nil {
+		log.Fatal("bad logging configuration", "err", err)
+	}
+	logging.CaptureStdLog(logging.New("log"))
+	trace.Process = "host"
 
 	timeNow := time.Now()
 	rand.Seed(int64(timeNow.Second()))
 	listenPort = 10000
 
-	fmt.Println(strconv.Itoa(listenPort), "\n")
+	log.Info("starting host", "port", listenPort)
 
-	reqData := &request.RegisterMachine{Port: listenPort}
-	jsonBytes, err := json.Marshal(reqData)
+	tlsConfig, err := setupTLS()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("couldn't set up tls", "err", err)
 	}
 
-	url := fmt.Sprintf("http://%s/machines/register", masterEndpoint)
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
+	masterKeys = auth.NewRemoteKeySet(client.URL(masterEndpoint, "/.well-known/jwks.json"))
+	masterKeys.Client.Transport = client.HTTPClient().Transport
+	err = masterKeys.Refresh()
 	if err != nil {
-		log.Fatal(err)
+		log.Warn("unable to fetch master signing keys", "err", err)
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+	// the first registration runs like any renewal, so the host already
+	// answers its health checks while it waits for the master
+	go renewLoop()
+	requestRenewal()
 
-	if response.StatusCode != 200 {
-		log.Print("Error registering with master")
-		os.Exit(1)
-	}
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+	m := newRouter()
+	masterRoutes(m)
 
-	json.Unmarshal([]byte(body), &registerData)
-	fmt.Println("Registered As Machine#", registerData.MachineId)
+	if tlsConfig == nil {
+		localPort = listenPort
+		localRoutes(m)
+		registerHealth(m)
+	} else {
 
-	m := martini.Classic()
+		// game servers have no certificates, they get a loopback only listener
+		localPort = hostconf.LocalServicePort()
+		if localPort == 0 {
+			localPort = listenPort + 1
+		}
 
-	// called by master
-	m.Get("/", handlePingRequest)
-	m.Get("/status", handlePingRequest)
-	m.Post("/games", handlePostNewGame)
+		local := newRouter()
+		localRoutes(local)
+		go func() {
+			err := http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", localPort), local)
+			log.Fatal("local listener stopped", "port", localPort, "err", err)
+		}()
+
+		// probes have no certificates either, the health checks get a plain
+		// listener of their own
+		healthPort := hostconf.HealthPort()
+		if healthPort == 0 {
+			healthPort = localPort + 1
+		}
 
-	// called by local gameservers
-	m.Post("/games/register_server", handleRegisterLocalServer)
-	m.Post("/games/player_connect", handlePlayerConnect)
-	m.Post("/games/player_disconnect", handlePlayerDisconnect)
-	m.Post("/games/shutdown_server", handleShutdownServer)
-	m.Post("/characters", handleUpdateCharacter)
+		probes := newRouter()
+		registerHealth(probes)
+		go func() {
+			err := http.ListenAndServe(fmt.Sprintf(":%d", healthPort), probes)
+			log.Fatal("health listener stopped", "port", healthPort, "err", err)
+		}()
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGKILL, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		fmt.Println(<-c)
+		log.Info("shutting down", "signal", <-c)
 		shutdown()
 		os.Exit(1)
 	}()
@@ -107,45 +178,333 @@ func main() {
 	}()
 
 	thisIp := fmt.Sprintf(":%d", listenPort)
-	m.RunOnAddr(thisIp)
+	if tlsConfig == nil {
+		m.RunOnAddr(thisIp)
+		return
+	}
+
+	server := &http.Server{Addr: thisIp, Handler: m, TLSConfig: tlsConfig}
+	log.Info("listening with tls", "addr", thisIp)
+	err = server.ListenAndServeTLS("", "")
+	log.Fatal("server stopped", "err", err)
+}
+
+func newRouter() *martini.ClassicMartini {
+
+	m := martini.Classic()
+	m.Map(logging.New("http").StdLogger(logging.Info))
+	m.Use(trace.Middleware(log))
+	return m
+}
+
+// called by master
+func masterRoutes(m *martini.ClassicMartini) {
+
+	m.Use(metrics.Middleware("thorium_host"))
+	m.Get("/metrics", metrics.Handler().ServeHTTP)
+	m.Get("/requests/:id", requireAdmin, trace.Handler)
+	metrics.Default.OnScrape(func() {
+		used, total := launch.PortUsage()
+		runningGameServers.Set(float64(len(launch.GetServerList())))
+		portsInUse.Set(float64(used))
+		portsTotal.Set(float64(total))
+	})
+
+	m.Get("/", handlePingRequest)
+	m.Get("/status", handlePingRequest)
+	m.Post("/games", requireMachineKey, handlePostNewGame)
+	m.Delete("/games/:id", requireMachineKey, handleEndGame)
+}
+
+// called by local gameservers
+func localRoutes(m *martini.ClassicMartini) {
+
+	m.Post("/games/register_server", handleRegisterLocalServer)
+	m.Post("/games/player_connect", handlePlayerConnect)
+	m.Post("/v2/games/:id/players", handleJoinPlayer)
+	m.Post("/games/player_disconnect", handlePlayerDisconnect)
+	m.Post("/games/shutdown_server", handleShutdownServer)
+	m.Post("/v2/games/:id/results", handleReportResults)
+	m.Post("/characters", handleUpdateCharacter)
+}
+
+// setupTLS configures tls from host.config and returns the config of the
+// listener the master calls, or nil to serve plain http. with a ca file only
+// the master, or another holder of a certificate from the cluster authority,
+// can call it.
+func setupTLS() (*tls.Config, error) {
+
+	certFile, keyFile, caFile := hostconf.TLSFiles()
+	if certFile == "" {
+		return nil, nil
+	}
+
+	serverCfg, err := pki.ServerConfig(certFile, keyFile, caFile, caFile != "")
+	if err != nil {
+		return nil, err
+	}
+
+	clientCfg, err := pki.ClientConfig(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client.UseTLS(clientCfg)
+	return serverCfg, nil
 }
 
 func sendHeartbeat() {
-	var err error
+
 	statusData := &request.MachineStatus{}
-	statusData.MachineKey = registerData.MachineKey
+	statusData.MachineKey = currentMachineKey()
+
+	// not registered yet, renewLoop is on it
+	if statusData.MachineKey == "" {
+		return
+	}
 	statusData.UsageCPU, _ = usage.GetCPU()
 	statusData.UsageNetwork, _ = usage.GetNetworkUtilization()
 	statusData.PlayerCapacity = 0.0
 
-	jsonBytes, err := json.Marshal(statusData)
+	rc, body, err := client.MachineStatus(masterEndpoint, statusData)
 	if err != nil {
 
-		log.Print(err)
+		heartbeatErrors.Inc()
+		log.Warn("heartbeat failed", "err", err)
 		return
 	}
 
-	url := fmt.Sprintf("http://%s/machines/status", masterEndpoint)
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
-	if err != nil {
+	if rc != 200 {
+		heartbeatErrors.Inc()
+	} else {
+		// an older master answers with no approval, which leaves it as it was
+		var status request.MachineStatusResponse
+		json.Unmarshal([]byte(body), &status)
+
+		registerMu.Lock()
+		lastHeartbeat = time.Now()
+		machineId := registerData.MachineId
+		approved := registerData.Approval == "pending" && status.Approval == "approved"
+		if status.Approval != "" {
+			registerData.Approval = status.Approval
+		}
+		registerMu.Unlock()
+
+		if approved {
+			log.Info("approved by an operator", "machine", machineId)
+		}
+	}
 
-		log.Print(err)
+	// the master restarted, or missed enough heartbeats to drop the session
+	if rc == 401 || rc == 403 {
+
+		log.Warn("machine key refused by master, re-registering", "status", rc)
+		requestRenewal()
 		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Connection", "close")
+	registerMu.RLock()
+	expires := machineKeyExpires
+	registerMu.RUnlock()
+
+	if !expires.IsZero() && expires.Sub(time.Now()) < machineKeyRenewBefore {
+		requestRenewal()
+	}
+}
+
+// requestRenewal asks renewLoop to re-register. requests made while a
+// renewal is already pending are merged into it.
+func requestRenewal() {
+
+	select {
+	case renew <- struct{}{}:
+	default:
+	}
+}
+
+func renewLoop() {
+
+	for range renew {
+
+		registerWithBackoff()
+
+		// anything that asked while we were registering is answered
+		select {
+		case <-renew:
+		default:
+		}
+	}
+}
+
+// registerWithBackoff registers with the master, retrying with exponential
+// backoff until it succeeds
+func registerWithBackoff() {
+
+	delay := time.Second
+	for {
+
+		err := register()
+		if err == nil {
+			return
+		}
+
+		log.Warn("unable to register with master", "retry", delay, "err", err)
 
-	client := &http.Client{}
-	var resp *http.Response
-	resp, err = client.Do(request)
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay/2))))
+		delay *= 2
+		if delay > maxRegisterBackoff {
+			delay = maxRegisterBackoff
+		}
+	}
+}
+
+// register registers with the master, or renews the registration if the host
+// already has a machine key. a renewal rotates the machine key and the keys
+// of the game servers still running here.
+func register() error {
+
+	registerMu.RLock()
+	previous := registerData
+	registerMu.RUnlock()
+
+	reqData := request.RegisterMachine{Port: listenPort, JoinToken: hostconf.JoinToken(), Region: hostconf.Region(), Zone: hostconf.Zone()}
+	if previous.MachineKey != "" {
+		reqData.MachineKey = previous.MachineKey
+		reqData.Games = runningGames()
+	}
+
+	rc, body, err := client.RegisterMachine(masterEndpoint, &reqData)
 	if err != nil {
+		return err
+	}
 
-		log.Print(err)
-		return
+	if rc == 403 && previous.MachineKey != "" {
+
+		// an operator expelled this machine, and the master already dropped its games
+		rotateGameTokens(nil)
+		log.Fatal("machine was revoked by the master", "machine", previous.MachineId)
+	}
+
+	if rc == 401 && previous.MachineKey != "" {
+
+		// the key is no good for a renewal, start over as a new machine
+		registerMu.Lock()
+		registerData = request.MachineRegisterResponse{}
+		registerMu.Unlock()
+
+		return fmt.Errorf("machine key %d refused", previous.MachineId)
+	}
+
+	if rc != 200 {
+		return fmt.Errorf("master responded %d: %s", rc, body)
+	}
+
+	var data request.MachineRegisterResponse
+	err = json.Unmarshal([]byte(body), &data)
+	if err != nil {
+		return err
+	}
+
+	var expires time.Time
+	claims, err := auth.Parse(data.MachineKey, masterKeys)
+	if err == nil {
+		expires = claims.ExpiresAt
+	}
+
+	registerMu.Lock()
+	registerData = data
+	machineKeyExpires = expires
+	registerMu.Unlock()
+
+	if previous.MachineKey == "" {
+		log.Info("registered", "machine", data.MachineId)
+	} else if previous.MachineId != data.MachineId {
+		log.Info("re-registered", "machine", data.MachineId, "previous", previous.MachineId)
+	} else {
+		log.Info("renewed registration", "machine", data.MachineId, "rotated", len(data.GameTokens))
+	}
+
+	if data.Approval == "pending" {
+		log.Warn("waiting for an operator to approve this machine before it is given games", "machine", data.MachineId)
+	}
+
+	rotateGameTokens(data.GameTokens)
+	return nil
+}
+
+func currentMachineKey() string {
+
+	registerMu.RLock()
+	defer registerMu.RUnlock()
+	return registerData.MachineKey
+}
+
+func runningGames() []int {
+
+	gameTokensMu.Lock()
+	defer gameTokensMu.Unlock()
+
+	games := make([]int, 0, len(gameTokens))
+	for gameId := range gameTokens {
+		games = append(games, gameId)
 	}
 
-	resp.Body.Close()
+	return games
+}
+
+// rotateGameTokens pushes new keys to the local game servers. game servers the
+// master no longer knows of can't report players or results, so they are
+// stopped.
+func rotateGameTokens(newTokens map[int]string) {
+
+	for _, gameId := range runningGames() {
+
+		newToken, ok := newTokens[gameId]
+		if !ok {
+
+			log.Warn("game is gone from the master, stopping its game server", "game", gameId)
+			forgetGameToken(gameId)
+			err := launch.StopGameServer(gameId)
+			if err != nil && err != launch.ErrGameServerNotFound {
+				log.Error("couldn't stop game server", "game", gameId, "err", err)
+			}
+			continue
+		}
+
+		gameTokensMu.Lock()
+		oldToken := gameTokens[gameId]
+		gameTokens[gameId] = newToken
+		retiredGameTokens[gameId] = oldToken
+		gameTokensMu.Unlock()
+
+		port, found := gameServerPort(gameId)
+		if !found {
+			continue
+		}
+
+		rc, _, err := client.RotateGameServerKey(fmt.Sprintf("http://localhost:%d", port), oldToken, newToken)
+		if err != nil || rc != 200 {
+
+			// the old key stays good with the master until it expires
+			log.Warn("unable to push new key to game server", "game", gameId, "status", rc, "err", err)
+			continue
+		}
+
+		gameTokensMu.Lock()
+		delete(retiredGameTokens, gameId)
+		gameTokensMu.Unlock()
+	}
+}
+
+func gameServerPort(gameId int) (int, bool) {
+
+	for _, gs := range launch.GetServerList() {
+		if gs.Game.GameId == gameId {
+			return gs.ListenPort, true
+		}
+	}
+
+	return 0, false
 }
 
 func handlePingRequest() (int, string) {
@@ -157,23 +516,25 @@ func handlePostNewGame(httpReq *http.Request, params martini.Params) (int, strin
 	defer httpReq.Body.Close()
 
 	var data request.NewGameServer
-	decoder := json.NewDecoder(httpReq.Body)
-
-	err := decoder.Decode(&data)
+	err := decode(httpReq, &data)
 	if err != nil {
 
-		log.Print("bad json request:\n", httpReq.Body)
+		log.Info("bad new game request", "err", err)
 		return 400, err.Error() // okay to send err back to master
 	}
 
-	err = launch.NewGameServer(registerData.MachineKey, listenPort, data.GameId, data.Map, data.Mode, data.MinimumLevel, data.MaximumPlayers)
+	err = launch.NewGameServer(data.GameToken, localPort, data.GameId, data.Map, data.Mode, data.MinimumLevel, data.MaximumPlayers)
 	if err != nil {
 
-		log.Print(err)
+		log.Error("couldn't start game server", "game", data.GameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
-	response := request.NewGameServerResponse{registerData.MachineKey}
+	gameTokensMu.Lock()
+	gameTokens[data.GameId] = data.GameToken
+	gameTokensMu.Unlock()
+
+	response := request.NewGameServerResponse{currentMachineKey()}
 
 	json, err := json.Marshal(&response)
 	if err != nil {
@@ -184,198 +545,463 @@ func handlePostNewGame(httpReq *http.Request, params martini.Params) (int, strin
 	return 200, string(json)
 }
 
+// requireMachineKey only lets through callers holding this host's current
+// machine key, which only the master knows
+func requireMachineKey(res http.ResponseWriter, httpReq *http.Request) {
+
+	key := currentMachineKey()
+	header := httpReq.Header.Get("Authorization")
+
+	if key == "" || subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+key)) != 1 {
+		log.Warn("refused unauthenticated master call", "method", httpReq.Method, "path", httpReq.URL.Path, "addr", httpReq.RemoteAddr)
+		http.Error(res, "Unauthorized", 401)
+	}
+}
+
+// requireAdmin only lets through callers holding an admin token signed with a
+// key the master publishes, like the master's own /admin routes
+func requireAdmin(res http.ResponseWriter, httpReq *http.Request) {
+
+	header := httpReq.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		http.Error(res, "Unauthorized", 401)
+		return
+	}
+
+	claims, err := auth.Parse(strings.TrimPrefix(header, "Bearer "), masterKeys)
+	if err == nil {
+		err = claims.Require(auth.RoleAdmin, auth.ScopeAdmin)
+	}
+
+	if err != nil {
+		log.Warn("refused admin call", "method", httpReq.Method, "path", httpReq.URL.Path, "addr", httpReq.RemoteAddr, "err", err)
+		http.Error(res, "Unauthorized", 401)
+	}
+}
+
+func handleEndGame(params martini.Params) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	forgetGameToken(gameId)
+
+	err = launch.StopGameServer(gameId)
+	switch {
+	case err == launch.ErrGameServerNotFound:
+		return 404, "Game Not Found"
+	case err != nil:
+		log.Error("couldn't stop game server", "game", gameId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return 200, "OK"
+}
+
 func handlePlayerConnect(httpReq *http.Request) (int, string) {
 
 	var data request.PlayerConnect
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&data)
+	err := decode(httpReq, &data)
 	if err != nil {
 
-		fmt.Println(err)
+		log.Info("bad player connect request", "err", err)
+		return badRequest(err)
+	}
+
+	if !isGameToken(data.MachineKey) {
+
+		log.Warn("invalid game server key on player connect", "game", data.GameId)
+		return 403, "Invalid Key"
+	}
+
+	join := request.PlayerJoin{SessionKey: data.SessionKey, CharacterId: data.CharacterId}
+	return admitPlayer(data.GameId, data.MachineKey, join, trace.FromRequest(httpReq))
+}
+
+// a player connecting with a join ticket. the game server sends its token in
+// the Authorization header, as v2 does.
+func handleJoinPlayer(httpReq *http.Request, params martini.Params) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
 		return 400, "Bad Request"
 	}
 
-	if data.MachineKey != registerData.MachineKey {
+	var data request.PlayerJoin
+	err = decode(httpReq, &data)
+	if err != nil {
 
-		log.Print("WARNING: Received invalid machine key during player connect")
-		log.Printf("have %s recv %s", registerData.MachineKey, data.MachineKey)
+		log.Info("bad player join request", "err", err)
+		return badRequest(err)
+	}
+
+	key := strings.TrimPrefix(httpReq.Header.Get("Authorization"), "Bearer ")
+	if !isGameToken(key) {
+
+		log.Warn("invalid game server key on player join", "game", gameId)
 		return 403, "Invalid Key"
 	}
 
-	rc, body, err := client.PlayerConnect(masterEndpoint, data.GameId, data.MachineKey, data.SessionKey, data.CharacterId)
+	return admitPlayer(gameId, key, data, trace.FromRequest(httpReq))
+}
 
+// admitPlayer checks the player's session and has the master count them in
+// the game. a lenient host lets a player it has cached in before the master
+// answers, but never one with a join ticket: only the master knows whether
+// the seat is still held.
+func admitPlayer(gameId int, machineKey string, join request.PlayerJoin, requestId string) (int, string) {
+
+	session, err := verifySession(join.SessionKey)
 	if err != nil {
+		return 401, "Invalid Session"
+	}
+
+	if hostconf.SessionCheck() == hostconf.SessionCheckLenient && join.JoinTicket == "" {
+
+		cached, ok := lookupCharacter(session.UserId, join.CharacterId)
+		if ok {
+
+			go confirmPlayerConnect(gameId, machineKey, join, requestId)
+
+			resp := request.PlayerConnectResponse{Character: cached.Character, Restrictions: cached.Restrictions}
+			jsonBytes, err := json.Marshal(&resp)
+			if err != nil {
+				return 500, "Internal Server Error"
+			}
 
-		fmt.Println(err)
+			return 200, string(jsonBytes)
+		}
+	}
+
+	return confirmPlayerConnect(gameId, machineKey, join, requestId)
+}
+
+// verifySession checks a player's session key offline. the master still has
+// the final say on revocation and bans, see hostconf.SessionCheck.
+func verifySession(sessionKey string) (*auth.Claims, error) {
+
+	if _, revoked := revokedSessions.Get(sessionKey); revoked {
+		return nil, auth.ErrInvalidToken
+	}
+
+	claims, err := auth.Parse(sessionKey, masterKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	err = claims.Require(auth.RolePlayer, auth.ScopeGames)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// confirmPlayerConnect passes the connect on to the master and caches the
+// character it returns. sessions the master refuses are remembered.
+func confirmPlayerConnect(gameId int, machineKey string, join request.PlayerJoin, requestId string) (int, string) {
+
+	var rc int
+	var body string
+	var err error
+
+	if join.JoinTicket != "" {
+		rc, body, err = client.JoinPlayer(masterEndpoint, machineKey, gameId, &join, client.RequestID(requestId))
+	} else {
+		rc, body, err = client.PlayerConnect(masterEndpoint, gameId, machineKey, join.SessionKey, join.CharacterId, client.RequestID(requestId))
+	}
+
+	if err != nil {
+
+		log.Error("couldn't forward player connect", "game", gameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
+	switch rc {
+	case 200:
+		var resp request.PlayerConnectResponse
+		err = json.Unmarshal([]byte(body), &resp)
+		if err == nil && resp.Character != nil {
+			claims, err := auth.Parse(join.SessionKey, masterKeys)
+			if err == nil {
+				storeCharacter(&cachedCharacter{UserId: claims.UserId, Character: resp.Character, Restrictions: resp.Restrictions})
+			}
+		}
+
+	case 401, 403:
+		characters.Delete(strconv.Itoa(join.CharacterId))
+		claims, err := auth.Parse(join.SessionKey, masterKeys)
+		if err == nil {
+			revokedSessions.SetFor(join.SessionKey, true, claims.ExpiresAt.Sub(time.Now()))
+		}
+	}
+
 	return rc, body
 }
 
+func lookupCharacter(uid int, characterId int) (*cachedCharacter, bool) {
+
+	v, ok := characters.Get(strconv.Itoa(characterId))
+	if !ok {
+		return nil, false
+	}
+
+	cached := v.(*cachedCharacter)
+	if cached.UserId != uid {
+		return nil, false
+	}
+
+	return cached, true
+}
+
+func storeCharacter(cached *cachedCharacter) {
+
+	characters.SetFor(strconv.Itoa(cached.Character.CharacterId), cached, hostconf.CharacterCacheTTL())
+}
+
+// updateCachedCharacter replaces a cached character with a newer snapshot
+func updateCachedCharacter(snapshot *model.Character) {
+
+	if snapshot == nil {
+		return
+	}
+
+	v, ok := characters.Get(strconv.Itoa(snapshot.CharacterId))
+	if !ok {
+		return
+	}
+
+	cached := v.(*cachedCharacter)
+	storeCharacter(&cachedCharacter{UserId: cached.UserId, Character: snapshot, Restrictions: cached.Restrictions})
+}
+
 func handlePlayerDisconnect(httpReq *http.Request) (int, string) {
 
 	var data request.PlayerDisconnect
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&data)
+	err := decode(httpReq, &data)
 	if err != nil {
 
-		fmt.Println(err)
-		return 400, "Bad Request"
+		log.Info("bad player disconnect request", "err", err)
+		return badRequest(err)
 	}
 
-	if data.MachineKey != registerData.MachineKey {
+	if !isGameToken(data.MachineKey) {
 
-		log.Print("WARNING: Received invalid machine key during player connect")
-		log.Printf("have %s recv %s", registerData.MachineKey, data.MachineKey)
+		log.Warn("invalid game server key on player disconnect", "game", data.GameId)
 		return 403, "Invalid Key"
 	}
 
-	rc, body, err := client.PlayerDisconnect(masterEndpoint, data.MachineKey, data.GameId, data.Snapshot)
+	rc, body, err := client.PlayerDisconnect(masterEndpoint, data.MachineKey, data.GameId, data.Snapshot, client.RequestID(trace.FromRequest(httpReq)))
 
 	if err != nil {
 
-		fmt.Println(err)
+		log.Error("couldn't forward player disconnect", "game", data.GameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
+	if rc == 200 {
+		updateCachedCharacter(data.Snapshot)
+	}
+
 	return rc, body
 }
 
 func handleShutdownServer(httpReq *http.Request) (int, string) {
 
 	var data request.ShutdownServer
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&data)
+	err := decode(httpReq, &data)
 	if err != nil {
 
-		fmt.Println(err)
-		return 400, "Bad Request"
+		log.Info("bad shutdown server request", "err", err)
+		return badRequest(err)
 	}
 
-	if data.MachineKey != registerData.MachineKey {
+	if !isGameToken(data.MachineKey) {
 
-		log.Print("WARNING: Received invalid machine key during player connect")
-		log.Printf("have %s recv %s", registerData.MachineKey, data.MachineKey)
+		log.Warn("invalid game server key on shutdown server", "game", data.GameId)
 		return 403, "Invalid Key"
 	}
 
-	rc, body, err := client.ShutdownServer(masterEndpoint, data.MachineKey, data.GameId)
+	rc, body, err := client.ShutdownServer(masterEndpoint, data.MachineKey, data.GameId, client.RequestID(trace.FromRequest(httpReq)))
 
 	if err != nil {
 
-		fmt.Println(err)
+		log.Error("couldn't forward shutdown server", "game", data.GameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
+	if rc == 200 {
+		forgetGameToken(data.GameId)
+	}
+
 	return rc, body
 }
 
-func handleUpdateCharacter(httpReq *http.Request) (int, string) {
+// the game server sends its token in the Authorization header, as v2 does,
+// and it is relayed as it came
+func handleReportResults(httpReq *http.Request, params martini.Params) (int, string) {
 
-	var data request.UpdateCharacter
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&data)
+	gameId, err := strconv.Atoi(params["id"])
 	if err != nil {
-
-		fmt.Println(err)
 		return 400, "Bad Request"
 	}
 
-	if data.MachineKey != registerData.MachineKey {
+	var data request.MatchResults
+	err = decode(httpReq, &data)
+	if err == nil {
+		err = data.Check()
+	}
+	if err != nil {
 
-		log.Print("WARNING: Received invalid machine key during update character")
-		log.Printf("have %s recv %s", registerData.MachineKey, data.MachineKey)
-		return 403, "Invalid Key"
+		log.Info("bad match results", "game", gameId, "err", err)
+		return badRequest(err)
 	}
 
-	rc, body, err := client.UpdateCharacter(masterEndpoint, data.MachineKey, data.Snapshot)
+	key := strings.TrimPrefix(httpReq.Header.Get("Authorization"), "Bearer ")
+	if !isGameToken(key) {
 
+		log.Warn("invalid game server key on match results", "game", gameId)
+		return 403, "Invalid Key"
+	}
+
+	rc, body, err := client.ReportMatchResults(masterEndpoint, key, gameId, &data, client.RequestID(trace.FromRequest(httpReq)))
 	if err != nil {
 
-		fmt.Println(err)
+		log.Error("couldn't forward match results", "game", gameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return rc, body
 }
 
-func handleRegisterLocalServer(httpReq *http.Request, params martini.Params) (int, string) {
+func handleUpdateCharacter(httpReq *http.Request) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
-	var data request.RegisterGameServer
-	err := decoder.Decode(&data)
+	var data request.UpdateCharacter
+	err := decode(httpReq, &data)
 	if err != nil {
 
-		log.Print(err)
-		return 400, "Bad Request"
+		log.Info("bad update character request", "err", err)
+		return badRequest(err)
 	}
 
-	if data.MachineKey != registerData.MachineKey {
+	if !isGameToken(data.MachineKey) {
 
-		log.Print("WARNING: Received invalid key trying to register local gameserver")
-		log.Printf("have %s recv %s", registerData.MachineKey, data.MachineKey)
+		log.Warn("invalid game server key on update character")
 		return 403, "Invalid Key"
 	}
 
-	var jsonBytes []byte
-	jsonBytes, err = json.Marshal(&data)
+	rc, body, err := client.UpdateCharacter(masterEndpoint, data.MachineKey, data.Snapshot, client.RequestID(trace.FromRequest(httpReq)))
+
 	if err != nil {
 
-		log.Print(err)
+		log.Error("couldn't forward update character", "err", err)
 		return 500, "Internal Server Error"
 	}
 
-	endpoint := fmt.Sprintf("http://%s/games/register_server", masterEndpoint)
-	var req *http.Request
-	req, err = http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonBytes))
+	if rc == 200 {
+		updateCachedCharacter(data.Snapshot)
+	}
+
+	return rc, body
+}
+
+func handleRegisterLocalServer(httpReq *http.Request, params martini.Params) (int, string) {
+
+	var data request.RegisterGameServer
+	err := decode(httpReq, &data)
 	if err != nil {
 
-		log.Print(err)
-		return 500, "Internal Server Error"
+		log.Info("bad register server request", "err", err)
+		return badRequest(err)
 	}
 
-	client := &http.Client{}
-	var resp *http.Response
-	resp, err = client.Do(req)
+	if !isGameToken(data.MachineKey) {
+
+		log.Warn("invalid game server key on register server", "game", data.GameId)
+		return 403, "Invalid Key"
+	}
+
+	rc, _, err := client.RegisterGameServer(masterEndpoint, &data, client.RequestID(trace.FromRequest(httpReq)))
 	if err != nil {
 
-		log.Print(err)
+		log.Error("couldn't forward register server", "game", data.GameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
+	if rc != 200 {
 
-		log.Print("error: couldn't register game server with master")
+		log.Warn("master refused to register game server", "game", data.GameId, "status", rc)
 		return 400, "Bad Request"
 	}
 
 	return 200, "OK"
 }
 
+func isGameToken(key string) bool {
+
+	gameTokensMu.Lock()
+	defer gameTokensMu.Unlock()
+
+	for _, token := range gameTokens {
+		if key != "" && key == token {
+			return true
+		}
+	}
+
+	for _, token := range retiredGameTokens {
+		if key != "" && key == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+func forgetGameToken(gameId int) {
+
+	gameTokensMu.Lock()
+	delete(gameTokens, gameId)
+	delete(retiredGameTokens, gameId)
+	gameTokensMu.Unlock()
+}
+
 func shutdown() {
 
-	var reqData request.UnregisterMachine
-	reqData.MachineKey = registerData.MachineKey
-	jsonBytes, err := json.Marshal(&reqData)
-	if err != nil {
+	registerMu.RLock()
+	current := registerData
+	registerMu.RUnlock()
+
+	// never got as far as registering
+	if current.MachineKey == "" {
 		return
 	}
 
-	var req *http.Request
-	req, err = http.NewRequest("POST", fmt.Sprintf("http://%s/machines/%d/disconnect", masterEndpoint, registerData.MachineId), bytes.NewBuffer(jsonBytes))
+	_, _, err := client.UnregisterMachine(masterEndpoint, current.MachineId, current.MachineKey)
 	if err != nil {
-		return
+		log.Warn("failed to disconnect properly", "machine", current.MachineId, "err", err)
 	}
+}
+
+// decode reads a json body into v and checks it against v's validate tags
+func decode(httpReq *http.Request, v interface{}) error {
 
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	var resp *http.Response
-	resp, err = client.Do(req)
+	err := json.NewDecoder(httpReq.Body).Decode(v)
 	if err != nil {
-		log.Print("failed to disconnect properly")
-		return
+		return err
 	}
-	resp.Body.Close()
+
+	return validation.Struct(v)
+}
+
+// badRequest answers a body decode refused: a broken rule names its field,
+// anything else is just malformed
+func badRequest(err error) (int, string) {
+
+	if fieldErr, ok := err.(*validation.FieldError); ok {
+		return 400, fieldErr.Error()
+	}
+
+	return 400, "Bad Request"
 }
@@ -2,36 +2,100 @@ package hostconf
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"os"
 	"time"
+
+	"github.com/jaybennett89/thorium-go/logging"
+	request "github.com/jaybennett89/thorium-go/requests"
 )
 
+// session check modes
+const SessionCheckStrict string = "strict"
+const SessionCheckLenient string = "lenient"
+
+const defaultCharacterCacheSeconds int = 30
+
 type HostConfiguration struct {
 	GameserverBinaryPath string
+
+	// strict confirms every player connect with the master before admitting
+	// the player. lenient admits a player whose character is cached straight
+	// away and confirms with the master in the background, so a revoked
+	// session is only refused from its next connect.
+	SessionCheck string
+
+	CharacterCacheSeconds int
+
+	// the join token an operator created for enrolling this host. the
+	// THORIUM_JOIN_TOKEN environment variable takes precedence.
+	JoinToken string
+
+	// where the host runs, eu-west and eu-west-1a. games are placed in the
+	// regions players ask for, so a host without one only gets games that
+	// ask for none, or that found no room where they asked. the
+	// THORIUM_REGION and THORIUM_ZONE environment variables take precedence.
+	Region string
+	Zone   string
+
+	// with a certificate the host serves the master over https, and with a
+	// ca file it only takes calls from certificates that authority signed and
+	// calls the master with its own certificate. game servers then reach the
+	// host over plain http on LocalServicePort, which only listens on the
+	// loopback interface. these are read once at start.
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSCAFile        string
+	LocalServicePort int
+
+	// with a certificate, /healthz and /readyz are served over plain http on
+	// this port, on every interface, since probes have no certificate.
+	// LocalServicePort + 1 when 0.
+	HealthPort int
+
+	// a level spec such as "info" or "warn,launch=debug". it is applied
+	// again whenever the file changes, so levels can be raised on a running
+	// host.
+	LogLevel string
 }
 
+var log = logging.New("hostconf")
+
 var config HostConfiguration
 var lastConfigMod time.Time
 
 func init() {
 	file, err := os.Open("config/host.config")
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("couldn't open config", "err", err)
 	}
 
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&config)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("couldn't parse config", "err", err)
 	}
 
 	info, err := os.Stat("config/host.config")
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("couldn't stat config", "err", err)
 	}
 
 	lastConfigMod = info.ModTime()
+	applyLogLevel()
+}
+
+func applyLogLevel() {
+
+	if config.LogLevel == "" {
+		return
+	}
+
+	err := logging.ParseLevels(config.LogLevel)
+	if err != nil {
+		log.Error("bad LogLevel in config", "err", err)
+	}
 }
 
 func GameserverBinaryPath() string {
@@ -45,7 +109,7 @@ func checkConfigFile() {
 	info, err := os.Stat("config/host.config")
 	if err != nil {
 
-		log.Fatal(err)
+		log.Fatal("couldn't stat config", "err", err)
 	}
 
 	modTime := info.ModTime()
@@ -55,18 +119,141 @@ func checkConfigFile() {
 		file, err := os.Open("config/host.config")
 		if err != nil {
 
-			log.Fatal(err)
+			log.Fatal("couldn't open config", "err", err)
 		}
 
 		decoder := json.NewDecoder(file)
 		err = decoder.Decode(&config)
 		if err != nil {
 
-			log.Fatal(err)
+			log.Fatal("couldn't parse config", "err", err)
 		}
 
 		lastConfigMod = modTime
+		applyLogLevel()
+
+		log.Info("config reloaded")
+	}
+}
+
+func SessionCheck() string {
+
+	checkConfigFile()
+	if config.SessionCheck == SessionCheckLenient {
+		return SessionCheckLenient
+	}
+
+	return SessionCheckStrict
+}
+
+func CharacterCacheTTL() time.Duration {
+
+	checkConfigFile()
+	if config.CharacterCacheSeconds <= 0 {
+		return time.Duration(defaultCharacterCacheSeconds) * time.Second
+	}
+
+	return time.Duration(config.CharacterCacheSeconds) * time.Second
+}
+
+func JoinToken() string {
+
+	token := os.Getenv("THORIUM_JOIN_TOKEN")
+	if token != "" {
+		return token
+	}
+
+	checkConfigFile()
+	return config.JoinToken
+}
+
+func Region() string {
+
+	region := os.Getenv("THORIUM_REGION")
+	if region != "" {
+		return region
+	}
 
-		log.Print("config reloaded")
+	checkConfigFile()
+	return config.Region
+}
+
+func Zone() string {
+
+	zone := os.Getenv("THORIUM_ZONE")
+	if zone != "" {
+		return zone
+	}
+
+	checkConfigFile()
+	return config.Zone
+}
+
+func TLSFiles() (string, string, string) {
+
+	return config.TLSCertFile, config.TLSKeyFile, config.TLSCAFile
+}
+
+func LocalServicePort() int {
+
+	return config.LocalServicePort
+}
+
+func HealthPort() int {
+
+	return config.HealthPort
+}
+
+// Validate reports the first setting in the config the host can't run with
+func Validate() error {
+
+	checkConfigFile()
+
+	if config.GameserverBinaryPath == "" {
+		return errors.New("hostconf: GameserverBinaryPath is not set")
 	}
+
+	info, err := os.Stat(config.GameserverBinaryPath)
+	if err != nil {
+		return fmt.Errorf("hostconf: GameserverBinaryPath: %v", err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("hostconf: GameserverBinaryPath %s is not executable", config.GameserverBinaryPath)
+	}
+
+	switch config.SessionCheck {
+	case "", SessionCheckStrict, SessionCheckLenient:
+	default:
+		return fmt.Errorf("hostconf: SessionCheck must be %s or %s, not %q", SessionCheckStrict, SessionCheckLenient, config.SessionCheck)
+	}
+
+	if region := Region(); region != "" && !request.ValidRegion(region) {
+		return fmt.Errorf("hostconf: Region %q is not a region name, such as eu-west", region)
+	}
+
+	if zone := Zone(); zone != "" {
+		if Region() == "" {
+			return errors.New("hostconf: Zone is set without a Region")
+		}
+		if !request.ValidRegion(zone) {
+			return fmt.Errorf("hostconf: Zone %q is not a zone name, such as eu-west-1a", zone)
+		}
+	}
+
+	if config.CharacterCacheSeconds < 0 {
+		return errors.New("hostconf: CharacterCacheSeconds is negative")
+	}
+
+	for _, path := range []string{config.TLSCertFile, config.TLSKeyFile, config.TLSCAFile} {
+		if path == "" {
+			continue
+		}
+
+		_, err = os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("hostconf: %v", err)
+		}
+	}
+
+	return nil
 }
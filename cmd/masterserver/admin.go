@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/auth"
+	thordb "github.com/jaybennett89/thorium-go/database"
+	"github.com/jaybennett89/thorium-go/logging"
+	"github.com/jaybennett89/thorium-go/model"
+	request "github.com/jaybennett89/thorium-go/requests"
+	"github.com/jaybennett89/thorium-go/trace"
+)
+
+// operator api, every route requires an admin token in the Authorization header
+
+type adminIdentity string
+
+var adminLog = logging.New("admin")
+
+func registerAdminRoutes(m *martini.ClassicMartini) {
+
+	m.Group("/admin", func(r martini.Router) {
+
+		r.Get("/machines", handleAdminListMachines)
+		r.Get("/machines/:id", handleAdminGetMachine)
+		r.Post("/machines/:id/cordon", handleAdminSetMachineState(thordb.MachineCordoned))
+		r.Post("/machines/:id/drain", handleAdminSetMachineState(thordb.MachineDraining))
+		r.Post("/machines/:id/uncordon", handleAdminSetMachineState(thordb.MachineActive))
+		r.Post("/machines/:id/approve", handleAdminApproveMachine)
+		r.Post("/machines/:id/revoke", handleAdminRevokeMachine)
+
+		r.Get("/join_tokens", handleAdminListJoinTokens)
+		r.Post("/join_tokens", handleAdminCreateJoinToken)
+		r.Delete("/join_tokens/:id", handleAdminRevokeJoinToken)
+
+		r.Delete("/games/:id", handleAdminEndGame)
+		r.Get("/loading_hosts", handleAdminListLoadingHosts)
+
+		r.Get("/sessions", handleAdminListSessions)
+		r.Delete("/sessions/:uid", handleAdminKickPlayer)
+
+		r.Get("/accounts/:uid/restrictions", handleAdminGetStanding)
+		r.Post("/accounts/:uid/restrictions", handleAdminAddRestriction)
+		r.Delete("/accounts/:uid/restrictions/:id", handleAdminLiftRestriction)
+
+		r.Get("/audit", handleAdminQueryAudit)
+
+		r.Get("/requests/:id", trace.Handler)
+
+		r.Get("/log_level", handleAdminGetLogLevel)
+		r.Put("/log_level", handleAdminSetLogLevel)
+
+	}, requireAdmin)
+}
+
+// requireAdmin only takes the bearer header: admin tokens never travel in a body
+func requireAdmin(res http.ResponseWriter, httpReq *http.Request, c martini.Context) {
+
+	header := httpReq.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		http.Error(res, "Unauthorized", 401)
+		return
+	}
+
+	claims, err := thordb.VerifyToken(strings.TrimPrefix(header, "Bearer "), auth.RoleAdmin)
+	if err == nil {
+		err = claims.Require(auth.RoleAdmin, auth.ScopeAdmin)
+	}
+
+	if err != nil {
+		adminLog.Info("rejected admin request", "path", httpReq.URL.Path, "err", err)
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditAdminAction, Detail: httpReq.Method + " " + httpReq.URL.Path + ": " + err.Error()})
+		http.Error(res, "Unauthorized", 401)
+		return
+	}
+
+	c.Map(adminIdentity(claims.Subject))
+}
+
+func handleAdminListMachines(httpReq *http.Request) (int, string) {
+
+	approval := httpReq.URL.Query().Get("approval")
+	switch approval {
+	case "", thordb.MachinePending, thordb.MachineApproved, thordb.MachineRevoked:
+	default:
+		return 400, "Bad Request"
+	}
+
+	list, err := thordb.AdminListMachines(approval)
+	if err != nil {
+		adminLog.Error("couldn't list machines", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, list)
+}
+
+func handleAdminGetMachine(params martini.Params) (int, string) {
+
+	machineId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	machine, err := thordb.AdminGetMachine(machineId)
+	switch {
+	case err == thordb.ErrMachineNotExist:
+		return 404, "Machine Not Found"
+	case err != nil:
+		adminLog.Error("couldn't read machine", "machine", machineId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, machine)
+}
+
+func handleAdminSetMachineState(state string) martini.Handler {
+
+	return func(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+		machineId, err := strconv.Atoi(params["id"])
+		if err != nil {
+			return 400, "Bad Request"
+		}
+
+		err = thordb.SetMachineState(machineId, state)
+		switch {
+		case err == thordb.ErrMachineNotExist:
+			return 404, "Machine Not Found"
+		case err != nil:
+			adminLog.Error("couldn't set machine state", "machine", machineId, "state", state, "err", err)
+			return 500, "Internal Server Error"
+		}
+
+		adminAudit(httpReq, admin, fmt.Sprintf("set machine %d to %s", machineId, state))
+		return 200, "OK"
+	}
+}
+
+func handleAdminApproveMachine(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	machineId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	err = thordb.ApproveMachine(machineId)
+	switch {
+	case err == thordb.ErrMachineNotExist:
+		return 404, "Machine Not Found"
+	case err == thordb.ErrMachineRevoked:
+		return 409, "Machine Revoked"
+	case err != nil:
+		adminLog.Error("couldn't approve machine", "machine", machineId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("approved machine %d", machineId))
+	return 200, "OK"
+}
+
+func handleAdminRevokeMachine(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	machineId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	err = thordb.RevokeMachine(machineId)
+	switch {
+	case err == thordb.ErrMachineNotExist:
+		return 404, "Machine Not Found"
+	case err != nil:
+		adminLog.Error("couldn't revoke machine", "machine", machineId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("revoked machine %d", machineId))
+	return 200, "OK"
+}
+
+func handleAdminListJoinTokens() (int, string) {
+
+	list, err := thordb.ListJoinTokens()
+	if err != nil {
+		adminLog.Error("couldn't list join tokens", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, list)
+}
+
+func handleAdminCreateJoinToken(httpReq *http.Request, admin adminIdentity) (int, string) {
+
+	var req request.CreateJoinToken
+	err := decode(httpReq, &req)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+
+	token, jt, err := thordb.CreateJoinToken(req.Label, req.MaxUses, ttl, req.AutoApprove, string(admin))
+	switch {
+	case err == thordb.ErrInvalidJoinToken:
+		return 400, "Bad Request"
+	case err != nil:
+		adminLog.Error("couldn't create join token", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("created join token %d %q for %d uses", jt.TokenId, jt.Label, jt.MaxUses))
+	return jsonResponse(201, &request.JoinTokenResponse{Token: token, JoinToken: jt})
+}
+
+func handleAdminRevokeJoinToken(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	tokenId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	err = thordb.RevokeJoinToken(tokenId)
+	switch {
+	case err == thordb.ErrJoinTokenNotExist:
+		return 404, "Join Token Not Found"
+	case err != nil:
+		adminLog.Error("couldn't revoke join token", "token", tokenId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("revoked join token %d", tokenId))
+	return 200, "OK"
+}
+
+func handleAdminEndGame(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	err = thordb.ForceEndGame(gameId)
+	switch {
+	case err == thordb.ErrGameNotExist:
+		return 404, "Game Not Found"
+	case err == thordb.ErrGameEnded:
+		return 409, "Game Already Ended"
+	case err != nil:
+		adminLog.Error("couldn't end game", "game", gameId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("ended game %d", gameId))
+	return 200, "OK"
+}
+
+func handleAdminListLoadingHosts() (int, string) {
+
+	list, err := thordb.AdminListLoadingHosts()
+	if err != nil {
+		adminLog.Error("couldn't list loading hosts", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, list)
+}
+
+func handleAdminListSessions() (int, string) {
+
+	list, err := thordb.AdminListSessions()
+	if err != nil {
+		adminLog.Error("couldn't list sessions", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, list)
+}
+
+func handleAdminKickPlayer(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	uid, err := strconv.Atoi(params["uid"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	found, err := thordb.KickPlayer(uid)
+	if err != nil {
+		adminLog.Error("couldn't kick player", "uid", uid, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	if !found {
+		return 404, "Session Not Found"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("kicked user %d", uid))
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditSessionRevoked, Success: true, Actor: string(admin), UserId: uid, Detail: "kicked"})
+	return 200, "OK"
+}
+
+func handleAdminGetStanding(params martini.Params) (int, string) {
+
+	uid, err := strconv.Atoi(params["uid"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	var resp request.AccountStandingResponse
+
+	resp.Active, err = thordb.GetActiveRestrictions(uid)
+	if err == nil {
+		resp.All, err = thordb.GetRestrictions(uid)
+	}
+	if err == nil {
+		resp.History, err = thordb.GetModerationHistory(uid)
+	}
+	if err != nil {
+		adminLog.Error("couldn't read standing", "uid", uid, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, &resp)
+}
+
+func handleAdminAddRestriction(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	uid, err := strconv.Atoi(params["uid"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	var req request.AddRestriction
+	err = decode(httpReq, &req)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+
+	restriction, err := thordb.AddRestriction(uid, req.Kind, req.Reason, string(admin), duration)
+	switch {
+	case err == thordb.ErrInvalidRestriction:
+		return 400, "Bad Request"
+	case err == thordb.ErrAccountNotExist:
+		return 404, "Account Not Found"
+	case err != nil:
+		adminLog.Error("couldn't add restriction", "uid", uid, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("applied %s restriction %d to user %d: %s", req.Kind, restriction.RestrictionId, uid, req.Reason))
+	if req.Kind == thordb.RestrictBan || req.Kind == thordb.RestrictSuspension {
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditSessionRevoked, Success: true, Actor: string(admin), UserId: uid, Detail: req.Kind})
+	}
+	return jsonResponse(201, restriction)
+}
+
+func handleAdminLiftRestriction(httpReq *http.Request, params martini.Params, admin adminIdentity) (int, string) {
+
+	uid, err := strconv.Atoi(params["uid"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	restrictionId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	// the reason is optional when lifting
+	var req request.LiftRestriction
+	json.NewDecoder(httpReq.Body).Decode(&req)
+
+	err = thordb.LiftRestriction(uid, restrictionId, req.Reason, string(admin))
+	switch {
+	case err == thordb.ErrRestrictionNotExist:
+		return 404, "Restriction Not Found"
+	case err != nil:
+		adminLog.Error("couldn't lift restriction", "restriction", restrictionId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("lifted restriction %d from user %d", restrictionId, uid))
+	return 200, "OK"
+}
+
+func handleAdminGetLogLevel() (int, string) {
+
+	return jsonResponse(200, logLevels())
+}
+
+// levels only change on the replica that takes the request
+func handleAdminSetLogLevel(httpReq *http.Request, admin adminIdentity) (int, string) {
+
+	var req request.SetLogLevel
+	err := decode(httpReq, &req)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	err = logging.ParseLevels(req.Level)
+	if err != nil {
+		return 400, err.Error()
+	}
+
+	for _, component := range req.Reset {
+		logging.ClearComponentLevel(component)
+	}
+
+	adminAudit(httpReq, admin, fmt.Sprintf("set log level %q, reset %v", req.Level, req.Reset))
+	return jsonResponse(200, logLevels())
+}
+
+func logLevels() request.LogLevelResponse {
+
+	level, overrides := logging.Levels()
+
+	resp := request.LogLevelResponse{Level: level.String(), Components: map[string]string{}}
+	for component, l := range overrides {
+		resp.Components[component] = l.String()
+	}
+
+	return resp
+}
+
+func adminAudit(httpReq *http.Request, admin adminIdentity, detail string) {
+
+	adminLog.Info(detail, "admin", admin)
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditAdminAction, Success: true, Actor: string(admin), Detail: detail})
+}
+
+func jsonResponse(status int, v interface{}) (int, string) {
+
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		log.Error("couldn't encode response", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return status, string(jsonBytes)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	thordb "github.com/jaybennett89/thorium-go/database"
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/ratelimit"
+)
+
+// audit records a security event with the caller's address and user agent.
+// a failure to record is logged but never fails the request.
+func audit(httpReq *http.Request, ev model.AuditEvent) {
+
+	ev.RemoteAddress = ratelimit.ClientIP(httpReq)
+	ev.UserAgent = httpReq.UserAgent()
+
+	err := thordb.RecordAudit(&ev)
+	if err != nil {
+		log.Error("unable to record audit event", "type", ev.Type, "err", err)
+	}
+}
+
+// GET /admin/audit?type=&username=&uid=&ip=&since=&until=&limit=
+// since and until are RFC 3339 timestamps
+func handleAdminQueryAudit(httpReq *http.Request) (int, string) {
+
+	q := httpReq.URL.Query()
+
+	filter := thordb.AuditFilter{
+		Type:          q.Get("type"),
+		Username:      q.Get("username"),
+		RemoteAddress: q.Get("ip"),
+	}
+
+	var err error
+
+	if v := q.Get("uid"); v != "" {
+		filter.UserId, err = strconv.Atoi(v)
+		if err != nil {
+			return 400, "Bad Request"
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			return 400, "Bad Request"
+		}
+	}
+
+	if v := q.Get("since"); v != "" {
+		filter.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return 400, "Bad Request"
+		}
+	}
+
+	if v := q.Get("until"); v != "" {
+		filter.Until, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return 400, "Bad Request"
+		}
+	}
+
+	list, err := thordb.QueryAudit(filter)
+	if err != nil {
+		adminLog.Error("couldn't query audit log", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, list)
+}
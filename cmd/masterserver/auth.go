@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/auth"
+	thordb "github.com/jaybennett89/thorium-go/database"
+)
+
+// route authentication. requireRole verifies the caller's token before the
+// handler runs and maps its *auth.Claims for the handler to use.
+
+// tokenFields are the body fields clients have always sent their token in
+type tokenFields struct {
+	SessionKey string `json:"sessionKey"`
+	MachineKey string `json:"machineKey"`
+
+	// heartbeats named the key machineToken before the field was renamed,
+	// kept for hosts that haven't been upgraded
+	MachineToken string `json:"machineToken"`
+}
+
+// requireRole only lets through callers holding a live token of role. scope
+// may be empty when the role alone is enough.
+func requireRole(role string, scope string) martini.Handler {
+
+	return requireToken(role, scope, thordb.VerifyToken)
+}
+
+// requireShutdownToken is requireRole for game servers reporting they
+// stopped, which may retry after their game has ended
+func requireShutdownToken(scope string) martini.Handler {
+
+	return requireToken(auth.RoleGameServer, scope, thordb.VerifyShutdownToken)
+}
+
+func requireToken(role string, scope string, verify func(string, string) (*auth.Claims, error)) martini.Handler {
+
+	return func(res http.ResponseWriter, httpReq *http.Request, c martini.Context) {
+
+		tokenStr := requestToken(httpReq, role)
+		if tokenStr == "" {
+			http.Error(res, "Unauthorized", 401)
+			return
+		}
+
+		claims, err := verify(tokenStr, role)
+		if err == nil {
+			err = claims.Require(role, scope)
+		}
+
+		switch {
+		case err == nil:
+		case err == thordb.ErrAccountBanned || err == thordb.ErrAccountSuspended || err == auth.ErrMissingScope:
+			http.Error(res, "Forbidden", 403)
+			return
+		case thordb.TokenRefused(err):
+			log.Info("rejected token", "role", role, "method", httpReq.Method, "path", httpReq.URL.Path, "err", err)
+			http.Error(res, "Unauthorized", 401)
+			return
+		default:
+			// the token may well be good, so the caller must not drop it
+			log.Error("couldn't check token", "role", role, "method", httpReq.Method, "path", httpReq.URL.Path, "err", err)
+			http.Error(res, "Service Unavailable", 503)
+			return
+		}
+
+		c.Map(claims)
+	}
+}
+
+// requestToken reads the bearer token, falling back to the token field of a
+// json body that a caller of role uses. game server requests carry the
+// player's session key too, so the field has to be picked by role. the body is
+// restored so the handler can still decode it.
+func requestToken(httpReq *http.Request, role string) string {
+
+	header := httpReq.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+
+	body, err := peekBody(httpReq)
+	if err != nil || body == nil {
+		return ""
+	}
+
+	var fields tokenFields
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+
+	switch {
+	case role == auth.RolePlayer:
+		return fields.SessionKey
+	case fields.MachineKey != "":
+		return fields.MachineKey
+	default:
+		return fields.MachineToken
+	}
+}
+
+// peekBody reads the request body and puts it back for the next handler
+func peekBody(httpReq *http.Request) ([]byte, error) {
+
+	if httpReq.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(httpReq.Body)
+	httpReq.Body.Close()
+	httpReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, err
+}
+
+// GET /.well-known/jwks.json
+// the public keys tokens may be signed with, for hosts verifying them offline
+func handleGetJWKS(res http.ResponseWriter) (int, string) {
+
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "public, max-age=300")
+
+	jsonBytes, err := json.Marshal(thordb.JWKS())
+	if err != nil {
+		log.Error("couldn't encode jwks", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return 200, string(jsonBytes)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/go-martini/martini"
+	thordb "github.com/jaybennett89/thorium-go/database"
+	"github.com/jaybennett89/thorium-go/health"
+)
+
+var errDraining = errors.New("master: draining for shutdown")
+
+// /healthz answers while the process runs. /readyz answers 200 only while the
+// replica should be sent traffic: it isn't draining and it can reach
+// postgres, redis and a key to sign tokens with.
+func registerHealth(m *martini.ClassicMartini) {
+
+	health.Register("serving", func() (string, error) {
+		if !isReady() {
+			return "", errDraining
+		}
+		return "", nil
+	})
+	health.Register("postgres", thordb.CheckPostgres)
+	health.Register("redis", thordb.CheckRedis)
+	health.Register("signing-key", thordb.CheckSigningKey)
+
+	m.Get("/healthz", health.LiveHandler().ServeHTTP)
+	m.Get("/readyz", health.Handler().ServeHTTP)
+}
@@ -2,112 +2,245 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 import "github.com/go-martini/martini"
 import (
+	"github.com/jaybennett89/thorium-go/auth"
 	thordb "github.com/jaybennett89/thorium-go/database"
+	"github.com/jaybennett89/thorium-go/leader"
+	"github.com/jaybennett89/thorium-go/logging"
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/ratelimit"
 	request "github.com/jaybennett89/thorium-go/requests"
+	"github.com/jaybennett89/thorium-go/trace"
+	"github.com/jaybennett89/thorium-go/validation"
 )
 
+// every replica is stateless and serves requests; only the lease holder
+// runs the singleton background jobs
+const jobsLease string = "master-jobs"
+const jobsLeaseTTL time.Duration = 15 * time.Second
+const reapInterval time.Duration = 30 * time.Second
+const machineStaleAge time.Duration = 120 * time.Second
+const keyRotationCheck time.Duration = 10 * time.Minute
+
+// a game that isn't running this long after it was requested has failed
+const gameStartTimeout time.Duration = 5 * time.Minute
+
+var log = logging.New("master")
+
+var elector *leader.Elector
+
+// authentication throttling, shared across replicas through redis
+var loginLimiter *ratelimit.Limiter
+var registerLimiter *ratelimit.Limiter
+var recoveryLimiter *ratelimit.Limiter
+var loginLockout *ratelimit.Lockout
+
 func main() {
-	fmt.Println("hello world")
+
+	// the logging package redacts everything it writes, so nothing logged by
+	// this process may leak a credential
+	err := logging.Configure()
+	if err != nil {
+		log.Fatal("bad logging configuration", "err", err)
+	}
+	logging.CaptureStdLog(logging.New("log"))
+	trace.Process = "master"
+
+	elector = leader.NewElector(thordb.LeaseStore(), jobsLease, leader.NodeName(), jobsLeaseTTL)
+	elector.Every("reap-machines", reapInterval, func(token int64) error {
+		_, err := thordb.ReapStaleMachines(jobsLease, token, machineStaleAge)
+		return err
+	})
+
+	elector.Every("fail-stuck-games", reapInterval, func(token int64) error {
+		_, err := thordb.FailStuckGames(jobsLease, token, gameStartTimeout)
+		return err
+	})
+
+	thordb.SeatReservationTTL = envDuration("THORIUM_SEAT_RESERVATION_TTL", thordb.SeatReservationTTL)
+
+	elector.Every("expire-seat-reservations", reapInterval, func(token int64) error {
+		_, err := thordb.ExpireSeatReservations(jobsLease, token)
+		return err
+	})
+
+	keyRotation := envDuration("THORIUM_KEY_ROTATION", thordb.DefaultKeyRotation)
+
+	elector.Every("rotate-keys", keyRotationCheck, func(token int64) error {
+		rotated, err := thordb.RotateSigningKeys(jobsLease, token, keyRotation)
+		if rotated {
+			log.Info("rotated token signing key")
+		}
+		return err
+	})
+	elector.Start()
+
+	// every replica picks up keys the leader rotated in
+	goBackground(func() {
+		ticker := time.NewTicker(thordb.KeyRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopping:
+				return
+			case <-ticker.C:
+				err := thordb.RefreshKeyring()
+				if err != nil {
+					log.Error("unable to refresh signing keys", "err", err)
+				}
+			}
+		}
+	})
+
+	limits := thordb.RateLimitStore()
+	ratelimit.TrustForwardedFor = os.Getenv("THORIUM_TRUST_PROXY") == "1"
+	loginLimiter = ratelimit.NewLimiter(limits, "login", 20, time.Minute)
+	registerLimiter = ratelimit.NewLimiter(limits, "register", 10, time.Hour)
+	recoveryLimiter = ratelimit.NewLimiter(limits, "recovery", 10, time.Hour)
+	loginLockout = ratelimit.NewLockout(limits, "login", 5, 30*time.Second, time.Hour, 24*time.Hour)
+
+	notifier, err = newNotifier()
+	if err != nil {
+		log.Fatal("couldn't set up notifications", "err", err)
+	}
+
+	tlsConfig, err := setupTLS()
+	if err != nil {
+		log.Fatal("couldn't set up tls", "err", err)
+	}
 
 	m := martini.Classic()
+	m.Map(logging.New("http").StdLogger(logging.Info))
+	registerMetrics(m)
+	m.Use(trace.Middleware(log))
 
 	// status
 	m.Get("/", handleGetStatusRequest)
 	m.Get("/status", handleGetStatusRequest)
+	m.Get("/.well-known/jwks.json", handleGetJWKS)
+	registerHealth(m)
 
-	// client
-	m.Post("/clients/login", handleClientLogin)
-	m.Post("/clients/register", handleClientRegister)
-	m.Post("/clients/disconnect", handleClientDisconnect)
+	// game api
+	registerVersions(m)
 
-	// characters
-	m.Post("/characters/new", handleCreateCharacter)
-	m.Post("/characters/select", handleSelectCharacter)
-	m.Get("/characters/:id/profile", handleGetCharProfile)
-	m.Get("/characters", handleGetCharacter)
-	m.Post("/characters", handleUpdateCharacter)
+	// operators
+	registerAdminRoutes(m)
 
-	// games
-	m.Post("/games/register_server", handleRegisterServer)
-	m.Post("/games/player_connect", handlePlayerConnect)
-	m.Post("/games/player_disconnect", handlePlayerDisconnect)
-	m.Post("/games/shutdown_server", handleShutdownServer)
+	checkSpec(m.Router)
 
-	m.Post("/games/server_status", handleGameServerStatus)
+	serve(&http.Server{Addr: ":6960", Handler: m, TLSConfig: tlsConfig})
+}
 
-	m.Post("/games", handleNewGameRequest)
+func handleGetStatusRequest(httpReq *http.Request) (int, string) {
 
-	m.Get("/games", handleGetServerList)
-	m.Get("/games/:id", handleGetGameInfo)
-	m.Get("/games/:id/server_info", handleGetServerInfo)
-	m.Post("/games/join_queue", handleClientJoinQueue)
+	status := request.StatusResponse{Status: "OK", Node: elector.Node}
 
-	// machines
-	m.Post("/machines/register", handleRegisterMachine)
-	m.Post("/machines/status", handleMachineHeartbeat)
-	m.Post("/machines/:id/disconnect", handleUnregisterMachine)
-	m.Delete("/machines/:id", handleUnregisterMachine)
+	code := 200
+	if !isReady() {
+		status.Status = "draining"
+		code = 503
+	}
 
-	m.RunOnAddr(":6960")
-}
+	var err error
+	status.Leader, status.FencingToken, err = elector.Leader()
+	if err != nil {
+		log.Warn("unable to read leader lease", "err", err)
+	}
 
-func handleGetStatusRequest(httpReq *http.Request) (int, string) {
-	return 200, "OK"
+	jsonBytes, err := json.Marshal(&status)
+	if err != nil {
+		log.Error("couldn't encode status", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return code, string(jsonBytes)
 }
 
-func handleClientLogin(httpReq *http.Request) (int, string) {
+func handleClientLogin(httpReq *http.Request, res http.ResponseWriter) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
 	var req request.Authentication
-	err := decoder.Decode(&req)
+	err := decode(httpReq, &req)
 	if err != nil {
-		log.Print("bad json request", httpReq.Body)
-		return 400, "Bad Request"
+		log.Info("bad login request", "err", err)
+		return badRequest(err)
 	}
 
 	var username string
-	var password string
-	username, password, err = sanitize(req.Username, req.Password)
+	username, err = sanitize(req.Username)
 	if err != nil {
-		log.Print("Error sanitizing authentication request", req.Username, req.Password)
+		log.Info("couldn't sanitize login request", "username", req.Username, "err", err)
 		return 400, "Bad Request"
 	}
 
+	// lock out both the caller and the targeted account after repeated failures
+	ipKey := "ip/" + ratelimit.ClientIP(httpReq)
+	userKey := "user/" + validation.Key(username)
+
+	for _, key := range []string{ipKey, userKey} {
+		locked, err := loginLockout.Check(key)
+		if err != nil {
+			log.Error("couldn't check login lockout", "key", key, "err", err)
+		} else if locked > 0 {
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditLogin, Username: username, Detail: "locked out"})
+			loginResults.Inc("locked")
+			ratelimit.SetRetryAfter(res, locked)
+			return 429, "Too Many Requests"
+		}
+	}
+
 	var charIDs []int
 	var token string
-	token, charIDs, err = thordb.LoginAccount(username, password)
+	token, charIDs, err = thordb.LoginAccount(username, req.Password)
 	if err != nil {
-		log.Print(err)
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditLogin, Username: username, Detail: err.Error()})
+		loginResults.Inc("failure")
 		switch err.Error() {
-		case "thordb: does not exist":
-			log.Print(fmt.Sprintf("thordb: failed login attempt: %s//%s", username, password))
-			return 400, "Bad Request"
-		case "thordb: invalid password":
-			log.Print(fmt.Sprintf("thordb: failed login attempt: %s//%s", username, password))
+		case "thordb: invalid credentials":
+			log.Info("failed login attempt", "username", username)
+			for _, key := range []string{ipKey, userKey} {
+				_, err = loginLockout.Fail(key)
+				if err != nil {
+					log.Error("couldn't record login failure", "key", key, "err", err)
+				}
+			}
 			return 400, "Bad Request"
 		case "thordb: already logged in":
-			log.Printf("thordb: failed login attempt (already logged in): %s//%s", username, password)
+			log.Info("failed login attempt, already logged in", "username", username)
 			return 400, "Bad Request"
+		case "thordb: account banned":
+			return 403, "Account Banned"
+		case "thordb: account suspended":
+			return 403, "Account Suspended"
 		default:
+			log.Error("login failed", "username", username, "err", err)
 			return 500, "Internal Server Error"
 		}
 	}
 
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditLogin, Success: true, Username: username})
+	loginResults.Inc("success")
+
+	err = loginLockout.Succeed(userKey)
+	if err != nil {
+		log.Error("couldn't clear login lockout", "username", username, "err", err)
+	}
+
 	var resp request.LoginResponse
 	resp.SessionKey = token
 	resp.CharacterIDs = charIDs
 	var jsonBytes []byte
 	jsonBytes, err = json.Marshal(&resp)
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't encode login response", "err", err)
 		return 500, "Internal Server Error"
 	}
 	return 200, string(jsonBytes)
@@ -116,82 +249,81 @@ func handleClientLogin(httpReq *http.Request) (int, string) {
 func handleClientRegister(httpReq *http.Request) (int, string) {
 	//using authentication struct for now because i haven't added the token yet
 	var req request.Authentication
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	err := decode(httpReq, &req)
 	if err != nil {
-		fmt.Println("error decoding register account request (authentication)")
-		return 500, "Internal Server Error"
+		log.Info("bad register request", "err", err)
+		return badRequest(err)
 	}
 
 	var username string
-	var password string
-
-	username, password, err = sanitize(req.Username, req.Password)
+	username, err = sanitize(req.Username)
 	if err != nil {
-		log.Print("Error sanitizing authentication request", req.Username, req.Password)
+		log.Info("couldn't sanitize register request", "username", req.Username, "err", err)
 		return 400, "Bad Request"
 	}
 
-	token, charIds, err := thordb.RegisterAccount(username, password)
+	token, charIds, err := thordb.RegisterAccount(username, req.Password, req.Email)
 	if err != nil {
-		log.Print(err)
+		log.Info("registration failed", "username", username, "err", err)
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditRegister, Username: username, Detail: err.Error()})
+		if validation.IsNameError(err) {
+			return 400, err.Error()
+		}
 		switch err.Error() {
 		case "thordb: already in use":
 			return 400, "Bad Request"
+		case "thordb: invalid email":
+			return 400, err.Error()
 		default:
 			return 500, "Internal Server Error"
 		}
 	}
 
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditRegister, Success: true, Username: username})
+
 	var resp request.LoginResponse
 	resp.SessionKey = token
 	resp.CharacterIDs = charIds
 	jsonBytes, err := json.Marshal(&resp)
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't encode register response", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, string(jsonBytes)
 }
 
-func handleClientDisconnect(httpReq *http.Request) (int, string) {
+func handleClientDisconnect(httpReq *http.Request, claims *auth.Claims) (int, string) {
 
-	var req request.Disconnect
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	uid := claims.UserId
+	err := thordb.Disconnect(uid)
 	if err != nil {
-		fmt.Println("error decoding client disconnect request")
-		return 400, "Bad Request"
-	}
-
-	err = thordb.Disconnect(req.SessionKey)
-	if err != nil {
-		log.Print("thordb couldnt disconnect, something went wrong")
-		log.Print(err)
+		log.Error("couldn't disconnect client", "uid", uid, "err", err)
 		return 500, "Internal Server Error"
 	}
 
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditSessionRevoked, Success: true, UserId: uid, Detail: "logout"})
+
 	return 200, "OK"
 }
 
-func handleCreateCharacter(httpReq *http.Request) (int, string) {
-	var req request.CreateCharacter
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+func handleCreateCharacter(httpReq *http.Request, claims *auth.Claims) (int, string) {
+	var req request.NewCharacter
+	err := decode(httpReq, &req)
 	if err != nil {
-		log.Print("character create req json decoding error %s", httpReq.Body)
-		return 400, "Bad Request"
+		log.Info("bad character create request", "err", err)
+		return badRequest(err)
 	}
 
-	characterId, err := thordb.CreateCharacter(req.SessionKey, req.Name, req.ClassId)
+	characterId, err := thordb.CreateCharacter(claims.UserId, req.Name, req.ClassId)
 	if err != nil {
-		log.Print(err)
+		log.Info("couldn't create character", "uid", claims.UserId, "err", err)
+		if validation.IsNameError(err) {
+			return 400, err.Error()
+		}
 		switch err.Error() {
 		case "thordb: already in use":
 			return 400, "Bad Request"
-		case "token contains an invalid number of segments":
-			return 400, "Bad Request"
 		default:
 			return 500, "Internal Server Error"
 		}
@@ -203,26 +335,23 @@ func handleCreateCharacter(httpReq *http.Request) (int, string) {
 	var jsonBytes []byte
 	jsonBytes, err = json.Marshal(&resp)
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't encode character response", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, string(jsonBytes)
 }
 
-func handleSelectCharacter(httpReq *http.Request) (int, string) {
+func handleSelectCharacter(params martini.Params, claims *auth.Claims) (int, string) {
 
-	var req request.SelectCharacter
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	characterId, err := strconv.Atoi(params["id"])
 	if err != nil {
-		log.Print("character select req json decoding error %s", httpReq.Body)
 		return 400, "Bad Request"
 	}
 
-	character, err := thordb.SelectCharacter(req.SessionKey, req.CharacterId)
+	character, err := thordb.SelectCharacter(claims.UserId, characterId)
 	if err != nil {
-		fmt.Println(err)
+		log.Error("couldn't select character", "uid", claims.UserId, "character", characterId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
@@ -234,19 +363,16 @@ func handleSelectCharacter(httpReq *http.Request) (int, string) {
 	return 200, string(json)
 }
 
-func handleGetCharacter(httpReq *http.Request) (int, string) {
+func handleGetCharacter(params martini.Params) (int, string) {
 
-	var req request.GetCharacter
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	characterId, err := strconv.Atoi(params["id"])
 	if err != nil {
-		log.Print("character select req json decoding error %s", httpReq.Body)
 		return 400, "Bad Request"
 	}
 
-	character, err := thordb.GetCharacter(req.MachineKey, req.CharacterId)
+	character, err := thordb.GetCharacter(characterId)
 	if err != nil {
-		fmt.Println(err)
+		log.Error("couldn't get character", "character", characterId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
@@ -258,95 +384,277 @@ func handleGetCharacter(httpReq *http.Request) (int, string) {
 	return 200, string(json)
 }
 
-func handleUpdateCharacter(httpReq *http.Request) (int, string) {
+func handleUpdateCharacter(httpReq *http.Request, params martini.Params, claims *auth.Claims) (int, string) {
 
-	var req request.UpdateCharacter
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	var req request.CharacterSnapshot
+	err := decode(httpReq, &req)
 	if err != nil {
-		log.Print("character select req json decoding error %s", httpReq.Body)
+		log.Info("bad update character request", "err", err)
+		return badRequest(err)
+	}
+
+	if strconv.Itoa(req.Snapshot.CharacterId) != params["id"] {
 		return 400, "Bad Request"
 	}
 
-	err = thordb.UpdateCharacter(req.MachineKey, req.Snapshot)
-	if err != nil {
-		fmt.Println(err)
+	err = thordb.UpdateCharacter(claims.GameId, req.Snapshot)
+	switch {
+	case err == thordb.ErrNotCurrentGame:
+		log.Warn("game server tried to save a character of another game", "game", claims.GameId, "character", req.Snapshot.CharacterId)
+		return 403, "Forbidden"
+	case err != nil:
+		log.Error("couldn't update character", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, "OK"
 }
 
-func handleGetCharProfile(httpReq *http.Request) (int, string) {
-	return 500, "Not Implemented"
+func handleGetCharProfile(params martini.Params) (int, string) {
+
+	characterId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	profile, err := thordb.GetCharacterProfile(characterId)
+	switch {
+	case err == thordb.ErrCharacterNotExist:
+		return 404, "Character Not Found"
+	case err != nil:
+		log.Error("couldn't read character profile", "character", characterId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, profile)
 }
 
-func handlePlayerConnect(httpReq *http.Request) (int, string) {
+// GET /v2/characters/:id/matches
+func handleListCharacterMatches(httpReq *http.Request, params martini.Params) (int, string) {
 
-	var req request.PlayerConnect
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	characterId, err := strconv.Atoi(params["id"])
 	if err != nil {
-		log.Print("join game req json decoding error %s", httpReq.Body)
 		return 400, "Bad Request"
 	}
 
-	character, err := thordb.PlayerConnect(req.GameId, req.MachineKey, req.SessionKey, req.CharacterId)
+	page, err := request.ParseMatchPage(httpReq.URL.Query())
 	if err != nil {
-		fmt.Println(err)
+		return badRequest(err)
+	}
+
+	matches, next, err := thordb.ListCharacterMatches(characterId, page)
+	switch {
+	case err == thordb.ErrCharacterNotExist:
+		return 404, "Character Not Found"
+	case err == thordb.ErrBadCursor:
+		return 400, "Bad Cursor"
+	case err != nil:
+		log.Error("couldn't list character matches", "character", characterId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
-	resp := request.PlayerConnectResponse{Character: character}
+	return jsonResponse(200, &request.MatchHistory{Matches: matches, NextCursor: next})
+}
+
+func handlePlayerConnect(httpReq *http.Request, params martini.Params, claims *auth.Claims, reqLog *logging.Logger) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	var req request.PlayerJoin
+	err = decode(httpReq, &req)
+	if err != nil {
+		reqLog.Info("bad player connect request", "err", err)
+		return badRequest(err)
+	}
+
+	if gameId != claims.GameId {
+		return 403, "Forbidden"
+	}
+
+	character, restrictions, err := thordb.PlayerConnect(gameId, claims.MachineId, req.SessionKey, req.CharacterId, req.JoinTicket)
+	if err != nil {
+		reqLog.Info("player connect refused", "game", gameId, "character", req.CharacterId, "err", err)
+		switch err {
+		case thordb.ErrInvalidSessionKey:
+			return 401, "Invalid Session"
+		case thordb.ErrInvalidJoinTicket:
+			return 400, "Invalid Ticket"
+		case thordb.ErrReservationExpired:
+			return 409, "Reservation Expired"
+		case thordb.ErrGameFull:
+			return 409, "Game Full"
+		case thordb.ErrMachineDraining:
+			return 503, "Machine Draining"
+		case thordb.ErrAccountBanned, thordb.ErrAccountSuspended, thordb.ErrMatchmakingRestricted:
+			return 403, "Forbidden"
+		default:
+			return 500, "Internal Server Error"
+		}
+	}
+
+	resp := request.PlayerConnectResponse{Character: character, Restrictions: restrictions}
 
 	bytes, err := json.Marshal(&resp)
 	if err != nil {
 
-		log.Print(err)
+		reqLog.Error("couldn't encode player connect response", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, string(bytes)
 }
 
-func handlePlayerDisconnect(httpReq *http.Request) (int, string) {
+func handlePlayerDisconnect(httpReq *http.Request, params martini.Params, claims *auth.Claims, reqLog *logging.Logger) (int, string) {
 
-	var req request.PlayerDisconnect
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	gameId, err := strconv.Atoi(params["id"])
 	if err != nil {
-		log.Print("join game req json decoding error %s", httpReq.Body)
 		return 400, "Bad Request"
 	}
 
-	err = thordb.PlayerDisconnect(req.MachineKey, req.GameId, req.Snapshot)
+	var req request.PlayerLeave
+	err = decode(httpReq, &req)
 	if err != nil {
-		fmt.Println(err)
+		reqLog.Info("bad player disconnect request", "err", err)
+		return badRequest(err)
+	}
+
+	if strconv.Itoa(req.Snapshot.CharacterId) != params["characterId"] {
+		return 400, "Bad Request"
+	}
+
+	if gameId != claims.GameId {
+		return 403, "Forbidden"
+	}
+
+	err = thordb.PlayerDisconnect(gameId, req.Snapshot)
+	switch {
+	case err == thordb.ErrNotCurrentGame:
+		reqLog.Warn("game server tried to disconnect a character of another game", "game", gameId, "character", req.Snapshot.CharacterId)
+		return 403, "Forbidden"
+	case err != nil:
+		reqLog.Error("couldn't disconnect player", "game", gameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, "OK"
 }
 
-func handleShutdownServer(httpReq *http.Request) (int, string) {
+func handleShutdownServer(params martini.Params, claims *auth.Claims) (int, string) {
 
-	var req request.ShutdownServer
-	decoder := json.NewDecoder(httpReq.Body)
-	err := decoder.Decode(&req)
+	gameId, err := strconv.Atoi(params["id"])
 	if err != nil {
-		log.Print("shutdown server req json decoding error %s", httpReq.Body)
 		return 400, "Bad Request"
 	}
 
-	err = thordb.ShutdownServer(req.MachineKey, req.GameId)
+	if gameId != claims.GameId {
+		return 403, "Forbidden"
+	}
+
+	err = thordb.ShutdownServer(gameId)
+	switch {
+	case err == thordb.ErrGameNotExist:
+		return 404, "Game Not Found"
+	case err == thordb.ErrGameEnded:
+		// a retry of a shutdown that went through
+		return 200, "OK"
+	case err == thordb.ErrGameState:
+		return 409, "Game Not Started"
+	case err != nil:
+		log.Error("couldn't shut down server", "game", gameId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return 200, "OK"
+}
+
+// POST /v2/games/:id/results, once per game
+func handleReportResults(httpReq *http.Request, params martini.Params, claims *auth.Claims, reqLog *logging.Logger) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	if gameId != claims.GameId {
+		return 403, "Forbidden"
+	}
+
+	var req request.MatchResults
+	err = decode(httpReq, &req)
+	if err == nil {
+		err = req.Check()
+	}
 	if err != nil {
-		fmt.Println(err)
+		reqLog.Info("bad match results", "game", gameId, "err", err)
+		return badRequest(err)
+	}
+
+	err = thordb.RecordMatchResults(gameId, &req)
+	switch {
+	case err == thordb.ErrGameNotExist:
+		return 404, "Game Not Found"
+	case err == thordb.ErrCharacterNotExist:
+		return 400, "Unknown Character"
+	case err == thordb.ErrCharacterNotInGame:
+		return 400, "Character Not In Game"
+	case err == thordb.ErrResultsReported:
+		return 409, "Results Already Reported"
+	case err == thordb.ErrGameEnded:
+		return 410, "Game Ended"
+	case err == thordb.ErrGameState:
+		return 409, "Game Not Started"
+	case err != nil:
+		reqLog.Error("couldn't record match results", "game", gameId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
+	reqLog.Info("recorded match results", "game", gameId, "players", len(req.Players))
 	return 200, "OK"
 }
 
+// POST /v2/games/:id/join, and the v1 /games/join with the game in the body
+func handleJoinGame(httpReq *http.Request, params martini.Params, claims *auth.Claims, reqLog *logging.Logger) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	var req request.GameJoin
+	err = decode(httpReq, &req)
+	if err != nil {
+		reqLog.Info("bad join game request", "err", err)
+		return badRequest(err)
+	}
+
+	seat, err := thordb.ReserveSeat(claims.UserId, req.CharacterId, gameId)
+	switch {
+	case err == thordb.ErrGameNotExist:
+		return 404, "Game Not Found"
+	case err == thordb.ErrCharacterNotExist:
+		return 404, "Character Not Found"
+	case err == thordb.ErrMatchmakingRestricted:
+		return 403, "Forbidden"
+	case err == thordb.ErrGameFull:
+		return 409, "Game Full"
+	case err == thordb.ErrGameState:
+		return 409, "Game Not Started"
+	case err == thordb.ErrGameEnded:
+		return 410, "Game Ended"
+	case err == thordb.ErrMachineDraining:
+		return 503, "Machine Draining"
+	case err != nil:
+		reqLog.Error("couldn't reserve a seat", "game", gameId, "character", req.CharacterId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	reqLog.Info("reserved a seat", "game", gameId, "character", req.CharacterId, "expires", seat.ExpiresAt)
+	return jsonResponse(200, seat)
+}
+
 func handleClientJoinQueue(httpReq *http.Request) (int, string) {
 	return 500, "Not Implemented"
 }
@@ -359,80 +667,162 @@ func handleGetServerList(httpReq *http.Request) (int, string) {
 
 	list, err := thordb.GetGamesList()
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't list games", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	bytes, err := json.Marshal(list)
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't encode game list", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, string(bytes)
 }
 
+// GET /v2/games/:id, in any state
+func handleGetGame(params martini.Params) (int, string) {
+
+	gameId, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+
+	game, err := thordb.GetGame(gameId)
+	switch {
+	case err == thordb.ErrGameNotExist:
+		return 404, "Game Not Found"
+	case err != nil:
+		log.Error("couldn't read game", "game", gameId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, game)
+}
+
+// GET /v2/games
+func handleListGames(httpReq *http.Request) (int, string) {
+
+	filter, err := request.ParseGameFilter(httpReq.URL.Query())
+	if err != nil {
+		return badRequest(err)
+	}
+
+	var list request.GameList
+	list.Games, list.NextCursor, err = thordb.ListGames(filter)
+	if err == thordb.ErrBadCursor {
+		return 400, "cursor is invalid"
+	} else if err != nil {
+		log.Error("couldn't list games", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, &list)
+}
+
+func handleListRegions() (int, string) {
+
+	regions, err := thordb.ListRegions()
+	if err != nil {
+		log.Error("couldn't list regions", "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	return jsonResponse(200, regions)
+}
+
 func handleGetGameInfo(httpReq *http.Request) (int, string) {
 	return 500, "Not Implemented"
 }
 
 func handleRegisterMachine(httpReq *http.Request) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
 	var req request.RegisterMachine
-	err := decoder.Decode(&req)
+	err := decode(httpReq, &req)
+	if err == nil {
+		err = req.Check()
+	}
 	if err != nil {
-		logerr("Error decoding machine register request", err)
-		return 500, "Internal Server Error"
+		log.Info("bad machine register request", "err", err)
+		return badRequest(err)
 	}
 
-	if req.Port == 0 {
-		fmt.Println("No Port Given")
-		return 400, "Bad Request"
-	} else {
-		fmt.Println("register port = ", req.Port)
+	machineIp := strings.Split(httpReq.RemoteAddr, ":")[0]
+
+	var response request.MachineRegisterResponse
+
+	// a host that lost its session renews in place and keeps its games
+	if req.MachineKey != "" {
+
+		response.MachineId, response.MachineKey, response.Approval, response.GameTokens, err = thordb.RenewMachine(req.MachineKey, machineIp, req.Port, req.Region, req.Zone, req.Games)
+		switch err {
+		case nil:
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Success: true, MachineId: response.MachineId, Detail: fmt.Sprintf("renewed, service port %d, %d games", req.Port, len(response.GameTokens))})
+		case thordb.ErrMachineNotExist:
+			// reaped, register it as a new machine below
+		case thordb.ErrInvalidMachineKey:
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Detail: "renew with stale machine key"})
+			return 401, "Invalid Machine Key"
+		case thordb.ErrMachineRevoked:
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Detail: "renew of revoked machine"})
+			return 403, "Machine Revoked"
+		default:
+			log.Error("couldn't renew machine", "err", err)
+			return 500, "Internal Server Error"
+		}
 	}
 
-	machineIp := strings.Split(httpReq.RemoteAddr, ":")[0]
+	if response.MachineKey == "" {
 
-	var machineId int
-	var machineKey string
-	machineId, machineKey, err = thordb.RegisterMachine(machineIp, req.Port)
-	if err != nil {
-		logerr("error registering machine", err)
-		return 500, "Internal Server Error"
+		if req.JoinToken == "" {
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Detail: "missing join token"})
+			return 401, "Join Token Required"
+		}
+
+		response.MachineId, response.MachineKey, response.Approval, err = thordb.RegisterMachine(machineIp, req.Port, req.Region, req.Zone, req.JoinToken)
+		switch {
+		case err == thordb.ErrInvalidJoinToken:
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Detail: "invalid join token"})
+			return 401, "Invalid Join Token"
+		case err != nil:
+			log.Error("couldn't register machine", "addr", machineIp, "port", req.Port, "err", err)
+			audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Detail: err.Error()})
+			return 500, "Internal Server Error"
+		}
+
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditMachineRegistered, Success: true, MachineId: response.MachineId, Detail: fmt.Sprintf("service port %d, %s", req.Port, response.Approval)})
 	}
-	var response request.MachineRegisterResponse
-	response.MachineId = machineId
-	response.MachineKey = machineKey
+
+	log.Info("machine registered", "machine", response.MachineId, "addr", machineIp, "port", req.Port, "region", req.Region, "zone", req.Zone, "approval", response.Approval)
 
 	var jsonBytes []byte
 	jsonBytes, err = json.Marshal(&response)
 	if err != nil {
-		log.Print("error encoding register machine response\n", err)
+		log.Error("couldn't encode register machine response", "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, string(jsonBytes)
 }
 
-func handleUnregisterMachine(httpReq *http.Request, params martini.Params) (int, string) {
+func handleUnregisterMachine(params martini.Params, claims *auth.Claims) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
-	var req request.UnregisterMachine
-	err := decoder.Decode(&req)
+	machineId, err := strconv.Atoi(params["id"])
 	if err != nil {
-		logerr("Error decoding machine unregister request", err)
 		return 400, "Bad Request"
 	}
 
-	success, err := thordb.UnregisterMachine(req.MachineKey)
+	if machineId != claims.MachineId {
+		return 403, "Forbidden"
+	}
+
+	success, err := thordb.UnregisterMachine(machineId)
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't unregister machine", "machine", machineId, "err", err)
 		return 500, "Internal Server Error"
 	} else if !success {
-		logerr("unable to remove machine registry", err)
-		return 400, "Bad Request"
+		log.Warn("unregistered machine was already gone", "machine", machineId)
+		return 404, "Machine Not Found"
 	}
 
 	return 200, "OK"
@@ -440,16 +830,14 @@ func handleUnregisterMachine(httpReq *http.Request, params martini.Params) (int,
 
 func handleNewGameRequest(httpReq *http.Request) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
-	var req request.CreateNewGame
-	err := decoder.Decode(&req)
-	if err != nil {
-		logerr("unable to decode body data", err)
-		return 500, "Internal Server Error"
+	var req request.NewGame
+	err := decode(httpReq, &req)
+	if err == nil {
+		err = req.Check()
 	}
-
-	if req.Map == "" || req.GameMode == "" {
-		return 400, "Missing Parameters"
+	if err != nil {
+		log.Info("bad new game request", "err", err)
+		return badRequest(err)
 	}
 
 	if req.MaxPlayers == 0 || req.MaxPlayers > 64 {
@@ -457,80 +845,111 @@ func handleNewGameRequest(httpReq *http.Request) (int, string) {
 		// use default in extreme cases
 	}
 
-	// validate token
-
 	var gameId int
-	gameId, err = thordb.CreateNewGame(req.Map, req.GameMode, req.MinimumLevel, req.MaxPlayers)
+	placementStart := time.Now()
+	gameId, err = thordb.CreateNewGame(req.Map, req.GameMode, req.MinimumLevel, req.MaxPlayers, req.RegionOrder(), req.StrictRegion)
 	if err != nil {
 
-		fmt.Println(err)
-
-		switch err.Error() {
+		placementLatency.Since(placementStart, "failed")
+		log.Warn("couldn't place new game", "map", req.Map, "mode", req.GameMode, "regions", req.RegionOrder(), "err", err)
 
-		case "thordb: no available servers":
+		switch err {
+		case thordb.ErrNoAvailableServers:
 			return 503, "No Available Servers"
+		case thordb.ErrNoServersInRegion:
+			return 503, "No Available Servers In Region"
 		default:
 			return 500, "Internal Server Error"
 		}
 
 	}
 
+	placementLatency.Since(placementStart, "placed")
+
 	response := request.CreateNewGameResponse{GameId: gameId}
 	bytes, err := json.Marshal(&response)
 	if err != nil {
 
-		fmt.Println(err)
+		log.Error("couldn't encode new game response", "err", err)
 		return 500, "Internal Server Error"
 	}
 
-	fmt.Println("[ThoriumNET] new game, id=", strconv.Itoa(gameId))
+	log.Info("new game", "game", gameId, "map", req.Map, "mode", req.GameMode)
 	return 201, string(bytes)
 }
 
-func handleRegisterServer(httpReq *http.Request, params martini.Params) (int, string) {
+func handleRegisterServer(httpReq *http.Request, params martini.Params, claims *auth.Claims) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
-	var req request.RegisterGameServer
-	err := decoder.Decode(&req)
+	gameId, err := strconv.Atoi(params["id"])
 	if err != nil {
-
-		logerr("Error decoding machine register request", err)
-		return 500, "Internal Server Error"
+		return 400, "Bad Request"
 	}
 
-	if req.Port == 0 {
-
-		fmt.Println("No Port Given")
-		return 400, "Missing Parameters"
+	var req request.ServerListening
+	err = decode(httpReq, &req)
+	if err != nil {
+		log.Info("bad register server request", "err", err)
+		return badRequest(err)
 	}
 
-	err = thordb.RegisterActiveGame(req.GameId, req.MachineKey, req.Port)
-	if err != nil {
+	if gameId != claims.GameId {
+		return 403, "Forbidden"
+	}
 
-		fmt.Println(err)
+	err = thordb.RegisterActiveGame(gameId, claims.MachineId, req.Port)
+	switch {
+	case err == thordb.ErrGameNotExist:
+		return 404, "Game Not Found"
+	case err == thordb.ErrGameEnded:
+		return 410, "Game Ended"
+	case err == thordb.ErrGameState:
+		return 409, "Game Already Registered"
+	case err != nil:
+		log.Error("couldn't register game server", "game", gameId, "machine", claims.MachineId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
 	return 200, "OK"
 }
 
-func handleMachineHeartbeat(httpReq *http.Request) (int, string) {
+func handleMachineHeartbeat(httpReq *http.Request, params martini.Params, claims *auth.Claims) (int, string) {
 
-	decoder := json.NewDecoder(httpReq.Body)
-	var req request.MachineStatus
-	err := decoder.Decode(&req)
-	if err != nil || req.MachineKey == "" {
-		log.Print("bad json request", httpReq.Body)
+	machineId, err := strconv.Atoi(params["id"])
+	if err != nil {
 		return 400, "Bad Request"
 	}
 
-	err = thordb.UpdateMachineStatus(req.MachineKey, req.UsageCPU, req.UsageNetwork, req.PlayerCapacity)
+	if machineId != claims.MachineId {
+		return 403, "Forbidden"
+	}
+
+	var req request.MachineLoad
+	err = decode(httpReq, &req)
+	if err != nil {
+		log.Info("bad machine heartbeat", "machine", claims.MachineId, "err", err)
+		return badRequest(err)
+	}
+
+	approval, err := thordb.UpdateMachineStatus(claims.MachineId, req.UsageCPU, req.UsageNetwork, req.PlayerCapacity)
+	switch {
+	case err == thordb.ErrMachineNotExist:
+
+		// reaped, the host registers again
+		return 401, "Machine Not Found"
+
+	case err != nil:
+
+		log.Error("couldn't update machine status", "machine", claims.MachineId, "err", err)
+		return 500, "Internal Server Error"
+	}
+
+	jsonBytes, err := json.Marshal(&request.MachineStatusResponse{Approval: approval})
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't encode machine status", "machine", claims.MachineId, "err", err)
 		return 500, "Internal Server Error"
 	}
 
-	return 200, "OK"
+	return 200, string(jsonBytes)
 }
 
 func handleGetServerInfo(params martini.Params) (int, string) {
@@ -547,9 +966,13 @@ func handleGetServerInfo(params martini.Params) (int, string) {
 
 		return 404, "Game Not Found"
 
+	case err == thordb.ErrGameEnded:
+
+		return 410, "Game Ended"
+
 	case err != nil:
 
-		fmt.Println(err)
+		log.Error("couldn't read server info", "game", gameId, "err", err)
 		return 500, "Internal Server Error"
 
 	}
@@ -572,12 +995,39 @@ func handleGetServerInfo(params martini.Params) (int, string) {
 	return 200, string(jsonBytes)
 }
 
-func sanitize(username string, password string) (string, string, error) {
-	return username, password, nil
+var errEmptyUsername = errors.New("empty username")
+
+// sanitize trims a username and rejects one that was only whitespace. the
+// username rules themselves live in the validation package, and the password
+// limits are on request.Authentication.
+func sanitize(username string) (string, error) {
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return "", errEmptyUsername
+	}
+
+	return username, nil
 }
 
-// TODO: Refactor into logging package
-func logerr(msg string, err error) {
-	fmt.Println("[ThoriumNET] ", msg)
-	fmt.Println(err)
+// decode reads a json body into v and checks it against v's validate tags
+func decode(httpReq *http.Request, v interface{}) error {
+
+	err := json.NewDecoder(httpReq.Body).Decode(v)
+	if err != nil {
+		return err
+	}
+
+	return validation.Struct(v)
+}
+
+// badRequest answers a body decode refused: a broken rule names its field,
+// anything else is just malformed
+func badRequest(err error) (int, string) {
+
+	if fieldErr, ok := err.(*validation.FieldError); ok {
+		return 400, fieldErr.Error()
+	}
+
+	return 400, "Bad Request"
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/go-martini/martini"
+	thordb "github.com/jaybennett89/thorium-go/database"
+	"github.com/jaybennett89/thorium-go/metrics"
+	"github.com/jaybennett89/thorium-go/model"
+)
+
+// prometheus metrics served at /metrics. request counts and latencies come
+// from the middleware, postgres and redis latencies from thordb.
+
+var loginResults = metrics.NewCounter("thorium_logins_total", "Login attempts by result.", "result")
+var placementLatency = metrics.NewHistogram("thorium_game_placement_duration_seconds", "Time to place a new game on a host, by result.", nil, "result")
+var activeSessions = metrics.NewGauge("thorium_active_sessions", "Player sessions currently open.")
+var gamesByState = metrics.NewGauge("thorium_games", "Games by state.", "state")
+
+func registerMetrics(m *martini.ClassicMartini) {
+
+	m.Use(metrics.Middleware("thorium"))
+	m.Get("/metrics", metrics.Handler().ServeHTTP)
+
+	metrics.Default.OnScrape(func() {
+
+		sessions, err := thordb.CountSessions()
+		if err != nil {
+			log.Error("couldn't count sessions for metrics", "err", err)
+		} else {
+			activeSessions.Set(float64(sessions))
+		}
+
+		counts, err := thordb.CountGames()
+		if err != nil {
+			log.Error("couldn't count games for metrics", "err", err)
+		} else {
+			for _, state := range []string{model.GameRequested, model.GameProvisioning, model.GameLoading, model.GameRunning, model.GameEnding} {
+				gamesByState.Set(float64(counts[state]), state)
+			}
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/openapi"
+	request "github.com/jaybennett89/thorium-go/requests"
+)
+
+// the api descriptions served at /openapi.json and /<version>/openapi.json are
+// built from the route lists in the requests package, which its contract test
+// holds to the routes the master registers
+
+// checkSpec warns about routes the master serves that the spec leaves out,
+// for builds that skipped the tests
+func checkSpec(routes martini.Routes) {
+
+	for _, route := range routes.All() {
+		if specFor(route.Method(), route.Pattern()) == nil {
+			log.Warn("route missing from the api spec", "method", route.Method(), "path", route.Pattern())
+		}
+	}
+}
+
+func specFor(method string, pattern string) *openapi.Operation {
+
+	op := openapi.Find(request.MasterAPI, method, pattern)
+	if op != nil {
+		return op
+	}
+
+	for _, version := range request.Versions {
+		prefix := "/" + version
+		if strings.HasPrefix(pattern, prefix+"/") {
+			return openapi.Find(request.VersionAPI(version), method, strings.TrimPrefix(pattern, prefix))
+		}
+	}
+
+	// v1 without a prefix
+	return openapi.Find(request.V1API, method, pattern)
+}
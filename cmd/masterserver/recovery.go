@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/jaybennett89/thorium-go/auth"
+	thordb "github.com/jaybennett89/thorium-go/database"
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/notify"
+	request "github.com/jaybennett89/thorium-go/requests"
+)
+
+const defaultNotifyFile string = "notifications.log"
+
+var notifier notify.Notifier
+
+// newNotifier sends mail through THORIUM_SMTP_ADDR when it is set, otherwise
+// messages are appended to THORIUM_NOTIFY_FILE for local testing.
+func newNotifier() (notify.Notifier, error) {
+
+	if addr := os.Getenv("THORIUM_SMTP_ADDR"); addr != "" {
+		return notify.NewSMTPNotifier(addr, os.Getenv("THORIUM_SMTP_FROM"), os.Getenv("THORIUM_SMTP_USER"), os.Getenv("THORIUM_SMTP_PASSWORD"))
+	}
+
+	path := os.Getenv("THORIUM_NOTIFY_FILE")
+	if path == "" {
+		path = defaultNotifyFile
+	}
+
+	log.Warn("no smtp relay configured, writing notifications to a file", "path", path)
+	return notify.NewFileNotifier(path)
+}
+
+// POST /v1/clients/recovery, PUT /v2/clients/recovery
+func handleSetRecoveryContact(httpReq *http.Request, claims *auth.Claims) (int, string) {
+
+	var req request.RecoveryContact
+	err := decode(httpReq, &req)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	err = thordb.SetRecoveryEmail(claims.UserId, req.Email)
+	if err != nil {
+		log.Info("couldn't set recovery email", "uid", claims.UserId, "err", err)
+		switch err {
+		case thordb.ErrInvalidEmail:
+			return 400, err.Error()
+		default:
+			return 500, "Internal Server Error"
+		}
+	}
+
+	return 200, "OK"
+}
+
+// POST /clients/password/forgot
+// the response is the same whether or not the account exists, so the endpoint
+// can't be used to discover usernames.
+func handleForgotPassword(httpReq *http.Request) (int, string) {
+
+	var req request.ForgotPassword
+	err := decode(httpReq, &req)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	uid, email, token, err := thordb.RequestPasswordReset(req.Username)
+	if err != nil {
+		log.Info("password reset not issued", "username", req.Username, "err", err)
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditPasswordResetRequested, UserId: uid, Username: req.Username, Detail: err.Error()})
+		return 200, "OK"
+	}
+
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditPasswordResetRequested, Success: true, UserId: uid, Username: req.Username})
+
+	msg := notify.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of %s.\r\n\r\nReset token: %s\r\n\r\nThe token expires in %d minutes. If you didn't ask for this you can ignore this message.",
+			req.Username, token, int(thordb.PasswordResetTTL.Minutes())),
+	}
+
+	// send in the background so a slow relay doesn't reveal that the account exists
+	goBackground(func() {
+		err := notifier.Send(msg)
+		if err != nil {
+			log.Error("unable to send password reset", "uid", uid, "err", err)
+		}
+	})
+
+	return 200, "OK"
+}
+
+// POST /clients/password/reset
+func handleResetPassword(httpReq *http.Request) (int, string) {
+
+	var req request.ResetPassword
+	err := decode(httpReq, &req)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	uid, err := thordb.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		log.Info("password reset failed", "err", err)
+		audit(httpReq, model.AuditEvent{Type: thordb.AuditPasswordReset, Detail: err.Error()})
+		switch err {
+		case thordb.ErrInvalidResetToken:
+			return 400, "Bad Request"
+		default:
+			return 500, "Internal Server Error"
+		}
+	}
+
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditPasswordReset, Success: true, UserId: uid})
+	audit(httpReq, model.AuditEvent{Type: thordb.AuditSessionRevoked, Success: true, UserId: uid, Detail: "password reset"})
+
+	return 200, "OK"
+}
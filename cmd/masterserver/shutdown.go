@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	thordb "github.com/jaybennett89/thorium-go/database"
+)
+
+// on SIGTERM or SIGINT the master stops reporting ready, waits
+// THORIUM_DRAIN_DELAY for load balancers to notice, then stops accepting
+// connections and gives the requests in flight until THORIUM_SHUTDOWN_TIMEOUT
+// to finish. the background jobs stop once nothing can start more work, and
+// the postgres and redis pools close last.
+
+const defaultDrainDelay time.Duration = 5 * time.Second
+const defaultShutdownTimeout time.Duration = 30 * time.Second
+
+// 1 while the replica should be sent traffic
+var ready int32
+
+// background work, which the drain waits for along with the requests in
+// flight. loops started with goBackground return when stopping closes
+var background sync.WaitGroup
+var stopping = make(chan struct{})
+
+func isReady() bool {
+
+	return atomic.LoadInt32(&ready) == 1
+}
+
+// goBackground runs fn in the background, where the drain will wait for it
+func goBackground(fn func()) {
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		fn()
+	}()
+}
+
+// envDuration reads a duration from the environment, def when it isn't set
+func envDuration(name string, def time.Duration) time.Duration {
+
+	env := os.Getenv(name)
+	if env == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(env)
+	if err != nil || d < 0 {
+		log.Fatal("bad "+name+", want a duration", "value", env)
+	}
+
+	return d
+}
+
+// serve runs server until the process is signalled to stop, then drains it
+// and shuts the master down. it returns once everything is closed.
+func serve(server *http.Server) {
+
+	drainDelay := envDuration("THORIUM_DRAIN_DELAY", defaultDrainDelay)
+	shutdownTimeout := envDuration("THORIUM_SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal("couldn't listen", "addr", server.Addr, "err", err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			stopped <- server.ServeTLS(listener, "", "")
+		} else {
+			stopped <- server.Serve(listener)
+		}
+	}()
+
+	atomic.StoreInt32(&ready, 1)
+	log.Info("listening", "addr", server.Addr, "tls", server.TLSConfig != nil)
+
+	select {
+	case err = <-stopped:
+		log.Fatal("server stopped", "err", err)
+	case sig := <-signals:
+		log.Info("shutting down", "signal", sig.String(), "drainDelay", drainDelay, "timeout", shutdownTimeout)
+	}
+
+	// a second signal skips the drain
+	go func() {
+		sig := <-signals
+		log.Fatal("stopping without draining", "signal", sig.String())
+	}()
+
+	atomic.StoreInt32(&ready, 0)
+	time.Sleep(drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Warn("requests still in flight at the shutdown deadline", "err", err)
+		server.Close()
+	}
+
+	elector.Stop()
+	close(stopping)
+
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("background work still running at the shutdown deadline")
+	}
+
+	err = thordb.Close()
+	if err != nil {
+		log.Warn("couldn't close the database pools", "err", err)
+	}
+
+	log.Info("shut down")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+
+	"github.com/jaybennett89/thorium-go/client"
+	"github.com/jaybennett89/thorium-go/pki"
+)
+
+// tls is configured from the environment. THORIUM_TLS_CERT and
+// THORIUM_TLS_KEY serve the api over https. THORIUM_TLS_CA turns on mutual
+// tls with the hosts: machine and game server routes then need a client
+// certificate signed by that authority, and calls to hosts check their
+// certificate against it and present ours.
+
+var requireMachineCert bool
+
+// setupTLS returns the listener's tls config, or nil to serve plain http
+func setupTLS() (*tls.Config, error) {
+
+	certFile := os.Getenv("THORIUM_TLS_CERT")
+	keyFile := os.Getenv("THORIUM_TLS_KEY")
+	caFile := os.Getenv("THORIUM_TLS_CA")
+
+	if certFile == "" {
+		return nil, nil
+	}
+
+	// players have no certificates, so one is only checked when given
+	serverCfg, err := pki.ServerConfig(certFile, keyFile, caFile, false)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCfg, err := pki.ClientConfig(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client.UseTLS(clientCfg)
+	requireMachineCert = caFile != ""
+
+	return serverCfg, nil
+}
+
+// requireClientCert refuses callers without a verified client certificate
+// when mutual tls is on
+func requireClientCert(res http.ResponseWriter, httpReq *http.Request) {
+
+	if !requireMachineCert {
+		return
+	}
+
+	if httpReq.TLS == nil || len(httpReq.TLS.VerifiedChains) == 0 {
+		http.Error(res, "Client Certificate Required", 403)
+	}
+}
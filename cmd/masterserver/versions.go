@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/auth"
+	"github.com/jaybennett89/thorium-go/metrics"
+	"github.com/jaybennett89/thorium-go/ratelimit"
+	request "github.com/jaybennett89/thorium-go/requests"
+)
+
+// the game api is versioned. the handlers implement v2, and v1 routes reach
+// them through adapters that move the ids a v1 body carries into the route
+// parameters v2 takes them from. v1 is mounted under /v1 and, for clients
+// that shipped before versions, without a prefix. both answer with
+// deprecation headers, and every versioned request is counted by version so
+// we can tell when v1 traffic has stopped.
+
+// the label unprefixed v1 requests are counted under
+const unversioned string = "unversioned"
+
+var apiRequests = metrics.NewCounter("thorium_api_requests_total", "Requests to the game api, by version.", "version")
+
+// THORIUM_V1_SUNSET is an HTTP date sent in the Sunset header of v1 responses
+// once a retirement date is set
+var v1Sunset string
+
+func registerVersions(m *martini.ClassicMartini) {
+
+	v1Sunset = os.Getenv("THORIUM_V1_SUNSET")
+	if v1Sunset != "" {
+		_, err := http.ParseTime(v1Sunset)
+		if err != nil {
+			log.Fatal("bad THORIUM_V1_SUNSET, want an HTTP date", "err", err)
+		}
+	}
+
+	m.Get("/openapi.json", request.Spec(request.CurrentVersion).Handler())
+	m.Get("/v1/openapi.json", request.Spec(request.V1).Handler())
+	m.Get("/v2/openapi.json", request.Spec(request.V2).Handler())
+
+	m.Group("/v2", registerV2Routes, apiVersion(request.V2))
+	m.Group("/v1", registerV1Routes, apiVersion(request.V1))
+	m.Group("", registerV1Routes, apiVersion(unversioned))
+}
+
+// apiVersion counts requests to a version, and marks the responses of every
+// version but the current one as deprecated
+func apiVersion(version string) martini.Handler {
+
+	label := version
+	if version == unversioned {
+		version = request.V1
+	}
+
+	deprecated := version != request.CurrentVersion
+	successor := "</" + request.CurrentVersion + "/openapi.json>; rel=\"successor-version\""
+
+	return func(res http.ResponseWriter) {
+
+		apiRequests.Inc(label)
+
+		if deprecated {
+			res.Header().Set("Deprecation", "true")
+			res.Header().Set("Link", successor)
+			if v1Sunset != "" && version == request.V1 {
+				res.Header().Set("Sunset", v1Sunset)
+			}
+		}
+	}
+}
+
+func registerV2Routes(r martini.Router) {
+
+	// clients
+	r.Post("/clients/login", ratelimit.Middleware(loginLimiter, ratelimit.ClientIP), handleClientLogin)
+	r.Post("/clients/register", ratelimit.Middleware(registerLimiter, ratelimit.ClientIP), handleClientRegister)
+	r.Post("/clients/disconnect", requireRole(auth.RolePlayer, auth.ScopeAccount), handleClientDisconnect)
+	r.Put("/clients/recovery", requireRole(auth.RolePlayer, auth.ScopeAccount), handleSetRecoveryContact)
+	r.Post("/clients/password/forgot", ratelimit.Middleware(recoveryLimiter, ratelimit.ClientIP), handleForgotPassword)
+	r.Post("/clients/password/reset", ratelimit.Middleware(recoveryLimiter, ratelimit.ClientIP), handleResetPassword)
+
+	// characters
+	r.Post("/characters", requireRole(auth.RolePlayer, auth.ScopeCharacters), handleCreateCharacter)
+	r.Post("/characters/:id/select", requireRole(auth.RolePlayer, auth.ScopeCharacters), handleSelectCharacter)
+	r.Get("/characters/:id", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameCharacters), handleGetCharacter)
+	r.Put("/characters/:id", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameCharacters), handleUpdateCharacter)
+	r.Get("/characters/:id/profile", handleGetCharProfile)
+	r.Get("/characters/:id/matches", handleListCharacterMatches)
+
+	// games
+	r.Post("/games", requireRole(auth.RolePlayer, auth.ScopeGames), handleNewGameRequest)
+	r.Get("/games", handleListGames)
+	r.Get("/games/:id", handleGetGame)
+	r.Get("/games/:id/server", handleGetServerInfo)
+	r.Post("/games/:id/join", requireRole(auth.RolePlayer, auth.ScopeGames), handleJoinGame)
+	r.Put("/games/:id/server", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameReport), handleRegisterServer)
+	r.Delete("/games/:id/server", requireClientCert, requireShutdownToken(auth.ScopeGameReport), handleShutdownServer)
+	r.Post("/games/:id/players", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGamePlayers), handlePlayerConnect)
+	r.Delete("/games/:id/players/:characterId", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGamePlayers), handlePlayerDisconnect)
+	r.Post("/games/:id/results", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameReport), handleReportResults)
+
+	// regions
+	r.Get("/regions", handleListRegions)
+
+	// machines
+	r.Post("/machines", requireClientCert, handleRegisterMachine)
+	r.Put("/machines/:id/status", requireClientCert, requireRole(auth.RoleMachine, auth.ScopeMachineStatus), handleMachineHeartbeat)
+	r.Delete("/machines/:id", requireClientCert, requireRole(auth.RoleMachine, auth.ScopeMachineStatus), handleUnregisterMachine)
+}
+
+// frozen, a change here breaks shipped clients
+func registerV1Routes(r martini.Router) {
+
+	// clients
+	r.Post("/clients/login", ratelimit.Middleware(loginLimiter, ratelimit.ClientIP), handleClientLogin)
+	r.Post("/clients/register", ratelimit.Middleware(registerLimiter, ratelimit.ClientIP), handleClientRegister)
+	r.Post("/clients/disconnect", requireRole(auth.RolePlayer, auth.ScopeAccount), handleClientDisconnect)
+	r.Post("/clients/recovery", requireRole(auth.RolePlayer, auth.ScopeAccount), handleSetRecoveryContact)
+	r.Post("/clients/password/forgot", ratelimit.Middleware(recoveryLimiter, ratelimit.ClientIP), handleForgotPassword)
+	r.Post("/clients/password/reset", ratelimit.Middleware(recoveryLimiter, ratelimit.ClientIP), handleResetPassword)
+
+	// characters
+	r.Post("/characters/new", requireRole(auth.RolePlayer, auth.ScopeCharacters), handleCreateCharacter)
+	r.Post("/characters/select", requireRole(auth.RolePlayer, auth.ScopeCharacters), bodyParam("characterId", "id"), handleSelectCharacter)
+	r.Get("/characters/:id/profile", handleGetCharProfile)
+	r.Get("/characters", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameCharacters), bodyParam("characterId", "id"), handleGetCharacter)
+	r.Post("/characters", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameCharacters), bodyParam("snapshot.characterId", "id"), handleUpdateCharacter)
+
+	// games
+	r.Post("/games/register_server", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGameReport), bodyParam("gameId", "id"), handleRegisterServer)
+	r.Post("/games/player_connect", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGamePlayers), bodyParam("gameId", "id"), handlePlayerConnect)
+	r.Post("/games/player_disconnect", requireClientCert, requireRole(auth.RoleGameServer, auth.ScopeGamePlayers), bodyParam("gameId", "id"), bodyParam("snapshot.characterId", "characterId"), handlePlayerDisconnect)
+	r.Post("/games/shutdown_server", requireClientCert, requireShutdownToken(auth.ScopeGameReport), bodyParam("gameId", "id"), handleShutdownServer)
+
+	r.Post("/games/server_status", handleGameServerStatus)
+
+	r.Post("/games", requireRole(auth.RolePlayer, auth.ScopeGames), handleNewGameRequest)
+
+	r.Get("/games", handleGetServerList)
+	r.Get("/games/:id", handleGetGameInfo)
+	r.Get("/games/:id/server_info", handleGetServerInfo)
+	r.Post("/games/join", requireRole(auth.RolePlayer, auth.ScopeGames), bodyParam("gameId", "id"), handleJoinGame)
+	r.Post("/games/join_queue", handleClientJoinQueue)
+
+	// machines
+	r.Post("/machines/register", requireClientCert, handleRegisterMachine)
+	r.Post("/machines/status", requireClientCert, requireRole(auth.RoleMachine, auth.ScopeMachineStatus), machineParam, handleMachineHeartbeat)
+	r.Post("/machines/:id/disconnect", requireClientCert, requireRole(auth.RoleMachine, auth.ScopeMachineStatus), handleUnregisterMachine)
+	r.Delete("/machines/:id", requireClientCert, requireRole(auth.RoleMachine, auth.ScopeMachineStatus), handleUnregisterMachine)
+}
+
+// bodyParam is a v1 adapter that copies a field of the json body into a
+// route parameter. field may name a nested field, snapshot.characterId. the
+// parameter is left empty when the body doesn't have the field, which the
+// handler refuses like any other bad id.
+func bodyParam(field string, param string) martini.Handler {
+
+	path := strings.Split(field, ".")
+
+	return func(httpReq *http.Request, params martini.Params) {
+
+		params[param] = ""
+
+		body, err := peekBody(httpReq)
+		if err != nil || body == nil {
+			return
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+
+		var v interface{}
+		if decoder.Decode(&v) != nil {
+			return
+		}
+
+		for _, key := range path {
+			fields, ok := v.(map[string]interface{})
+			if !ok {
+				return
+			}
+			v = fields[key]
+		}
+
+		switch value := v.(type) {
+		case json.Number:
+			params[param] = value.String()
+		case string:
+			params[param] = value
+		}
+	}
+}
+
+// machineParam is a v1 adapter for the heartbeat, which v1 sends without the
+// machine's id: the id is the caller's own
+func machineParam(params martini.Params, claims *auth.Claims) {
+
+	params["id"] = strconv.Itoa(claims.MachineId)
+}
@@ -0,0 +1,113 @@
+package main
+
+// a small certificate authority for securing master and host traffic.
+//
+//	thorium-ca init -dir certs
+//	thorium-ca issue -dir certs -name master -hosts localhost,127.0.0.1
+//	thorium-ca issue -dir certs -name host-1 -hosts host-1.example.net,10.0.0.5
+//
+// init writes ca.crt and ca.key. issue writes <name>.crt and <name>.key,
+// signed by the authority in the same directory.
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/pki"
+)
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "init":
+		initCA(os.Args[2:])
+	case "issue":
+		issue(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+
+	fmt.Fprintln(os.Stderr, "usage: thorium-ca init|issue [flags]")
+	os.Exit(2)
+}
+
+func initCA(args []string) {
+
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := flags.String("dir", "certs", "directory to write ca.crt and ca.key to")
+	name := flags.String("name", "thorium ca", "common name of the authority")
+	ttl := flags.Duration("ttl", 10*365*24*time.Hour, "how long the authority is valid")
+	flags.Parse(args)
+
+	certFile := filepath.Join(*dir, "ca.crt")
+	if _, err := os.Stat(certFile); err == nil {
+		log.Fatalf("thorium-ca: %s already exists", certFile)
+	}
+
+	err := os.MkdirAll(*dir, 0700)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ca, err := pki.NewCA(*name, *ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ca.Save(certFile, filepath.Join(*dir, "ca.key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("wrote", certFile)
+}
+
+func issue(args []string) {
+
+	flags := flag.NewFlagSet("issue", flag.ExitOnError)
+	dir := flags.String("dir", "certs", "directory holding the authority")
+	name := flags.String("name", "", "common name of the certificate, also its file name")
+	hosts := flags.String("hosts", "", "comma separated dns names and ip addresses the certificate is valid for")
+	ttl := flags.Duration("ttl", 365*24*time.Hour, "how long the certificate is valid")
+	flags.Parse(args)
+
+	if *name == "" || *hosts == "" {
+		log.Fatal("thorium-ca: -name and -hosts are required")
+	}
+
+	ca, err := pki.LoadCA(filepath.Join(*dir, "ca.crt"), filepath.Join(*dir, "ca.key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cert, key, err := ca.Issue(*name, strings.Split(*hosts, ","), *ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	certFile := filepath.Join(*dir, *name+".crt")
+	err = ioutil.WriteFile(certFile, cert, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(*dir, *name+".key"), key, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("wrote", certFile)
+}
@@ -0,0 +1,349 @@
+package thordb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/client"
+	"github.com/jaybennett89/thorium-go/model"
+
+	"github.com/lib/pq"
+	"gopkg.in/redis.v3"
+)
+
+// operator facing queries and actions used by the /admin api
+
+// machine states
+const MachineActive string = "active"
+const MachineCordoned string = "cordoned"
+const MachineDraining string = "draining"
+
+var ErrMachineNotExist = errors.New("thordb: machine does not exist")
+var ErrMachineDraining = errors.New("thordb: machine is draining")
+var ErrInvalidMachineState = errors.New("thordb: invalid machine state")
+
+const machineStatusQuery string = "SELECT machine_id, remote_address, service_listen_port, region, zone, state, approval, label, last_heartbeat, cpu_usage_pct, network_usage_pct, player_occupancy_pct FROM machines JOIN machines_metadata USING (machine_id) LEFT JOIN join_tokens ON join_tokens.token_id = machines.join_token_id"
+
+// AdminListMachines lists every enrolled machine, or only those with the
+// given approval state when approval is not empty.
+func AdminListMachines(approval string) ([]model.MachineStatus, error) {
+
+	var rows *sql.Rows
+	var err error
+	if approval == "" {
+		rows, err = db.Query(machineStatusQuery + " ORDER BY machine_id")
+	} else {
+		rows, err = db.Query(machineStatusQuery+" WHERE approval = $1 ORDER BY machine_id", approval)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.MachineStatus, 0)
+
+	for rows.Next() {
+		m, err := scanMachineStatus(rows)
+		if err != nil {
+			log.Error("machine read error", "err", err)
+			continue
+		}
+		list = append(list, *m)
+	}
+
+	for i := range list {
+		err = loadMachineGames(&list[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+func AdminGetMachine(machineId int) (*model.MachineStatus, error) {
+
+	row := db.QueryRow(machineStatusQuery+" WHERE machine_id = $1", machineId)
+
+	m, err := scanMachineStatus(row)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrMachineNotExist
+	case err != nil:
+		return nil, err
+	}
+
+	err = loadMachineGames(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SetMachineState moves a machine between active, cordoned and draining.
+// cordoned machines get no new games; draining machines also refuse new
+// players so their running games empty out.
+func SetMachineState(machineId int, state string) error {
+
+	switch state {
+	case MachineActive, MachineCordoned, MachineDraining:
+	default:
+		return ErrInvalidMachineState
+	}
+
+	res, err := db.Exec("UPDATE machines SET state = $1 WHERE machine_id = $2", state, machineId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrMachineNotExist
+	}
+
+	return nil
+}
+
+// ForceEndGame asks the host to stop the game server and ends the game. the
+// host call is best effort so a dead host can't keep a game alive. a game
+// that never started fails instead.
+func ForceEndGame(gameId int) error {
+
+	var state string
+	var address sql.NullString
+	var port sql.NullInt64
+	var machineKey sql.NullString
+	err := db.QueryRow("SELECT g.state, m.remote_address, m.service_listen_port, mm.most_recent_key FROM games g LEFT JOIN machines m USING (machine_id) LEFT JOIN machines_metadata mm USING (machine_id) WHERE g.game_id = $1", gameId).Scan(&state, &address, &port, &machineKey)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrGameNotExist
+	case err != nil:
+		return err
+	}
+
+	switch state {
+	case model.GameEnded, model.GameFailed:
+		return ErrGameEnded
+	case model.GameRequested, model.GameProvisioning:
+		return failGame(db, gameId, "ended by an operator")
+	}
+
+	res, err := db.Exec("UPDATE games SET state = $1, state_reason = $2 WHERE game_id = $3 AND state IN "+movableTo(model.GameEnding, model.GameLoading, model.GameRunning), model.GameEnding, "ended by an operator", gameId)
+	err = moved(db, res, err, gameId)
+	if err != nil && err != ErrGameState {
+		return err
+	}
+
+	if address.Valid {
+		rc, body, err := client.EndGameServer(fmt.Sprintf("%s:%d", address.String, port.Int64), gameId, machineKey.String)
+		if err != nil {
+			log.Warn("couldn't reach host to end game", "game", gameId, "err", err)
+		} else if rc != 200 {
+			log.Warn("host refused to end game", "game", gameId, "status", rc, "body", body)
+		}
+	}
+
+	// the game server may have reported its shutdown already
+	res, err = db.Exec("UPDATE games SET state = $1 WHERE game_id = $2 AND state IN "+movableTo(model.GameEnded, model.GameEnding), model.GameEnded, gameId)
+	err = moved(db, res, err, gameId)
+	if err == ErrGameEnded {
+		return nil
+	}
+
+	return err
+}
+
+func AdminListSessions() ([]model.Session, error) {
+
+	list := make([]model.Session, 0)
+	prefix := strings.TrimSuffix(sessionKey, "%d")
+
+	var cursor int64
+	for {
+
+		var keys []string
+		var err error
+		cursor, keys, err = kvstore.Scan(cursor, prefix+"*", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+
+			uid, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+			if err != nil {
+				continue
+			}
+
+			s := model.Session{UserId: uid}
+
+			ttl, err := kvstore.TTL(key).Result()
+			if err == nil {
+				s.ExpiresIn = int(ttl / time.Second)
+			}
+
+			err = db.QueryRow("SELECT username FROM account_data WHERE user_id = $1", uid).Scan(&s.Username)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+
+			list = append(list, s)
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return list, nil
+}
+
+// KickPlayer ends the session of the user. later requests made with the old
+// session key fail validation.
+func KickPlayer(uid int) (bool, error) {
+
+	count, err := kvstore.Del(fmt.Sprintf(sessionKey, uid)).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func AdminListLoadingHosts() ([]model.LoadingHost, error) {
+
+	rows, err := db.Query("SELECT game_id, machine_id, map_name, game_mode, state_changed_on FROM games WHERE state IN " + sqlList([]string{model.GameProvisioning, model.GameLoading}) + " ORDER BY state_changed_on")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.LoadingHost, 0)
+
+	for rows.Next() {
+		var h model.LoadingHost
+		err = rows.Scan(&h.GameId, &h.MachineId, &h.Map, &h.Mode, &h.KickoffTime)
+		if err != nil {
+			log.Error("loading host read error", "err", err)
+			continue
+		}
+		list = append(list, h)
+	}
+
+	return list, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMachineStatus(row scanner) (*model.MachineStatus, error) {
+
+	var m model.MachineStatus
+	var label sql.NullString
+	var heartbeat pq.NullTime
+	var cpu, network, occupancy sql.NullFloat64
+
+	err := row.Scan(&m.MachineId, &m.RemoteAddress, &m.ListenPort, &m.Region, &m.Zone, &m.State, &m.Approval, &label, &heartbeat, &cpu, &network, &occupancy)
+	if err != nil {
+		return nil, err
+	}
+
+	m.EnrolledWith = label.String
+
+	m.LastHeartbeat = heartbeat.Time
+	m.UsageCPU = cpu.Float64
+	m.UsageNetwork = network.Float64
+	m.PlayerCapacity = occupancy.Float64
+
+	return &m, nil
+}
+
+func loadMachineGames(m *model.MachineStatus) error {
+
+	var err error
+
+	m.RunningGames, err = queryIds("SELECT game_id FROM games WHERE machine_id = $1 AND state = $2", m.MachineId, model.GameRunning)
+	if err != nil {
+		return err
+	}
+
+	m.LoadingGames, err = queryIds("SELECT game_id FROM games WHERE machine_id = $1 AND state IN "+sqlList([]string{model.GameProvisioning, model.GameLoading}), m.MachineId)
+	return err
+}
+
+func queryIds(query string, args ...interface{}) ([]int, error) {
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// CountSessions counts the active player sessions.
+func CountSessions() (int, error) {
+
+	prefix := strings.TrimSuffix(sessionKey, "%d")
+
+	count := 0
+	var cursor int64
+	for {
+
+		var keys []string
+		var err error
+		cursor, keys, err = kvstore.Scan(cursor, prefix+"*", 1000).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		count += len(keys)
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+// CountGames counts the games in each state that hasn't ended.
+func CountGames() (map[string]int, error) {
+
+	rows, err := db.Query("SELECT state, COUNT(*) FROM games WHERE state NOT IN " + sqlList([]string{model.GameEnded, model.GameFailed}) + " GROUP BY state")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var state string
+		var count int
+		err = rows.Scan(&state, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[state] = count
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,132 @@
+package thordb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/redact"
+)
+
+// security audit trail. events never carry secrets: free text fields are
+// passed through the redaction guard before they are stored.
+
+// audit event types
+const AuditLogin string = "login"
+const AuditRegister string = "register"
+const AuditSessionRevoked string = "session_revoked"
+const AuditAdminAction string = "admin_action"
+const AuditMachineRegistered string = "machine_registered"
+const AuditPasswordResetRequested string = "password_reset_requested"
+const AuditPasswordReset string = "password_reset"
+
+const maxAuditResults int = 1000
+
+type AuditFilter struct {
+	Type          string
+	Username      string
+	UserId        int
+	RemoteAddress string
+	Since         time.Time
+	Until         time.Time
+	Limit         int
+}
+
+func RecordAudit(ev *model.AuditEvent) error {
+
+	if ev.OccurredOn.IsZero() {
+		ev.OccurredOn = time.Now()
+	}
+
+	ev.UserAgent = redact.String(ev.UserAgent)
+	ev.Detail = redact.String(ev.Detail)
+
+	return db.QueryRow("INSERT INTO security_audit (event_type, occurred_on, success, actor, user_id, username, machine_id, remote_address, user_agent, detail) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING event_id",
+		ev.Type, ev.OccurredOn, ev.Success, nullString(ev.Actor), nullInt(ev.UserId), nullString(ev.Username), nullInt(ev.MachineId), ev.RemoteAddress, ev.UserAgent, nullString(ev.Detail)).Scan(&ev.EventId)
+}
+
+// QueryAudit returns the newest events matching filter first.
+func QueryAudit(filter AuditFilter) ([]model.AuditEvent, error) {
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.Type != "" {
+		add("event_type = $%d", filter.Type)
+	}
+	if filter.Username != "" {
+		add("username = $%d", filter.Username)
+	}
+	if filter.UserId != 0 {
+		add("user_id = $%d", filter.UserId)
+	}
+	if filter.RemoteAddress != "" {
+		add("remote_address = $%d", filter.RemoteAddress)
+	}
+	if !filter.Since.IsZero() {
+		add("occurred_on >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("occurred_on < $%d", filter.Until)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditResults {
+		limit = maxAuditResults
+	}
+
+	query := "SELECT event_id, event_type, occurred_on, success, actor, user_id, username, machine_id, remote_address, user_agent, detail FROM security_audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY occurred_on DESC, event_id DESC LIMIT %d", limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.AuditEvent, 0)
+
+	for rows.Next() {
+
+		var ev model.AuditEvent
+		var actor, username, address, agent, detail sql.NullString
+		var uid, machineId sql.NullInt64
+
+		err = rows.Scan(&ev.EventId, &ev.Type, &ev.OccurredOn, &ev.Success, &actor, &uid, &username, &machineId, &address, &agent, &detail)
+		if err != nil {
+			return nil, err
+		}
+
+		ev.Actor = actor.String
+		ev.UserId = int(uid.Int64)
+		ev.Username = username.String
+		ev.MachineId = int(machineId.Int64)
+		ev.RemoteAddress = address.String
+		ev.UserAgent = agent.String
+		ev.Detail = detail.String
+
+		list = append(list, ev)
+	}
+
+	return list, nil
+}
+
+func nullString(s string) sql.NullString {
+
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(i int) sql.NullInt64 {
+
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
@@ -0,0 +1,204 @@
+package thordb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+
+	"github.com/lib/pq"
+)
+
+// machine enrollment. a host registers with a join token an operator created,
+// and the machine it becomes is pending until approved unless the token
+// approves its machines itself. like reset tokens, only a sha256 of a join
+// token is stored.
+
+// machine approval states
+const MachinePending string = "pending"
+const MachineApproved string = "approved"
+const MachineRevoked string = "revoked"
+
+const joinTokenSize int = 24
+
+var ErrInvalidJoinToken = errors.New("thordb: invalid join token")
+var ErrJoinTokenNotExist = errors.New("thordb: join token does not exist")
+var ErrMachineRevoked = errors.New("thordb: machine is revoked")
+
+// CreateJoinToken creates a join token good for maxUses registrations, or any
+// number when maxUses is 0. a ttl of 0 never expires. the token itself is
+// only returned here.
+func CreateJoinToken(label string, maxUses int, ttl time.Duration, autoApprove bool, actor string) (string, *model.JoinToken, error) {
+
+	if label == "" || maxUses < 0 || ttl < 0 {
+		return "", nil, ErrInvalidJoinToken
+	}
+
+	token, err := randomToken(joinTokenSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	jt := model.JoinToken{Label: label, MaxUses: maxUses, AutoApprove: autoApprove, CreatedBy: actor, CreatedOn: time.Now()}
+
+	var expires pq.NullTime
+	if ttl > 0 {
+		expires = pq.NullTime{Time: jt.CreatedOn.Add(ttl), Valid: true}
+		jt.ExpiresOn = &expires.Time
+	}
+
+	err = db.QueryRow("INSERT INTO join_tokens (token_hash, label, max_uses, auto_approve, created_by, created_on, expires_on) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING token_id",
+		hashToken(token), label, maxUses, autoApprove, actor, jt.CreatedOn, expires).Scan(&jt.TokenId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &jt, nil
+}
+
+func ListJoinTokens() ([]model.JoinToken, error) {
+
+	rows, err := db.Query("SELECT token_id, label, max_uses, uses, auto_approve, created_by, created_on, expires_on, revoked_on FROM join_tokens ORDER BY token_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.JoinToken, 0)
+
+	for rows.Next() {
+
+		var jt model.JoinToken
+		var expires, revoked pq.NullTime
+
+		err = rows.Scan(&jt.TokenId, &jt.Label, &jt.MaxUses, &jt.Uses, &jt.AutoApprove, &jt.CreatedBy, &jt.CreatedOn, &expires, &revoked)
+		if err != nil {
+			return nil, err
+		}
+
+		if expires.Valid {
+			jt.ExpiresOn = &expires.Time
+		}
+		if revoked.Valid {
+			jt.RevokedOn = &revoked.Time
+		}
+
+		list = append(list, jt)
+	}
+
+	return list, nil
+}
+
+// RevokeJoinToken stops a join token from enrolling more machines. machines
+// it already enrolled are left alone.
+func RevokeJoinToken(tokenId int) error {
+
+	res, err := db.Exec("UPDATE join_tokens SET revoked_on = $1 WHERE token_id = $2 AND revoked_on IS NULL", time.Now(), tokenId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrJoinTokenNotExist
+	}
+
+	return nil
+}
+
+// useJoinToken takes one use of a join token and returns its id and whether
+// its machines are approved on enrollment
+func useJoinToken(tx *sql.Tx, token string) (int, bool, error) {
+
+	var tokenId int
+	var autoApprove bool
+
+	err := tx.QueryRow("UPDATE join_tokens SET uses = uses + 1 WHERE token_hash = $1 AND revoked_on IS NULL AND (expires_on IS NULL OR expires_on > $2) AND (max_uses = 0 OR uses < max_uses) RETURNING token_id, auto_approve",
+		hashToken(token), time.Now()).Scan(&tokenId, &autoApprove)
+	if err == sql.ErrNoRows {
+		return 0, false, ErrInvalidJoinToken
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return tokenId, autoApprove, nil
+}
+
+// ApproveMachine lets a pending machine host games.
+func ApproveMachine(machineId int) error {
+
+	var approval string
+	err := db.QueryRow("SELECT approval FROM machines WHERE machine_id = $1", machineId).Scan(&approval)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrMachineNotExist
+	case err != nil:
+		return err
+	case approval == MachineRevoked:
+		return ErrMachineRevoked
+	}
+
+	_, err = db.Exec("UPDATE machines SET approval = $1 WHERE machine_id = $2 AND approval = $3", MachineApproved, machineId, MachinePending)
+	return err
+}
+
+// RevokeMachine expels a machine from the fleet. its key stops working at
+// once, it can't renew, and the games it was hosting fail so their game
+// server keys stop working too. the join token it enrolled with is revoked
+// as well, since the host still holds it. the machine record is kept for the
+// audit trail.
+func RevokeMachine(machineId int) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("UPDATE machines SET approval = $1 WHERE machine_id = $2", MachineRevoked, machineId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rows == 0 {
+		tx.Rollback()
+		return ErrMachineNotExist
+	}
+
+	err = failMachineGames(tx, machineId, "machine revoked")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE machines_metadata SET most_recent_key = NULL WHERE machine_id = $1", machineId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE join_tokens SET revoked_on = $1 WHERE token_id = (SELECT join_token_id FROM machines WHERE machine_id = $2) AND revoked_on IS NULL", time.Now(), machineId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return kvstore.Del(fmt.Sprintf(machineSessionKey, machineId)).Err()
+}
@@ -0,0 +1,67 @@
+//go:build integration
+
+package thordb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// these run against the postgres and redis of docker-compose.yml, with the
+// baseline schema loaded:
+//
+//	go test -tags integration ./database
+
+func TestRevokedMachineIsNotReapedOrReEnrolled(t *testing.T) {
+
+	lease := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	// a fresh database has no signing key until the first rotation
+	_, err := RotateSigningKeys(lease, 1, DefaultKeyRotation)
+	if err != nil {
+		t.Fatalf("couldn't set up a signing key: %s", err)
+	}
+
+	token, _, err := CreateJoinToken(lease, 0, 0, true, "test")
+	if err != nil {
+		t.Fatalf("couldn't create a join token: %s", err)
+	}
+
+	machineId, machineKey, approval, err := RegisterMachine("127.0.0.1", 6961, "", "", token)
+	if err != nil || approval != MachineApproved {
+		t.Fatalf("couldn't enroll: %v %s", err, approval)
+	}
+
+	err = RevokeMachine(machineId)
+	if err != nil {
+		t.Fatalf("couldn't revoke: %s", err)
+	}
+
+	// the host stops sending heartbeats long enough to be reaped
+	_, err = db.Exec("UPDATE machines_metadata SET last_heartbeat = $1 WHERE machine_id = $2", time.Now().Add(-time.Hour), machineId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReapStaleMachines(lease, 1, time.Minute)
+	if err != nil {
+		t.Fatalf("couldn't reap: %s", err)
+	}
+
+	var state string
+	err = db.QueryRow("SELECT approval FROM machines WHERE machine_id = $1", machineId).Scan(&state)
+	if err != nil || state != MachineRevoked {
+		t.Fatalf("revoked machine was reaped: %v %s", err, state)
+	}
+
+	_, _, _, _, err = RenewMachine(machineKey, "127.0.0.1", 6961, "", "", nil)
+	if err != ErrMachineRevoked {
+		t.Fatalf("revoked machine renewed: %v", err)
+	}
+
+	_, _, _, err = RegisterMachine("127.0.0.1", 6961, "", "", token)
+	if err != ErrInvalidJoinToken {
+		t.Fatalf("revoked machine's join token enrolled again: %v", err)
+	}
+}
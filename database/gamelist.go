@@ -0,0 +1,182 @@
+package thordb
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jaybennett89/thorium-go/model"
+	request "github.com/jaybennett89/thorium-go/requests"
+)
+
+// the server browser's game list. pages are keyset paginated: a cursor holds
+// the sort key of the last game on its page, so a page costs the same however
+// deep it is and games placed in the meantime don't shift the pages after it.
+// sorting by player count follows the counts as they are when each page is
+// read, so a game whose count changes between pages may show up twice or not
+// at all.
+
+var ErrBadCursor = errors.New("thordb: bad cursor")
+
+// a game, the region of the host it is placed on and the seats reserved in it
+const gameListingQuery string = `SELECT g.game_id, g.map_name, g.game_mode, g.minimum_level, g.player_count, g.maximum_players,
+		g.state, COALESCE(m.region, '') AS region,
+		(SELECT count(*) FROM seat_reservations r WHERE r.game_id = g.game_id AND r.` + liveReservation + `) AS reserved
+	FROM games g
+	  LEFT JOIN machines m ON m.machine_id = g.machine_id`
+
+// the games listed when the filter names no state
+var listedGameStates = []string{model.GameLoading, model.GameRunning}
+
+type gameOrder struct {
+	by       string
+	after    string
+	keyWidth int
+}
+
+var gameOrders = map[string]gameOrder{
+	request.SortNewest:        {"game_id DESC", "game_id < $%d", 1},
+	request.SortOldest:        {"game_id ASC", "game_id > $%d", 1},
+	request.SortMostPlayers:   {"player_count DESC, game_id DESC", "(player_count, game_id) < ($%d, $%d)", 2},
+	request.SortFewestPlayers: {"player_count ASC, game_id ASC", "(player_count, game_id) > ($%d, $%d)", 2},
+}
+
+// ListGames returns a page of the games matching filter, which the caller
+// has validated, and the cursor of the next page, empty on the last one
+func ListGames(filter request.GameFilter) ([]model.GameListing, string, error) {
+
+	order, ok := gameOrders[filter.Sort]
+	if !ok {
+		order = gameOrders[request.SortNewest]
+		filter.Sort = request.SortNewest
+	}
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.Map != "" {
+		add("map_name = $%d", filter.Map)
+	}
+	if filter.Mode != "" {
+		add("game_mode = $%d", filter.Mode)
+	}
+	if filter.MinLevel > 0 {
+		add("minimum_level >= $%d", filter.MinLevel)
+	}
+	if filter.MaxLevel > 0 {
+		add("minimum_level <= $%d", filter.MaxLevel)
+	}
+	if filter.OpenSlots {
+		where = append(where, "player_count + reserved < maximum_players")
+	}
+	if filter.State != "" {
+		add("state = $%d", filter.State)
+	} else {
+		where = append(where, "state IN "+sqlList(listedGameStates))
+	}
+	if filter.Region != "" {
+		add("region = $%d", filter.Region)
+	}
+
+	if filter.Cursor != "" {
+		key, err := decodeGameCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+
+		args = append(args, key[len(key)-order.keyWidth:]...)
+		if order.keyWidth == 1 {
+			where = append(where, fmt.Sprintf(order.after, len(args)))
+		} else {
+			where = append(where, fmt.Sprintf(order.after, len(args)-1, len(args)))
+		}
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > request.MaxGamePage {
+		limit = request.DefaultGamePage
+	}
+
+	query := "SELECT * FROM (" + gameListingQuery + ") AS listing"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	// one more than the page, to tell whether there is a next one
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d", order.by, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	list := make([]model.GameListing, 0)
+
+	for rows.Next() {
+
+		var g model.GameListing
+		var reserved int
+		err = rows.Scan(&g.GameId, &g.Map, &g.Mode, &g.MinimumLevel, &g.PlayerCount, &g.MaximumPlayers, &g.State, &g.Region, &reserved)
+		if err != nil {
+			return nil, "", err
+		}
+
+		g.OpenSlots = openSlots(&g, reserved)
+
+		list = append(list, g)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(list) <= limit {
+		return list, "", nil
+	}
+
+	list = list[:limit]
+	last := list[limit-1]
+	return list, encodeGameCursor(filter.Sort, last.PlayerCount, last.GameId), nil
+}
+
+// a cursor is the sort it belongs to and the player count and id of the last
+// game on its page. it is opaque to clients.
+func encodeGameCursor(sort string, playerCount int, gameId int) string {
+
+	raw := fmt.Sprintf("%s:%d:%d", sort, playerCount, gameId)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeGameCursor(cursor string, sort string) ([]interface{}, error) {
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || parts[0] != sort {
+		return nil, ErrBadCursor
+	}
+
+	playerCount, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	gameId, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	return []interface{}{playerCount, gameId}, nil
+}
@@ -0,0 +1,158 @@
+package thordb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jaybennett89/thorium-go/model"
+)
+
+// a game's state only ever moves along model.GameTransitions, which the
+// database enforces as well. every update that moves a game names the states
+// it moves the game from, so two updates racing on the same game can't both
+// move it, and one that finds the game in another state reports why.
+
+var ErrGameEnded = errors.New("thordb: game has ended")
+var ErrGameState = errors.New("thordb: game is in the wrong state")
+
+// the states of games that hold a machine
+var activeGameStates = []string{model.GameProvisioning, model.GameLoading, model.GameRunning, model.GameEnding}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// movableTo returns from as a sql list. it panics when one of the states
+// can't move to to, which is a mistake in the caller.
+func movableTo(to string, from ...string) string {
+
+	for _, state := range from {
+		if !model.CanTransition(state, to) {
+			panic("thordb: a game can't move from " + state + " to " + to)
+		}
+	}
+
+	return sqlList(from)
+}
+
+// sqlList returns states as a sql list, ('a', 'b'). states are constants,
+// never input.
+func sqlList(states []string) string {
+
+	quoted := make([]string, len(states))
+	for i, state := range states {
+		quoted[i] = "'" + state + "'"
+	}
+
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
+
+// moved checks that an update moved the game, and otherwise says why not
+func moved(x execer, res sql.Result, err error, gameId int) error {
+
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows > 0 {
+		return nil
+	}
+
+	var state string
+	err = x.QueryRow("SELECT state FROM games WHERE game_id = $1", gameId).Scan(&state)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrGameNotExist
+	case err != nil:
+		return err
+	case state == model.GameEnded || state == model.GameFailed:
+		return ErrGameEnded
+	}
+
+	return ErrGameState
+}
+
+// failGame marks a game that hasn't ended as failed
+func failGame(x execer, gameId int, reason string) error {
+
+	res, err := x.Exec("UPDATE games SET state = $1, state_reason = $2 WHERE game_id = $3 AND state IN "+movableTo(model.GameFailed, model.GameRequested, model.GameProvisioning, model.GameLoading, model.GameRunning, model.GameEnding), model.GameFailed, reason, gameId)
+	return moved(x, res, err, gameId)
+}
+
+// failMachineGames marks every game a machine holds as failed, before the
+// machine is revoked or removed
+func failMachineGames(x execer, machineId int, reason string) error {
+
+	_, err := x.Exec("UPDATE games SET state = $1, state_reason = $2 WHERE machine_id = $3 AND state IN "+movableTo(model.GameFailed, activeGameStates...), model.GameFailed, reason, machineId)
+	return err
+}
+
+// GetGame returns a game in any state, with its transitions
+func GetGame(gameId int) (*model.GameDetail, error) {
+
+	var g model.GameDetail
+	var machineId sql.NullInt64
+	var reserved int
+
+	err := db.QueryRow(`SELECT g.game_id, g.map_name, g.game_mode, g.minimum_level, g.player_count, g.maximum_players,
+			g.state, COALESCE(m.region, ''), g.machine_id, g.requested_on,
+			(SELECT count(*) FROM seat_reservations r WHERE r.game_id = g.game_id AND r.`+liveReservation+`)
+		FROM games g
+		  LEFT JOIN machines m ON m.machine_id = g.machine_id
+		WHERE g.game_id = $1`, gameId).Scan(&g.GameId, &g.Map, &g.Mode, &g.MinimumLevel, &g.PlayerCount, &g.MaximumPlayers, &g.State, &g.Region, &machineId, &g.RequestedOn, &reserved)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrGameNotExist
+	case err != nil:
+		return nil, err
+	}
+
+	g.MachineId = int(machineId.Int64)
+	g.OpenSlots = openSlots(&g.GameListing, reserved)
+
+	rows, err := db.Query("SELECT from_state, to_state, occurred_on, reason FROM game_transitions WHERE game_id = $1 ORDER BY transition_id", gameId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	g.Transitions = make([]model.GameTransition, 0)
+	for rows.Next() {
+
+		var t model.GameTransition
+		var from, reason sql.NullString
+		err = rows.Scan(&from, &t.To, &t.OccurredOn, &reason)
+		if err != nil {
+			return nil, err
+		}
+
+		t.From = from.String
+		t.Reason = reason.String
+		g.Transitions = append(g.Transitions, t)
+	}
+
+	return &g, rows.Err()
+}
+
+// openSlots is the room left in a game that can still take players, less
+// the seats reserved for players on their way
+func openSlots(g *model.GameListing, reserved int) int {
+
+	if g.State != model.GameLoading && g.State != model.GameRunning {
+		return 0
+	}
+
+	taken := g.PlayerCount + reserved
+	if taken >= g.MaximumPlayers {
+		return 0
+	}
+
+	return g.MaximumPlayers - taken
+}
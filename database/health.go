@@ -0,0 +1,42 @@
+package thordb
+
+import (
+	"fmt"
+)
+
+// readiness checks for the master, each a health.Check
+
+// CheckPostgres pings postgres through the pool
+func CheckPostgres() (string, error) {
+
+	err := db.Ping()
+	if err != nil {
+		return "", err
+	}
+
+	stats := db.Stats()
+	return fmt.Sprintf("%d open connections, %d in use", stats.OpenConnections, stats.InUse), nil
+}
+
+// CheckRedis pings redis through the pool
+func CheckRedis() (string, error) {
+
+	err := kvstore.Ping().Err()
+	if err != nil {
+		return "", err
+	}
+
+	stats := kvstore.PoolStats()
+	return fmt.Sprintf("%d open connections", stats.TotalConns), nil
+}
+
+// CheckSigningKey reports whether the keyring holds a key to sign tokens with
+func CheckSigningKey() (string, error) {
+
+	key, err := ring.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	return "signing with " + key.Id, nil
+}
@@ -0,0 +1,177 @@
+package thordb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/metrics"
+)
+
+// every postgres and redis call is timed below the thordb api, by wrapping
+// the postgres driver and the connections the redis client dials, so no query
+// can be missed.
+
+var dbLatency = metrics.NewHistogram("thorium_db_duration_seconds", "Postgres call latency by operation.", nil, "op")
+var redisLatency = metrics.NewHistogram("thorium_redis_duration_seconds", "Redis round trip latency.", nil)
+
+// openTimed opens a postgres pool whose calls are timed
+func openTimed(dataSource string) (*sql.DB, error) {
+
+	pg, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	drv := pg.Driver()
+	pg.Close()
+
+	return sql.OpenDB(&timedConnector{dataSource: dataSource, driver: drv}), nil
+}
+
+type timedConnector struct {
+	dataSource string
+	driver     driver.Driver
+}
+
+func (c *timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+
+	conn, err := c.driver.Open(c.dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	return &timedConn{conn}, nil
+}
+
+func (c *timedConnector) Driver() driver.Driver {
+
+	return c.driver
+}
+
+type timedConn struct {
+	driver.Conn
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+
+	defer dbLatency.Since(time.Now(), "prepare")
+
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &timedStmt{stmt}, nil
+}
+
+func (c *timedConn) Begin() (driver.Tx, error) {
+
+	defer dbLatency.Since(time.Now(), "begin")
+
+	tx, err := c.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &timedTx{tx}, nil
+}
+
+// Query and Exec skip the prepare round trip when the driver can
+func (c *timedConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+
+	queryer, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	defer dbLatency.Since(time.Now(), "query")
+	return queryer.Query(query, args)
+}
+
+func (c *timedConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+
+	execer, ok := c.Conn.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	defer dbLatency.Since(time.Now(), "exec")
+	return execer.Exec(query, args)
+}
+
+type timedStmt struct {
+	driver.Stmt
+}
+
+func (s *timedStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	defer dbLatency.Since(time.Now(), "query")
+	return s.Stmt.Query(args)
+}
+
+func (s *timedStmt) Exec(args []driver.Value) (driver.Result, error) {
+
+	defer dbLatency.Since(time.Now(), "exec")
+	return s.Stmt.Exec(args)
+}
+
+type timedTx struct {
+	driver.Tx
+}
+
+func (t *timedTx) Commit() error {
+
+	defer dbLatency.Since(time.Now(), "commit")
+	return t.Tx.Commit()
+}
+
+func (t *timedTx) Rollback() error {
+
+	defer dbLatency.Since(time.Now(), "rollback")
+	return t.Tx.Rollback()
+}
+
+// redisDialer dials connections that time each command from its write to the
+// first byte of the reply. a pipeline counts as one round trip.
+func redisDialer(addr string) func() (net.Conn, error) {
+
+	return func() (net.Conn, error) {
+
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+
+		return &timedRedisConn{Conn: conn}, nil
+	}
+}
+
+type timedRedisConn struct {
+	net.Conn
+	sent    time.Time
+	waiting bool
+}
+
+func (c *timedRedisConn) Write(b []byte) (int, error) {
+
+	if !c.waiting {
+		c.sent = time.Now()
+		c.waiting = true
+	}
+
+	return c.Conn.Write(b)
+}
+
+func (c *timedRedisConn) Read(b []byte) (int, error) {
+
+	n, err := c.Conn.Read(b)
+	if c.waiting && n > 0 {
+		redisLatency.Since(c.sent)
+		c.waiting = false
+	}
+
+	return n, err
+}
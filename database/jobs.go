@@ -0,0 +1,143 @@
+package thordb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+)
+
+// singleton background jobs run by the elected master replica
+
+var ErrStaleFencingToken = errors.New("thordb: stale fencing token")
+
+// checkFence records token as the newest seen for the lease name and fails if a
+// newer leader has already written, so a deposed leader can't clobber its work.
+func checkFence(tx *sql.Tx, name string, token int64) error {
+
+	res, err := tx.Exec("INSERT INTO leader_fences (name, token) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET token = EXCLUDED.token WHERE leader_fences.token <= EXCLUDED.token", name, token)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrStaleFencingToken
+	}
+
+	return nil
+}
+
+// ReapStaleMachines removes machines that stopped sending heartbeats, and
+// fails the games they were hosting. revoked machines are kept for the audit
+// trail, and so they can't enroll again. it returns the number of machines
+// removed.
+func ReapStaleMachines(lease string, token int64, maxAge time.Duration) (int, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkFence(tx, lease, token)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+
+	rows, err := tx.Query("SELECT machine_id FROM machines_metadata JOIN machines USING (machine_id) WHERE last_heartbeat < $1 AND approval <> $2", cutoff, MachineRevoked)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	machineIds := make([]int, 0)
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		machineIds = append(machineIds, id)
+	}
+	rows.Close()
+
+	for _, machineId := range machineIds {
+
+		err = failMachineGames(tx, machineId, "host stopped sending heartbeats")
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		_, err = tx.Exec("DELETE FROM machines WHERE machine_id = $1", machineId)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, machineId := range machineIds {
+		kvstore.Del(fmt.Sprintf(machineSessionKey, machineId))
+	}
+
+	if len(machineIds) > 0 {
+		log.Info("reaped stale machines", "count", len(machineIds), "machines", machineIds)
+	}
+
+	return len(machineIds), nil
+}
+
+// FailStuckGames fails games that have waited longer than maxAge for a host
+// or for their game server to register. it returns the number of games failed.
+func FailStuckGames(lease string, token int64, maxAge time.Duration) (int, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkFence(tx, lease, token)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	res, err := tx.Exec("UPDATE games SET state = $1, state_reason = $2 WHERE state IN "+movableTo(model.GameFailed, model.GameRequested, model.GameProvisioning, model.GameLoading)+" AND state_changed_on < $3", model.GameFailed, "timed out before running", time.Now().Add(-maxAge))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if count > 0 {
+		log.Info("failed games stuck before running", "count", count)
+	}
+
+	return int(count), nil
+}
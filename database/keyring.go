@@ -0,0 +1,312 @@
+package thordb
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/auth"
+	"github.com/lib/pq"
+)
+
+// signing keys. the ring lives in postgres so every master replica signs with
+// the same key: each replica reloads it with RefreshKeyring and the elected
+// leader rotates it with RotateSigningKeys. a new key is published
+// keyPrePublish before it signs anything, so hosts caching the jwks see it in
+// time, and a retired key keeps verifying until every token it signed has
+// expired.
+//
+// keys/app.rsa seeds the ring on first start. it stays trusted for admin
+// tokens after it retires, since cmd/admin-token signs with it offline, and
+// stays in the jwks so hosts accept those tokens too.
+
+const signingKeyBits int = 2048
+const keyPrePublish time.Duration = time.Hour
+const keyRetention time.Duration = machineTokenTTL
+
+const DefaultKeyRotation time.Duration = 30 * 24 * time.Hour
+const KeyRefreshInterval time.Duration = time.Minute
+
+var ErrNoSigningKey = errors.New("thordb: no active signing key")
+
+type keyring struct {
+	mu        sync.RWMutex
+	active    *auth.SigningKey
+	published auth.KeySet
+	operator  *auth.SigningKey
+}
+
+var ring = &keyring{published: auth.KeySet{}}
+
+func (r *keyring) PublicKey(kid string) (*rsa.PublicKey, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if key, ok := r.published[kid]; ok {
+		return key, nil
+	}
+
+	if r.operator != nil && kid == r.operator.Id {
+		return &r.operator.Key.PublicKey, nil
+	}
+
+	return nil, auth.ErrUnknownKey
+}
+
+// operatorOnly reports whether kid is the operator key and no longer in the ring
+func (r *keyring) operatorOnly(kid string) bool {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.published[kid]
+	return !ok && r.operator != nil && kid == r.operator.Id
+}
+
+func (r *keyring) signingKey() (*auth.SigningKey, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.active == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return r.active, nil
+}
+
+// bootstrapKeyring seeds an empty ring with the operator key, or with a new
+// key without keys/app.rsa, so a fresh install can sign from the start
+func bootstrapKeyring(key *rsa.PrivateKey) error {
+
+	if key == nil {
+
+		var empty bool
+		err := db.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM signing_keys)").Scan(&empty)
+		if err != nil || !empty {
+			return err
+		}
+
+		key, err = rsa.GenerateKey(rand.Reader, signingKeyBits)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("INSERT INTO signing_keys (kid, private_key, created_on, activated_on) SELECT $1, $2, $3, $3 WHERE NOT EXISTS (SELECT 1 FROM signing_keys)", auth.Thumbprint(&key.PublicKey), encodeKey(key), time.Now())
+		return err
+	}
+
+	signing := auth.NewSigningKey(key)
+
+	ring.mu.Lock()
+	ring.operator = signing
+	ring.mu.Unlock()
+
+	now := time.Now()
+	_, err := db.Exec("INSERT INTO signing_keys (kid, private_key, created_on, activated_on) SELECT $1, $2, $3, $3 WHERE NOT EXISTS (SELECT 1 FROM signing_keys)", signing.Id, encodeKey(key), now)
+	return err
+}
+
+// RefreshKeyring reloads the signing keys from postgres.
+func RefreshKeyring() error {
+
+	rows, err := db.Query("SELECT kid, private_key, activated_on, retired_on FROM signing_keys WHERE retired_on IS NULL OR retired_on > $1 ORDER BY created_on", time.Now().Add(-keyRetention))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	published := make(auth.KeySet)
+	var active *auth.SigningKey
+
+	for rows.Next() {
+
+		var kid string
+		var pemKey string
+		var activated, retired pq.NullTime
+
+		err = rows.Scan(&kid, &pemKey, &activated, &retired)
+		if err != nil {
+			return err
+		}
+
+		key, err := decodeKey(pemKey)
+		if err != nil {
+			return err
+		}
+
+		published[kid] = &key.PublicKey
+
+		// ordered by age, so the newest active key wins
+		if activated.Valid && !retired.Valid {
+			active = &auth.SigningKey{Id: kid, Key: key}
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	ring.mu.Lock()
+	ring.published = published
+	if active != nil {
+		ring.active = active
+	}
+	ring.mu.Unlock()
+
+	return nil
+}
+
+// RotateSigningKeys is run by the elected leader. once the active key is
+// within keyPrePublish of being interval old it publishes the next key, and
+// once the active key is interval old it switches to that key and retires the
+// old one. it returns whether the active key changed.
+func RotateSigningKeys(lease string, token int64, interval time.Duration) (bool, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	err = checkFence(tx, lease, token)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	now := time.Now()
+	rotated := false
+
+	var activeKid string
+	var activatedOn time.Time
+	err = tx.QueryRow("SELECT kid, activated_on FROM signing_keys WHERE activated_on IS NOT NULL AND retired_on IS NULL ORDER BY activated_on DESC LIMIT 1 FOR UPDATE").Scan(&activeKid, &activatedOn)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return false, err
+	}
+
+	var pendingKid string
+	var pendingCreated time.Time
+	err = tx.QueryRow("SELECT kid, created_on FROM signing_keys WHERE activated_on IS NULL ORDER BY created_on LIMIT 1 FOR UPDATE").Scan(&pendingKid, &pendingCreated)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return false, err
+	}
+
+	due := activatedOn.Add(interval)
+
+	switch {
+	case pendingKid == "" && !now.Before(due.Add(-keyPrePublish)):
+		pendingKid, err = insertSigningKey(tx, now)
+		// with no active key at all there is nothing to wait for
+		if err == nil && activeKid == "" {
+			err = activateSigningKey(tx, pendingKid, "", now)
+			rotated = true
+		}
+
+	case pendingKid != "" && !now.Before(due) && now.Sub(pendingCreated) >= keyPrePublish:
+		err = activateSigningKey(tx, pendingKid, activeKid, now)
+		rotated = true
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec("DELETE FROM signing_keys WHERE retired_on < $1", now.Add(-keyRetention))
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return rotated, RefreshKeyring()
+}
+
+// JWKS returns the public half of every key in the ring, and of the operator
+// key.
+func JWKS() *auth.JWKS {
+
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	keys := make(auth.KeySet, len(ring.published)+1)
+	for kid, key := range ring.published {
+		keys[kid] = key
+	}
+	if ring.operator != nil {
+		keys[ring.operator.Id] = &ring.operator.Key.PublicKey
+	}
+
+	kids := make([]string, 0, len(keys))
+	for kid := range keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := &auth.JWKS{Keys: make([]auth.JWK, 0, len(kids))}
+	for _, kid := range kids {
+		jwks.Keys = append(jwks.Keys, auth.NewJWK(keys[kid]))
+	}
+
+	return jwks
+}
+
+func insertSigningKey(tx *sql.Tx, now time.Time) (string, error) {
+
+	key, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return "", err
+	}
+
+	kid := auth.Thumbprint(&key.PublicKey)
+	_, err = tx.Exec("INSERT INTO signing_keys (kid, private_key, created_on) VALUES ($1, $2, $3)", kid, encodeKey(key), now)
+	if err != nil {
+		return "", err
+	}
+
+	return kid, nil
+}
+
+func activateSigningKey(tx *sql.Tx, kid string, previous string, now time.Time) error {
+
+	_, err := tx.Exec("UPDATE signing_keys SET activated_on = $1 WHERE kid = $2", now, kid)
+	if err != nil {
+		return err
+	}
+
+	if previous == "" {
+		return nil
+	}
+
+	_, err = tx.Exec("UPDATE signing_keys SET retired_on = $1 WHERE kid = $2", now, previous)
+	return err
+}
+
+func encodeKey(key *rsa.PrivateKey) string {
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func decodeKey(pemKey string) (*rsa.PrivateKey, error) {
+
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("thordb: bad signing key encoding")
+	}
+
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
@@ -1,137 +1,258 @@
 package thordb
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/jaybennett89/thorium-go/auth"
 )
 
 const machineSessionKey string = "machines/%d"
 const hkeyMachineToken string = "machineToken"
 
-func RegisterMachine(remoteAddress string, servicePort int) (int, string, error) {
+// RegisterMachine enrolls a new machine with a join token, in the region and
+// zone it declared. it returns the machine id, its key and its approval
+// state.
+func RegisterMachine(remoteAddress string, servicePort int, region string, zone string, joinToken string) (int, string, string, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	tokenId, autoApprove, err := useJoinToken(tx, joinToken)
+	if err != nil {
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	approval := MachinePending
+	if autoApprove {
+		approval = MachineApproved
+	}
 
 	var machineId int
-	err := db.QueryRow("INSERT INTO machines (remote_address, service_listen_port) VALUES ($1, $2) RETURNING machine_id", remoteAddress, servicePort).Scan(&machineId)
+	err = tx.QueryRow("INSERT INTO machines (remote_address, service_listen_port, region, zone, approval, join_token_id, enrolled_on) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING machine_id",
+		remoteAddress, servicePort, region, zone, approval, tokenId, time.Now()).Scan(&machineId)
 	if err != nil {
-		return 0, "", err
+		tx.Rollback()
+		return 0, "", "", err
 	}
 
-	token := jwt.New(jwt.SigningMethodRS256)
-	token.Claims["machineId"] = machineId
-	token.Claims["iat"] = time.Now()
 	var token_str string
-	token_str, err = token.SignedString(signKey)
+	token_str, err = IssueToken(auth.MachineClaims(machineId, machineTokenTTL))
 	if err != nil {
-		return 0, "", err
+		tx.Rollback()
+		return 0, "", "", err
 	}
 
-	_, err = db.Exec("INSERT INTO machines_metadata  VALUES ($1, $2, $3, $4, $5, $6)", machineId, token_str, time.Now(), 0.0, 0.0, 0.0)
+	_, err = tx.Exec("INSERT INTO machines_metadata  VALUES ($1, $2, $3, $4, $5, $6)", machineId, token_str, time.Now(), 0.0, 0.0, 0.0)
 	if err != nil {
-		return 0, "", err
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", "", err
 	}
 
 	var ok bool
 	ok, err = kvstore.HSet(fmt.Sprintf(machineSessionKey, machineId), hkeyMachineToken, token_str).Result()
 	if err != nil {
-		return 0, "", err
+		return 0, "", "", err
 	}
 	if !ok {
-		return 0, "", errors.New("thordb: unable to set machine token in redis")
+		return 0, "", "", errors.New("thordb: unable to set machine token in redis")
 	}
 	kvstore.Expire(fmt.Sprintf(machineSessionKey, machineId), time.Second*120)
 
-	return machineId, token_str, nil
+	return machineId, token_str, approval, nil
 }
 
-func UnregisterMachine(machineToken string) (bool, error) {
+// RenewMachine re-registers a machine that lost its session, for example
+// after a master restart or a missed heartbeat window, and rotates its key.
+// previousKey must be the machine's most recent key, so a key that was
+// already rotated can't renew again. it returns the new key and a fresh game
+// server token for each of games the master still has on the machine, along
+// with the machine's approval state. the machine's address, port, region and
+// zone are replaced with the ones it declares now.
+// ErrMachineNotExist means the machine was reaped and has to register anew.
+func RenewMachine(previousKey string, remoteAddress string, servicePort int, region string, zone string, games []int) (int, string, string, map[int]string, error) {
 
-	machineId, err := validateMachineToken(machineToken)
+	c, err := auth.Parse(previousKey, ring)
 	if err != nil {
-		return false, err
+		return 0, "", "", nil, ErrInvalidMachineKey
 	}
 
-	res, err := db.Exec("DELETE FROM machines WHERE machine_id = $1", machineId)
+	err = c.Require(auth.RoleMachine, "")
 	if err != nil {
-		log.Print("couldn't delete machine from postgres")
-	} else {
-		var rows int64
-		rows, err = res.RowsAffected()
-		if err != nil {
-			log.Print("couldnt read rows affected")
-		} else if rows == 0 {
-			log.Print("couldn't delete machine from postgres - does not exist")
-		}
+		return 0, "", "", nil, ErrInvalidMachineKey
+	}
+
+	machineId := c.MachineId
+
+	var mostRecentKey sql.NullString
+	var approval string
+	err = db.QueryRow("SELECT most_recent_key, approval FROM machines_metadata JOIN machines USING (machine_id) WHERE machine_id = $1", machineId).Scan(&mostRecentKey, &approval)
+	if err == sql.ErrNoRows {
+		return 0, "", "", nil, ErrMachineNotExist
+	} else if err != nil {
+		return 0, "", "", nil, err
+	}
+
+	if approval == MachineRevoked {
+		return 0, "", "", nil, ErrMachineRevoked
 	}
 
-	var count int64
-	count, err = kvstore.Del(fmt.Sprintf(machineSessionKey, machineId)).Result()
+	if mostRecentKey.String != previousKey {
+		return 0, "", "", nil, ErrInvalidMachineKey
+	}
+
+	token, err := IssueToken(auth.MachineClaims(machineId, machineTokenTTL))
 	if err != nil {
-		log.Print("couldn't delete machine from redis cache")
-		log.Print(err)
+		return 0, "", "", nil, err
 	}
 
-	if count == 0 {
-		log.Print("couldn't delete machine from redis cache")
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", "", nil, err
 	}
-	return true, nil
-}
 
-func UpdateMachineStatus(machineToken string, usageCpu float64, usageNetwork float64, usagePlayerCapacity float64) error {
+	_, err = tx.Exec("UPDATE machines SET remote_address = $1, service_listen_port = $2, region = $3, zone = $4 WHERE machine_id = $5", remoteAddress, servicePort, region, zone, machineId)
+	if err != nil {
+		tx.Rollback()
+		return 0, "", "", nil, err
+	}
 
-	machineId, err := validateMachineToken(machineToken)
+	// compare and swap so two renewals racing with the same key can't both win
+	res, err := tx.Exec("UPDATE machines_metadata SET most_recent_key = $1, last_heartbeat = $2 WHERE machine_id = $3 AND most_recent_key = $4", token, time.Now(), machineId, previousKey)
 	if err != nil {
-		return err
+		tx.Rollback()
+		return 0, "", "", nil, err
 	}
 
-	// ToDo: use this later to check that 1 row was updated
-	//var res sql.Result
-	_, err = db.Exec("UPDATE machines_metadata SET last_heartbeat = $1, cpu_usage_pct = $2, network_usage_pct = $3, player_occupancy_pct = $4 WHERE machine_id = $5",
-		time.Now(), usageCpu, usageNetwork, usagePlayerCapacity, machineId)
+	rows, err := res.RowsAffected()
 	if err != nil {
-		return err
+		tx.Rollback()
+		return 0, "", "", nil, err
 	}
 
-	kvstore.Expire(fmt.Sprintf(machineSessionKey, machineId), time.Second*120)
+	if rows == 0 {
+		tx.Rollback()
+		return 0, "", "", nil, ErrInvalidMachineKey
+	}
 
-	return nil
-}
+	gameTokens := make(map[int]string)
+	for _, gameId := range games {
 
-func TestMachineRequest() {
-	_, err := kvstore.Ping().Result()
+		err = checkGameServer(gameId, machineId, activeGameStates)
+		if err == ErrInvalidGameToken {
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			return 0, "", "", nil, err
+		}
+
+		gameTokens[gameId], err = IssueToken(auth.GameServerClaims(gameId, machineId, gameServerTokenTTL))
+		if err != nil {
+			tx.Rollback()
+			return 0, "", "", nil, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		log.Print(err)
+		return 0, "", "", nil, err
 	}
 
-	log.Print("Made it!")
+	key := fmt.Sprintf(machineSessionKey, machineId)
+	err = kvstore.HSet(key, hkeyMachineToken, token).Err()
+	if err != nil {
+		return 0, "", "", nil, err
+	}
+	kvstore.Expire(key, time.Second*120)
+
+	return machineId, token, approval, gameTokens, nil
 }
 
-func validateMachineToken(token_str string) (int, error) {
-	token, err := jwt.Parse(token_str, func(t *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
+// UnregisterMachine removes a machine that is shutting down, and fails the
+// games it was still hosting. it returns false when the machine was already
+// gone.
+func UnregisterMachine(machineId int) (bool, error) {
+
+	tx, err := db.Begin()
 	if err != nil {
-		return 0, err
+		return false, err
 	}
 
-	var idFloat64 float64
-	idFloat64, ok := token.Claims["machineId"].(float64)
-	id := int(idFloat64)
-	if !ok {
-		return 0, errors.New("thordb: invalid session")
+	err = failMachineGames(tx, machineId, "machine unregistered")
+	if err != nil {
+		tx.Rollback()
+		return false, err
 	}
 
-	var savedToken string
-	savedToken, err = kvstore.HGet(fmt.Sprintf(machineSessionKey, id), hkeyMachineToken).Result()
+	res, err := tx.Exec("DELETE FROM machines WHERE machine_id = $1", machineId)
 	if err != nil {
-		return 0, err
+		tx.Rollback()
+		return false, err
 	}
-	if token_str == savedToken {
-		return id, nil
-	} else {
-		return 0, errors.New("thordb: invalid session")
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	count, err := kvstore.Del(fmt.Sprintf(machineSessionKey, machineId)).Result()
+	if err != nil {
+		log.Error("couldn't delete machine from redis cache", "machine", machineId, "err", err)
+	} else if count == 0 {
+		log.Warn("machine had no session in redis cache", "machine", machineId)
 	}
+
+	if rows == 0 {
+		log.Warn("couldn't delete machine from postgres, it does not exist", "machine", machineId)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// UpdateMachineStatus records a heartbeat and returns the machine's approval,
+// so a pending host learns it was approved
+func UpdateMachineStatus(machineId int, usageCpu float64, usageNetwork float64, usagePlayerCapacity float64) (string, error) {
+
+	var approval string
+	err := db.QueryRow(`UPDATE machines_metadata mm SET last_heartbeat = $1, cpu_usage_pct = $2, network_usage_pct = $3, player_occupancy_pct = $4
+		FROM machines m
+		WHERE mm.machine_id = $5 AND m.machine_id = mm.machine_id
+		RETURNING m.approval`,
+		time.Now(), usageCpu, usageNetwork, usagePlayerCapacity, machineId).Scan(&approval)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", ErrMachineNotExist
+	case err != nil:
+		return "", err
+	}
+
+	kvstore.Expire(fmt.Sprintf(machineSessionKey, machineId), time.Second*120)
+
+	return approval, nil
+}
+
+func TestMachineRequest() {
+	_, err := kvstore.Ping().Result()
+	if err != nil {
+		log.Error("redis ping failed", "err", err)
+	}
+
+	log.Debug("machine request test complete")
 }
@@ -0,0 +1,310 @@
+package thordb
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+	request "github.com/jaybennett89/thorium-go/requests"
+)
+
+// match results. a game's results are recorded once, and each character's
+// lifetime record and stat totals are added to in the same transaction, so
+// the totals are always the sum of the matches kept. a game server reports
+// while its game is running or ending, before it shuts down, and only on the
+// characters its game admitted.
+
+var ErrResultsReported = errors.New("thordb: results already reported")
+var ErrCharacterNotExist = errors.New("thordb: character does not exist")
+var ErrCharacterNotInGame = errors.New("thordb: character did not play in the game")
+
+// RecordMatchResults stores a game's results, which the caller has checked,
+// and adds them to the players' lifetime records
+func RecordMatchResults(gameId int, results *request.MatchResults) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = recordMatch(tx, gameId, results)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func recordMatch(tx *sql.Tx, gameId int, results *request.MatchResults) error {
+
+	// locked so a game can't end between the check and the insert
+	var state string
+	err := tx.QueryRow("SELECT state FROM games WHERE game_id = $1 FOR UPDATE", gameId).Scan(&state)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrGameNotExist
+	case err != nil:
+		return err
+	case state == model.GameEnded || state == model.GameFailed:
+		return ErrGameEnded
+	case state != model.GameRunning && state != model.GameEnding:
+		return ErrGameState
+	}
+
+	reportedOn := time.Now()
+
+	_, err = tx.Exec("INSERT INTO match_results (game_id, reported_on) VALUES ($1, $2)", gameId, reportedOn)
+	if isUniqueViolation(err) {
+		return ErrResultsReported
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range results.Players {
+
+		var played bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM game_players WHERE game_id = $1 AND character_id = $2)", gameId, p.CharacterId).Scan(&played)
+		if err != nil {
+			return err
+		}
+
+		if !played {
+			return ErrCharacterNotInGame
+		}
+
+		_, err = tx.Exec("INSERT INTO match_players (game_id, character_id, outcome, team, score) VALUES ($1, $2, $3, $4, $5)", gameId, p.CharacterId, p.Outcome, p.Team, p.Score)
+		if isForeignKeyViolation(err) {
+			return ErrCharacterNotExist
+		}
+		if err != nil {
+			return err
+		}
+
+		for stat, value := range p.Stats {
+			_, err = tx.Exec("INSERT INTO match_stats (game_id, character_id, stat, value) VALUES ($1, $2, $3, $4)", gameId, p.CharacterId, stat, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO character_records (character_id, matches, wins, losses, draws, abandoned, total_score, best_score, last_match_on)
+		SELECT character_id, 1, (outcome = 'win')::int, (outcome = 'loss')::int, (outcome = 'draw')::int, (outcome = 'abandoned')::int, score, score, $2
+		FROM match_players
+		WHERE game_id = $1
+		ON CONFLICT (character_id) DO UPDATE SET
+			matches = character_records.matches + 1,
+			wins = character_records.wins + EXCLUDED.wins,
+			losses = character_records.losses + EXCLUDED.losses,
+			draws = character_records.draws + EXCLUDED.draws,
+			abandoned = character_records.abandoned + EXCLUDED.abandoned,
+			total_score = character_records.total_score + EXCLUDED.total_score,
+			best_score = GREATEST(character_records.best_score, EXCLUDED.best_score),
+			last_match_on = EXCLUDED.last_match_on`, gameId, reportedOn)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO character_stats (character_id, stat, total, best)
+		SELECT character_id, stat, value, value
+		FROM match_stats
+		WHERE game_id = $1
+		ON CONFLICT (character_id, stat) DO UPDATE SET
+			total = character_stats.total + EXCLUDED.total,
+			best = GREATEST(character_stats.best, EXCLUDED.best)`, gameId)
+	return err
+}
+
+// GetCharacterProfile returns a character's public profile and lifetime
+// record
+func GetCharacterProfile(characterId int) (*model.CharacterProfile, error) {
+
+	character, err := GetCharacter(characterId)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrCharacterNotExist
+	case err != nil:
+		return nil, err
+	}
+
+	profile := model.CharacterProfile{
+		CharacterId: character.CharacterId,
+		Name:        character.Name,
+		ClassId:     character.ClassId,
+		Level:       character.Level,
+	}
+
+	r := &profile.Record
+	var lastMatchOn time.Time
+
+	err = db.QueryRow("SELECT matches, wins, losses, draws, abandoned, total_score, best_score, last_match_on FROM character_records WHERE character_id = $1", characterId).Scan(&r.Matches, &r.Wins, &r.Losses, &r.Draws, &r.Abandoned, &r.TotalScore, &r.BestScore, &lastMatchOn)
+	switch {
+	case err == sql.ErrNoRows:
+		// hasn't played a match
+	case err != nil:
+		return nil, err
+	default:
+		r.LastMatchOn = &lastMatchOn
+	}
+
+	rows, err := db.Query("SELECT stat, total, best FROM character_stats WHERE character_id = $1", characterId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r.Stats = make(map[string]model.StatTotal)
+	for rows.Next() {
+
+		var stat string
+		var total model.StatTotal
+		err = rows.Scan(&stat, &total.Total, &total.Best)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Stats[stat] = total
+	}
+
+	return &profile, rows.Err()
+}
+
+// ListCharacterMatches returns a page of a character's matches, newest
+// first, and the cursor of the next page, empty on the last one
+func ListCharacterMatches(characterId int, page request.MatchPage) ([]model.CharacterMatch, string, error) {
+
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM characters WHERE id = $1)", characterId).Scan(&exists)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !exists {
+		return nil, "", ErrCharacterNotExist
+	}
+
+	limit := page.Limit
+	if limit <= 0 || limit > request.MaxMatchPage {
+		limit = request.DefaultMatchPage
+	}
+
+	where := "mp.character_id = $1"
+	args := []interface{}{characterId}
+
+	if page.Cursor != "" {
+		before, err := decodeMatchCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		where += " AND mp.game_id < $2"
+		args = append(args, before)
+	}
+
+	// one more than the page, to tell whether there is a next one
+	rows, err := db.Query(`SELECT mp.game_id, g.map_name, g.game_mode, r.reported_on, mp.outcome, mp.team, mp.score
+		FROM match_players mp
+		  JOIN match_results r USING (game_id)
+		  JOIN games g USING (game_id)
+		WHERE `+where+fmt.Sprintf(" ORDER BY mp.game_id DESC LIMIT %d", limit+1), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	matches := make([]model.CharacterMatch, 0)
+	for rows.Next() {
+
+		m := model.CharacterMatch{Stats: make(map[string]float64)}
+		err = rows.Scan(&m.GameId, &m.Map, &m.Mode, &m.ReportedOn, &m.Outcome, &m.Team, &m.Score)
+		if err != nil {
+			return nil, "", err
+		}
+
+		matches = append(matches, m)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(matches) > limit {
+		matches = matches[:limit]
+		next = encodeMatchCursor(matches[limit-1].GameId)
+	}
+
+	if len(matches) == 0 {
+		return matches, "", nil
+	}
+
+	err = loadMatchStats(characterId, matches)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return matches, next, nil
+}
+
+// loadMatchStats fills in the stats of a page of a character's matches,
+// which are ordered newest first
+func loadMatchStats(characterId int, matches []model.CharacterMatch) error {
+
+	index := make(map[int]int, len(matches))
+	for i, m := range matches {
+		index[m.GameId] = i
+	}
+
+	rows, err := db.Query("SELECT game_id, stat, value FROM match_stats WHERE character_id = $1 AND game_id BETWEEN $2 AND $3", characterId, matches[len(matches)-1].GameId, matches[0].GameId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var gameId int
+		var stat string
+		var value float64
+		err = rows.Scan(&gameId, &stat, &value)
+		if err != nil {
+			return err
+		}
+
+		if i, ok := index[gameId]; ok {
+			matches[i].Stats[stat] = value
+		}
+	}
+
+	return rows.Err()
+}
+
+// a match cursor is the id of the last game on its page. it is opaque to
+// clients.
+func encodeMatchCursor(gameId int) string {
+
+	return base64.RawURLEncoding.EncodeToString([]byte("matches:" + strconv.Itoa(gameId)))
+}
+
+func decodeMatchCursor(cursor string) (int, error) {
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "matches:") {
+		return 0, ErrBadCursor
+	}
+
+	gameId, err := strconv.Atoi(strings.TrimPrefix(string(raw), "matches:"))
+	if err != nil {
+		return 0, ErrBadCursor
+	}
+
+	return gameId, nil
+}
@@ -0,0 +1,250 @@
+package thordb
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+
+	"github.com/lib/pq"
+)
+
+// account standing: bans, suspensions and feature restrictions
+
+// restriction kinds
+const RestrictBan string = "ban"
+const RestrictSuspension string = "suspension"
+const RestrictChat string = "chat"
+const RestrictMatchmaking string = "matchmaking"
+
+// moderation history actions
+const moderationApplied string = "applied"
+const moderationLifted string = "lifted"
+
+var ErrAccountBanned = errors.New("thordb: account banned")
+var ErrAccountSuspended = errors.New("thordb: account suspended")
+var ErrMatchmakingRestricted = errors.New("thordb: matchmaking restricted")
+var ErrInvalidRestriction = errors.New("thordb: invalid restriction")
+var ErrRestrictionNotExist = errors.New("thordb: restriction does not exist")
+var ErrAccountNotExist = errors.New("thordb: account does not exist")
+
+// GetActiveRestrictions returns the restrictions currently in force for uid.
+func GetActiveRestrictions(uid int) ([]model.Restriction, error) {
+
+	return queryRestrictions("SELECT restriction_id, user_id, kind, reason, actor, created_on, expires_on, lifted_on, lifted_by FROM account_restrictions WHERE user_id = $1 AND lifted_on IS NULL AND (expires_on IS NULL OR expires_on > $2) ORDER BY created_on", uid, time.Now())
+}
+
+// GetRestrictions returns every restriction ever applied to uid, including lifted and expired ones.
+func GetRestrictions(uid int) ([]model.Restriction, error) {
+
+	return queryRestrictions("SELECT restriction_id, user_id, kind, reason, actor, created_on, expires_on, lifted_on, lifted_by FROM account_restrictions WHERE user_id = $1 ORDER BY created_on", uid)
+}
+
+// AddRestriction records a restriction against uid. bans are permanent,
+// every other kind needs a duration. bans and suspensions end any live session.
+func AddRestriction(uid int, kind string, reason string, actor string, duration time.Duration) (*model.Restriction, error) {
+
+	switch kind {
+	case RestrictBan:
+		if duration != 0 {
+			return nil, ErrInvalidRestriction
+		}
+	case RestrictSuspension, RestrictChat, RestrictMatchmaking:
+		if duration <= 0 {
+			return nil, ErrInvalidRestriction
+		}
+	default:
+		return nil, ErrInvalidRestriction
+	}
+
+	now := time.Now()
+	r := model.Restriction{UserId: uid, Kind: kind, Reason: reason, Actor: actor, CreatedOn: now}
+
+	var expires pq.NullTime
+	if duration > 0 {
+		expires = pq.NullTime{Time: now.Add(duration), Valid: true}
+		r.ExpiresOn = &expires.Time
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM account_data WHERE user_id = $1)", uid).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if !exists {
+		tx.Rollback()
+		return nil, ErrAccountNotExist
+	}
+
+	err = tx.QueryRow("INSERT INTO account_restrictions (user_id, kind, reason, actor, created_on, expires_on) VALUES ($1, $2, $3, $4, $5, $6) RETURNING restriction_id", uid, kind, reason, actor, now, expires).Scan(&r.RestrictionId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = recordModeration(tx, &r, moderationApplied, reason, actor, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == RestrictBan || kind == RestrictSuspension {
+
+		_, err = KickPlayer(uid)
+		if err != nil {
+			log.Warn("couldn't end session of restricted account", "uid", uid, "err", err)
+		}
+	}
+
+	return &r, nil
+}
+
+// LiftRestriction ends a restriction early and records who lifted it.
+func LiftRestriction(uid int, restrictionId int, reason string, actor string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	var r model.Restriction
+	var expires pq.NullTime
+	err = tx.QueryRow("UPDATE account_restrictions SET lifted_on = $1, lifted_by = $2 WHERE restriction_id = $3 AND user_id = $4 AND lifted_on IS NULL RETURNING restriction_id, user_id, kind, expires_on", now, actor, restrictionId, uid).Scan(&r.RestrictionId, &r.UserId, &r.Kind, &expires)
+	switch {
+	case err == sql.ErrNoRows:
+		tx.Rollback()
+		return ErrRestrictionNotExist
+	case err != nil:
+		tx.Rollback()
+		return err
+	}
+
+	err = recordModeration(tx, &r, moderationLifted, reason, actor, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetModerationHistory returns the audit trail of restrictions applied to and lifted from uid.
+func GetModerationHistory(uid int) ([]model.ModerationRecord, error) {
+
+	rows, err := db.Query("SELECT record_id, restriction_id, user_id, action, kind, reason, actor, recorded_on FROM moderation_history WHERE user_id = $1 ORDER BY recorded_on", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.ModerationRecord, 0)
+
+	for rows.Next() {
+		var rec model.ModerationRecord
+		err = rows.Scan(&rec.RecordId, &rec.RestrictionId, &rec.UserId, &rec.Action, &rec.Kind, &rec.Reason, &rec.Actor, &rec.RecordedOn)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rec)
+	}
+
+	return list, nil
+}
+
+// checkStanding fails if uid is banned or suspended. it also returns the
+// active restrictions so callers can enforce the narrower kinds.
+func checkStanding(uid int) ([]model.Restriction, error) {
+
+	active, err := GetActiveRestrictions(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range active {
+		switch r.Kind {
+		case RestrictBan:
+			return nil, ErrAccountBanned
+		case RestrictSuspension:
+			return nil, ErrAccountSuspended
+		}
+	}
+
+	return active, nil
+}
+
+func hasRestriction(active []model.Restriction, kind string) bool {
+
+	for _, r := range active {
+		if r.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func restrictionKinds(active []model.Restriction) []string {
+
+	kinds := make([]string, 0, len(active))
+	for _, r := range active {
+		kinds = append(kinds, r.Kind)
+	}
+
+	return kinds
+}
+
+func recordModeration(tx *sql.Tx, r *model.Restriction, action string, reason string, actor string, at time.Time) error {
+
+	_, err := tx.Exec("INSERT INTO moderation_history (restriction_id, user_id, action, kind, reason, actor, recorded_on) VALUES ($1, $2, $3, $4, $5, $6, $7)", r.RestrictionId, r.UserId, action, r.Kind, reason, actor, at)
+	return err
+}
+
+func queryRestrictions(query string, args ...interface{}) ([]model.Restriction, error) {
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]model.Restriction, 0)
+
+	for rows.Next() {
+
+		var r model.Restriction
+		var expires, lifted pq.NullTime
+		var liftedBy sql.NullString
+
+		err = rows.Scan(&r.RestrictionId, &r.UserId, &r.Kind, &r.Reason, &r.Actor, &r.CreatedOn, &expires, &lifted, &liftedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		if expires.Valid {
+			r.ExpiresOn = &expires.Time
+		}
+		if lifted.Valid {
+			r.LiftedOn = &lifted.Time
+			r.LiftedBy = liftedBy.String
+		}
+
+		list = append(list, r)
+	}
+
+	return list, nil
+}
@@ -5,14 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"github.com/jaybennett89/thorium-go/requests"
 )
 
 func ProvisionNewGame(game_id int, map_name string, game_mode string) error {
 
-	log.Print("starting new game on %s (%s)", map_name, game_mode)
+	log.Info("starting new game", "game", game_id, "map", map_name, "mode", game_mode)
 
 	var (
 		address      string
@@ -22,7 +21,7 @@ func ProvisionNewGame(game_id int, map_name string, game_mode string) error {
 
 	err := db.QueryRow("SELECT * FROM get_available_machine()").Scan(&address, &port, &machineToken)
 	if err != nil {
-		log.Print("no available machines")
+		log.Warn("no available machines", "game", game_id)
 		return errors.New("thordb: does not exist")
 	}
 
@@ -37,7 +36,7 @@ func ProvisionNewGame(game_id int, map_name string, game_mode string) error {
 		return err
 	}
 	endpoint := fmt.Sprintf("http://%s:%d/games/%d", address, port, game_id)
-	log.Printf("provisioner: posting new game to @%s", endpoint)
+	log.Debug("posting new game", "endpoint", endpoint)
 
 	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonBytes))
 	if err != nil {
@@ -56,7 +55,7 @@ func ProvisionNewGame(game_id int, map_name string, game_mode string) error {
 	if response.StatusCode != 200 {
 		return errors.New("thordb: failed to initialize resource")
 	} else {
-		log.Print("provisioner: new game request ok")
+		log.Debug("new game request ok", "game", game_id)
 	}
 	return nil
 }
@@ -0,0 +1,173 @@
+package thordb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+// account recovery. a reset token is only ever handed to the notifier: the
+// database keeps its sha256, so a leaked table can't be used to take over
+// accounts. tokens are single use and expire after PasswordResetTTL.
+
+const PasswordResetTTL time.Duration = 30 * time.Minute
+const resetTokenSize int = 32
+
+var ErrInvalidEmail = errors.New("thordb: invalid email")
+var ErrNoRecoveryContact = errors.New("thordb: no recovery contact")
+var ErrInvalidResetToken = errors.New("thordb: invalid reset token")
+
+// SetRecoveryEmail changes the recovery contact of an account. an empty email
+// removes it.
+func SetRecoveryEmail(uid int, email string) error {
+
+	email, err := checkEmail(email)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE account_data SET email = $1 WHERE user_id = $2", nullString(email), uid)
+	return err
+}
+
+// RequestPasswordReset issues a reset token for username and returns the
+// address it should be sent to. earlier unused tokens stop working.
+func RequestPasswordReset(username string) (int, string, string, error) {
+
+	var uid int
+	var email sql.NullString
+
+	err := db.QueryRow("SELECT user_id, email FROM account_data WHERE username_key = $1", validation.Key(username)).Scan(&uid, &email)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, "", "", ErrAccountNotExist
+	case err != nil:
+		return 0, "", "", err
+	case !email.Valid:
+		return uid, "", "", ErrNoRecoveryContact
+	}
+
+	token, err := randomToken(resetTokenSize)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	_, err = tx.Exec("DELETE FROM password_resets WHERE user_id = $1 AND used_on IS NULL", uid)
+	if err != nil {
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	_, err = tx.Exec("INSERT INTO password_resets (user_id, token_hash, created_on, expires_on) VALUES ($1, $2, $3, $4)", uid, hashToken(token), now, now.Add(PasswordResetTTL))
+	if err != nil {
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return uid, email.String, token, nil
+}
+
+// ResetPassword redeems a reset token, sets the new password and ends every
+// session of the account.
+func ResetPassword(token string, password string) (int, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var uid int
+	var resetId int
+	err = tx.QueryRow("SELECT reset_id, user_id FROM password_resets WHERE token_hash = $1 AND used_on IS NULL AND expires_on > $2 FOR UPDATE", hashToken(token), time.Now()).Scan(&resetId, &uid)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return 0, ErrInvalidResetToken
+	} else if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	passwordHash, salt, err := hashPassword(password)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE password_resets SET used_on = $1 WHERE reset_id = $2", time.Now(), resetId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE account_data SET password = $1, salt = $2, algorithm = $3 WHERE user_id = $4", passwordHash, salt, passwordAlgorithm, uid)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = KickPlayer(uid)
+	if err != nil {
+		log.Warn("couldn't end sessions after password reset", "uid", uid, "err", err)
+	}
+
+	return uid, nil
+}
+
+// randomToken returns size random bytes, hex encoded
+func randomToken(size int) (string, error) {
+
+	buf := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) []byte {
+
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// checkEmail returns the bare address, or an empty string for no address
+func checkEmail(email string) (string, error) {
+
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return addr.Address, nil
+}
@@ -0,0 +1,152 @@
+package thordb
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jaybennett89/thorium-go/model"
+)
+
+// placement. a host declares its region, eu-west, and a zone in it when it
+// registers. a new game goes to the least loaded host with room in the first
+// region of the player's order that has one. when none of those regions has
+// room, or no region was asked for, the game goes to the least loaded host
+// with room anywhere, unless the player asked for their regions only. hosts
+// that declare no region only get games that way.
+
+var ErrNoAvailableServers = errors.New("thordb: no available servers")
+var ErrNoServersInRegion = errors.New("thordb: no available servers in the requested regions")
+
+// a host has room for another game while it is under these loads
+const hostHasRoom string = "mm.cpu_usage_pct < 80.0 AND mm.network_usage_pct < 80.0 AND mm.player_occupancy_pct < 100.0"
+
+// placeGame picks the machine for a new game from regions, best first, and
+// says whether it is in one of them
+func placeGame(regions []string, strictRegion bool) (*model.Machine, bool, error) {
+
+	rows, err := db.Query(`SELECT m.machine_id, m.remote_address, m.service_listen_port, mm.most_recent_key, m.region, m.zone
+		FROM machines m
+		  JOIN machines_metadata mm USING (machine_id)
+		WHERE m.state = $1 AND m.approval = $2 AND `+hostHasRoom+`
+		ORDER BY mm.player_occupancy_pct, mm.cpu_usage_pct, m.machine_id`, MachineActive, MachineApproved)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	// the least loaded machine of each region, and of all
+	var leastLoaded *model.Machine
+	byRegion := make(map[string]*model.Machine)
+
+	for rows.Next() {
+
+		var m model.Machine
+		err = rows.Scan(&m.MachineId, &m.RemoteAddress, &m.ListenPort, &m.MachineKey, &m.Region, &m.Zone)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if leastLoaded == nil {
+			leastLoaded = &m
+		}
+		if _, ok := byRegion[m.Region]; !ok {
+			byRegion[m.Region] = &m
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, region := range regions {
+		if m, ok := byRegion[region]; ok {
+			return m, true, nil
+		}
+	}
+
+	switch {
+	case leastLoaded == nil:
+		return nil, false, ErrNoAvailableServers
+	case len(regions) > 0 && strictRegion:
+		return nil, false, ErrNoServersInRegion
+	}
+
+	return leastLoaded, false, nil
+}
+
+// ListRegions returns every region an approved machine is in, with the room
+// it has for games and players
+func ListRegions() ([]model.RegionCapacity, error) {
+
+	rows, err := db.Query(`SELECT m.region, string_agg(DISTINCT m.zone, ',' ORDER BY m.zone), count(*),
+			count(*) FILTER (WHERE m.state = $1 AND `+hostHasRoom+`)
+		FROM machines m
+		  JOIN machines_metadata mm USING (machine_id)
+		WHERE m.approval = $2
+		GROUP BY m.region
+		ORDER BY m.region`, MachineActive, MachineApproved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	regions := make([]model.RegionCapacity, 0)
+	index := make(map[string]int)
+
+	for rows.Next() {
+
+		var r model.RegionCapacity
+		var zones string
+		err = rows.Scan(&r.Region, &zones, &r.Machines, &r.AvailableMachines)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Zones = make([]string, 0)
+		for _, zone := range strings.Split(zones, ",") {
+			if zone != "" {
+				r.Zones = append(r.Zones, zone)
+			}
+		}
+
+		index[r.Region] = len(regions)
+		regions = append(regions, r)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// seats reserved for joining players aren't open
+	rows, err = db.Query(`SELECT m.region, count(*), COALESCE(sum(g.player_count), 0),
+			COALESCE(sum(GREATEST(g.maximum_players - g.player_count -
+				(SELECT count(*) FROM seat_reservations r WHERE r.game_id = g.game_id AND r.`+liveReservation+`), 0)), 0)
+		FROM games g
+		  JOIN machines m USING (machine_id)
+		WHERE g.state = $1 AND m.state <> $2 AND m.approval = $3
+		GROUP BY m.region`, model.GameRunning, MachineDraining, MachineApproved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var region string
+		var games, players, open int
+		err = rows.Scan(&region, &games, &players, &open)
+		if err != nil {
+			return nil, err
+		}
+
+		if i, ok := index[region]; ok {
+			regions[i].RunningGames = games
+			regions[i].Players = players
+			regions[i].OpenSeats = open
+		}
+	}
+
+	return regions, rows.Err()
+}
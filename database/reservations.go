@@ -0,0 +1,242 @@
+package thordb
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/auth"
+	"github.com/jaybennett89/thorium-go/model"
+	request "github.com/jaybennett89/thorium-go/requests"
+)
+
+// seat reservations. a player joining a game first reserves a seat, which
+// counts against maximum_players like a connected player does, and gets a
+// join ticket for it. the game server redeems the ticket when the player
+// connects, and the seat becomes a place in player_count. a seat that isn't
+// redeemed in time stops counting when it expires, and the elected master
+// deletes it. the game's row is locked while seats are counted, so a game is
+// never promised to more players than it holds.
+
+var ErrInvalidJoinTicket = errors.New("thordb: invalid join ticket")
+var ErrReservationExpired = errors.New("thordb: seat reservation expired")
+
+// SeatReservationTTL is how long a seat is held for a player who hasn't
+// connected
+var SeatReservationTTL = 30 * time.Second
+
+// the reservations that still hold a seat
+const liveReservation string = "redeemed_on IS NULL AND expires_on > now()"
+
+// ReserveSeat holds a seat in a running game for one of the account's
+// characters and returns where to connect, with the ticket the game server
+// redeems. a character holds one seat at a time, so a new reservation gives
+// up the character's earlier ones.
+func ReserveSeat(uid int, characterId int, gameId int) (*request.JoinGameResponse, error) {
+
+	active, err := GetActiveRestrictions(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasRestriction(active, RestrictMatchmaking) {
+		return nil, ErrMatchmakingRestricted
+	}
+
+	var owned bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM characters WHERE id = $1 AND uid = $2)", characterId, uid).Scan(&owned)
+	if err != nil {
+		return nil, err
+	}
+
+	if !owned {
+		return nil, ErrCharacterNotExist
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, reservationId, err := reserveSeat(tx, uid, characterId, gameId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// the ticket outlives the reservation by a little, so a ticket refused
+	// for its age says the seat is gone rather than that it is invalid
+	ticket, err := IssueToken(auth.JoinTicketClaims(reservationId, uid, characterId, gameId, SeatReservationTTL+time.Minute))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.JoinTicket = ticket
+	return resp, nil
+}
+
+func reserveSeat(tx *sql.Tx, uid int, characterId int, gameId int) (*request.JoinGameResponse, int, error) {
+
+	var resp request.JoinGameResponse
+	var playerCount, maxPlayers int
+	var state string
+	var machineState sql.NullString
+	var port sql.NullInt64
+
+	// locked until the reservation is in, so seats are counted one join at
+	// a time
+	err := tx.QueryRow(`SELECT g.player_count, g.maximum_players, g.state, g.port, m.state, COALESCE(m.remote_address, '')
+		FROM games g
+		  LEFT JOIN machines m USING (machine_id)
+		WHERE g.game_id = $1
+		FOR UPDATE OF g`, gameId).Scan(&playerCount, &maxPlayers, &state, &port, &machineState, &resp.RemoteAddress)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, 0, ErrGameNotExist
+	case err != nil:
+		return nil, 0, err
+	case state == model.GameEnded || state == model.GameFailed:
+		return nil, 0, ErrGameEnded
+	case state != model.GameRunning:
+		return nil, 0, ErrGameState
+	case machineState.String == MachineDraining:
+		return nil, 0, ErrMachineDraining
+	}
+
+	resp.ListenPort = int(port.Int64)
+
+	_, err = tx.Exec("DELETE FROM seat_reservations WHERE character_id = $1 AND redeemed_on IS NULL", characterId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reserved, err := reservedSeats(tx, gameId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if playerCount+reserved >= maxPlayers {
+		return nil, 0, ErrGameFull
+	}
+
+	var reservationId int
+	err = tx.QueryRow(`INSERT INTO seat_reservations (game_id, user_id, character_id, expires_on)
+		VALUES ($1, $2, $3, now() + $4::float8 * interval '1 millisecond')
+		RETURNING reservation_id, expires_on`, gameId, uid, characterId, SeatReservationTTL.Nanoseconds()/int64(time.Millisecond)).Scan(&reservationId, &resp.ExpiresAt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &resp, reservationId, nil
+}
+
+// reservedSeats counts the seats held in a game for players who haven't
+// connected yet
+func reservedSeats(x execer, gameId int) (int, error) {
+
+	var reserved int
+	err := x.QueryRow("SELECT count(*) FROM seat_reservations WHERE game_id = $1 AND "+liveReservation, gameId).Scan(&reserved)
+	return reserved, err
+}
+
+// checkJoinTicket returns the reservation a ticket holds, after checking the
+// ticket was issued to this player for this game
+func checkJoinTicket(ticket string, gameId int, uid int, characterId int) (int, error) {
+
+	c, err := VerifyToken(ticket, auth.RoleJoinTicket)
+	if err != nil {
+		return 0, ErrInvalidJoinTicket
+	}
+
+	if c.GameId != gameId || c.UserId != uid || c.CharacterId != characterId || c.ReservationId == 0 {
+		return 0, ErrInvalidJoinTicket
+	}
+
+	return c.ReservationId, nil
+}
+
+// takeSeat counts a connecting player in a game, in the seat their
+// reservation held or, without one, in a seat nobody has reserved. the
+// game's row is locked by the caller.
+func takeSeat(tx *sql.Tx, gameId int, reservationId int, playerCount int, maxPlayers int) error {
+
+	if reservationId != 0 {
+
+		res, err := tx.Exec("UPDATE seat_reservations SET redeemed_on = now() WHERE reservation_id = $1 AND game_id = $2 AND "+liveReservation, reservationId, gameId)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrReservationExpired
+		}
+
+	} else {
+
+		reserved, err := reservedSeats(tx, gameId)
+		if err != nil {
+			return err
+		}
+
+		if playerCount+reserved >= maxPlayers {
+			return ErrGameFull
+		}
+	}
+
+	// a reserved seat was already counted, this only guards against a count
+	// that has drifted
+	if playerCount >= maxPlayers {
+		return ErrGameFull
+	}
+
+	_, err := tx.Exec("UPDATE games SET player_count = player_count + 1 WHERE game_id = $1", gameId)
+	return err
+}
+
+// ExpireSeatReservations deletes reservations past their expiry, redeemed or
+// not, and returns how many were never redeemed
+func ExpireSeatReservations(lease string, token int64) (int, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkFence(tx, lease, token)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var unredeemed int
+	err = tx.QueryRow(`WITH expired AS (
+			DELETE FROM seat_reservations WHERE expires_on <= now() RETURNING redeemed_on
+		)
+		SELECT count(*) FROM expired WHERE redeemed_on IS NULL`).Scan(&unredeemed)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if unredeemed > 0 {
+		log.Info("expired unredeemed seat reservations", "count", unredeemed)
+	}
+
+	return unredeemed, nil
+}
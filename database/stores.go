@@ -0,0 +1,18 @@
+package thordb
+
+import (
+	"github.com/jaybennett89/thorium-go/leader"
+	"github.com/jaybennett89/thorium-go/ratelimit"
+)
+
+// redis backed stores shared by all master replicas
+
+func LeaseStore() leader.Store {
+
+	return leader.NewRedisStore(kvstore)
+}
+
+func RateLimitStore() ratelimit.Store {
+
+	return ratelimit.NewRedisStore(kvstore)
+}
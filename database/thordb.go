@@ -1,36 +1,38 @@
 package thordb
 
 import (
-	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"strings"
 	"time"
 
+	"github.com/jaybennett89/thorium-go/auth"
 	"github.com/jaybennett89/thorium-go/client"
 	"github.com/jaybennett89/thorium-go/globals"
+	"github.com/jaybennett89/thorium-go/logging"
 	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/validation"
 
 	"github.com/dgrijalva/jwt-go"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"gopkg.in/redis.v3"
 )
 
+var log = logging.New("thordb")
+
 const privKeyPath string = "keys/app.rsa"
-const pubKeyPath string = "keys/app.rsa.pub"
 
 // redis keys
 const sessionKey string = "sessions/user/%d"
 const hkeyUserToken string = "userToken"
-const hkeyCharacterToken string = "characterToken"
-const hkeyCharacterData string = "characterData"
 const gameSessionKey string = "games/%d"
 
 // errors
@@ -38,255 +40,227 @@ var ErrInvalidSessionKey = errors.New("thordb: invalid session key")
 var ErrInvalidMachineKey = errors.New("thordb: invalid machine key")
 var ErrGameNotExist = errors.New("thordb: game does not exist")
 var ErrGameFull = errors.New("thordb: game is full")
+var ErrInvalidCredentials = errors.New("thordb: invalid credentials")
+var ErrAlreadyInUse = errors.New("thordb: already in use")
+var ErrNotCurrentGame = errors.New("thordb: character is not in this game")
 
 var db *sql.DB
 var kvstore *redis.Client
 var signKey *rsa.PrivateKey
-var verifyKey *rsa.PublicKey
 
 func init() {
 	// check rsa
 	var signBytes []byte
-	var verifyBytes []byte
 	var err error
-	log.Print("opening app.rsa keys")
+	log.Debug("opening signing key", "path", privKeyPath)
 	signBytes, err = ioutil.ReadFile(privKeyPath)
 	if err != nil {
-		log.Print(err)
+		log.Error("couldn't read signing key", "path", privKeyPath, "err", err)
 	}
 	signKey, err = jwt.ParseRSAPrivateKeyFromPEM(signBytes)
 	if err != nil {
-		log.Print(err)
-	}
-	verifyBytes, err = ioutil.ReadFile(pubKeyPath)
-	if err != nil {
-		log.Print(err)
-	}
-	verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
-	if err != nil {
-		log.Print(err)
+		log.Error("couldn't parse signing key", "err", err)
 	}
 
-	log.Print("testing postgres connection")
+	log.Debug("testing postgres connection")
 	// check postgres
-	db, err = sql.Open("postgres", "port=5432 host=db user=postgres password=secret dbname=postgres sslmode=disable")
+	db, err = openTimed("port=5432 host=db user=postgres password=secret dbname=postgres sslmode=disable")
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("couldn't open postgres", "err", err)
 	}
 
 	err = db.Ping()
 	if err != nil {
-		log.Print(err)
+		log.Error("postgres ping failed", "err", err)
 	}
 
-	log.Print("testing redis connection")
+	log.Debug("testing redis connection")
 	// check redis
 	kvstore = redis.NewClient(&redis.Options{
 		Addr:     "cache:6379",
+		Dialer:   redisDialer("cache:6379"),
 		Password: "",
 		DB:       0,
 	})
 
 	_, err = kvstore.Ping().Result()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("redis ping failed", "err", err)
 	}
 
-	log.Print("thordb initialization complete")
-}
-
-func CreateNewGame(mapName string, gameMode string, minimumLevel int, maxPlayers int) (int, error) {
-
-	tx, err := db.Begin()
+	err = bootstrapKeyring(signKey)
 	if err != nil {
-		return 0, err
+		log.Error("couldn't bootstrap the keyring", "err", err)
 	}
 
-	var gameId int
-	err = tx.QueryRow("INSERT INTO games (map_name, game_mode, minimum_level, maximum_players) VALUES ( $1, $2, $3, $4 ) RETURNING game_id", mapName, gameMode, minimumLevel, maxPlayers).Scan(&gameId)
+	err = RefreshKeyring()
 	if err != nil {
-		return 0, err
+		log.Error("couldn't load the keyring", "err", err)
 	}
 
-	machineList, err := GetMachineList()
+	err = validation.LoadWordLists(validation.ReservedNamesPath, validation.ProfanityPath)
 	if err != nil {
-
-		err = tx.Rollback()
-
-		if err != nil {
-
-			fmt.Println(err)
-			return 0, err
-		}
-
-		fmt.Println(err)
-		return 0, err
+		log.Error("couldn't load name word lists", "err", err)
 	}
 
-	if len(machineList) < 1 {
+	log.Info("thordb initialization complete")
+}
 
-		err = tx.Rollback()
-		if err != nil {
+// Close closes the postgres and redis pools. nothing in thordb may be called
+// afterwards, so the process closes them last, on its way out.
+func Close() error {
 
-			return 0, err
-		}
+	dbErr := db.Close()
+	kvErr := kvstore.Close()
 
-		return 0, errors.New("thordb: no available servers")
+	if dbErr != nil {
+		return dbErr
 	}
 
-	// pick a machine
-	// for now its okay to use the first one since we only have one server in dev environment
-	machine := machineList[0]
-	fmt.Println("selected ", machine.RemoteAddress, ":", machine.ListenPort)
-
-	endpoint := fmt.Sprintf("%s:%d", machine.RemoteAddress, machine.ListenPort)
-	rc, body, err := client.NewGameServer(endpoint, gameId, mapName, gameMode, minimumLevel, maxPlayers)
-	if err != nil {
-
-		err = tx.Rollback()
-		if err != nil {
+	return kvErr
+}
 
-			return 0, err
-		}
+// CreateNewGame records a game and has a host start its game server, in the
+// first of regions, best first, with room. the game is kept, failed, when no
+// host will take it.
+func CreateNewGame(mapName string, gameMode string, minimumLevel int, maxPlayers int, regions []string, strictRegion bool) (int, error) {
 
+	var gameId int
+	err := db.QueryRow("INSERT INTO games (map_name, game_mode, minimum_level, maximum_players) VALUES ( $1, $2, $3, $4 ) RETURNING game_id", mapName, gameMode, minimumLevel, maxPlayers).Scan(&gameId)
+	if err != nil {
 		return 0, err
 	}
 
-	fmt.Println("new game server response status : ", rc, " body : ", body)
-
-	if rc != 200 {
+	machine, preferred, err := placeGame(regions, strictRegion)
+	switch {
+	case err == ErrNoAvailableServers:
+		abandonGame(gameId, "no available servers")
+		return 0, err
+	case err == ErrNoServersInRegion:
+		abandonGame(gameId, "no available servers in "+strings.Join(regions, ", "))
+		return 0, err
+	case err != nil:
+		log.Error("couldn't place game", "game", gameId, "err", err)
+		abandonGame(gameId, "couldn't list machines")
+		return 0, err
+	}
 
-		err = tx.Rollback()
-		if err != nil {
+	// the transition to provisioning says where the game went, and why
+	reason := "placed"
+	if machine.Region != "" {
+		reason += " in " + machine.Region
+	}
+	if len(regions) > 0 && !preferred {
+		reason += ", none of " + strings.Join(regions, ", ") + " had room"
+	}
 
-			return 0, err
-		}
+	log.Info("selected machine for game", "game", gameId, "machine", machine.MachineId, "region", machine.Region, "zone", machine.Zone, "preferred", preferred, "addr", machine.RemoteAddress, "port", machine.ListenPort)
 
-		return 0, errors.New("thordb: machine unavailable")
+	res, err := db.Exec("UPDATE games SET state = $1, machine_id = $2, state_reason = $3 WHERE game_id = $4 AND state IN "+movableTo(model.GameProvisioning, model.GameRequested), model.GameProvisioning, machine.MachineId, reason, gameId)
+	err = moved(db, res, err, gameId)
+	if err != nil {
+		return 0, err
 	}
 
-	_, err = tx.Exec("INSERT INTO loading_hosts (game_id, machine_id, kickoff_time) VALUES ( $1, $2, $3 )", gameId, machine.MachineId, time.Now())
+	// the game server gets its own key, scoped to this game on this machine
+	gameToken, err := IssueToken(auth.GameServerClaims(gameId, machine.MachineId, gameServerTokenTTL))
 	if err != nil {
-
-		fmt.Println(err)
-
-		err = tx.Rollback()
-		if err != nil {
-
-			return 0, err
-		}
-
+		abandonGame(gameId, "couldn't issue a game server key")
 		return 0, err
 	}
 
-	err = tx.Commit()
+	endpoint := fmt.Sprintf("%s:%d", machine.RemoteAddress, machine.ListenPort)
+	rc, body, err := client.NewGameServer(endpoint, machine.MachineKey, gameId, gameToken, mapName, gameMode, minimumLevel, maxPlayers)
 	if err != nil {
-
+		abandonGame(gameId, "couldn't reach the host")
 		return 0, err
 	}
 
-	return gameId, nil
-}
-
-func RegisterActiveGame(gameId int, machineKey string, listenPort int) error {
+	log.Debug("new game server response", "game", gameId, "status", rc, "body", body)
 
-	machineId, err := readMachineKey(machineKey)
-	if err != nil {
+	if rc != 200 {
+		abandonGame(gameId, fmt.Sprintf("the host refused with %d", rc))
+		return 0, errors.New("thordb: machine unavailable")
+	}
 
-		return err
+	// the game server may have registered while the host was answering, in
+	// which case the game is already running
+	res, err = db.Exec("UPDATE games SET state = $1, state_reason = NULL WHERE game_id = $2 AND state IN "+movableTo(model.GameLoading, model.GameProvisioning), model.GameLoading, gameId)
+	err = moved(db, res, err, gameId)
+	if err != nil && err != ErrGameState {
+		log.Error("couldn't mark game loading", "game", gameId, "err", err)
+		return 0, err
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
+	return gameId, nil
+}
 
-		return err
-	}
+// abandonGame fails a game that couldn't be placed
+func abandonGame(gameId int, reason string) {
 
-	_, err = tx.Exec("DELETE FROM loading_hosts WHERE game_id = $1 AND machine_id = $2", gameId, machineId)
+	err := failGame(db, gameId, reason)
 	if err != nil {
-
-		return err
+		log.Error("couldn't mark game failed", "game", gameId, "reason", reason, "err", err)
 	}
+}
 
-	_, err = tx.Exec("INSERT INTO hosts (game_id, machine_id, port) VALUES ( $1, $2, $3 )", gameId, machineId, listenPort)
-	if err != nil {
+// RegisterActiveGame records that the game server of a game is listening.
+func RegisterActiveGame(gameId int, machineId int, listenPort int) error {
 
-		err = tx.Rollback()
-		if err != nil {
+	res, err := db.Exec("UPDATE games SET state = $1, port = $2, state_reason = NULL WHERE game_id = $3 AND machine_id = $4 AND state IN "+movableTo(model.GameRunning, model.GameProvisioning, model.GameLoading), model.GameRunning, listenPort, gameId, machineId)
+	return moved(db, res, err, gameId)
+}
 
-			return err
-		}
+// RegisterAccount creates an account. email is an optional recovery contact
+// and may be empty.
+func RegisterAccount(username string, password string, email string) (string, []int, error) {
 
-		return err
+	username, usernameKey, err := validation.Username(username)
+	if err != nil {
+		return "", nil, err
 	}
 
-	err = tx.Commit()
+	email, err = checkEmail(email)
 	if err != nil {
-
-		return err
+		return "", nil, err
 	}
 
-	return nil
-}
-
-func RegisterAccount(username string, password string) (string, []int, error) {
-
 	var foundname string
 
 	// check to see if username is taken already
-	err := db.QueryRow("SELECT username FROM account_data WHERE username LIKE $1;", username).Scan(&foundname)
+	err = db.QueryRow("SELECT username FROM account_data WHERE username_key = $1;", usernameKey).Scan(&foundname)
 	switch {
 	case err == sql.ErrNoRows:
-		log.Print("Username available")
+		log.Debug("username available", "username", username)
 	case err != nil:
-		log.Print(err)
+		log.Error("couldn't check username", "err", err)
 		return "", nil, err
 	default:
-		log.Print("Username is already in use")
-		return "", nil, errors.New("thordb: already in use")
+		log.Debug("username already in use", "username", username)
+		return "", nil, ErrAlreadyInUse
 	}
 
-	saltSize := 16
-	alg := "sha1"
-
-	//allocates 16+sha1.Size bytes to the bufer
-	//creates slice with length saltSize and capacity of saltSize+sha1.Size
-	buf := make([]byte, saltSize, saltSize+sha1.Size)
-
-	//fill buf with random data (linux is /dev/urandom)
-	_, e := io.ReadFull(rand.Reader, buf)
-
-	if e != nil {
-		fmt.Println("filling buf with random data failed")
-		return "", nil, e
+	passwordHash, salt, err := hashPassword(password)
+	if err != nil {
+		log.Error("couldn't hash password", "err", err)
+		return "", nil, err
 	}
 
-	// create password hash
-	dirtySalt := sha1.New()
-	dirtySalt.Write(buf)
-	dirtySalt.Write([]byte(password))
-	salt := dirtySalt.Sum(buf)
-	combination := string(salt) + string(password)
-	passwordHash := sha1.New()
-	io.WriteString(passwordHash, combination)
-
 	var uid int
 	timenow := time.Now()
 
 	// register new account in the database
-	err = db.QueryRow("INSERT INTO account_data (username, password, salt, algorithm, createdon, lastlogin) VALUES ($1, $2, $3, $4, $5, $6) RETURNING user_id", username, passwordHash.Sum(nil), salt, alg, timenow, timenow).Scan(&uid)
-	if err != nil {
-		fmt.Println("error inserting account data: ", err)
+	err = db.QueryRow("INSERT INTO account_data (username, username_key, email, password, salt, algorithm, createdon, lastlogin) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING user_id", username, usernameKey, nullString(email), passwordHash, salt, passwordAlgorithm, timenow, timenow).Scan(&uid)
+	if isUniqueViolation(err) {
+		// lost a race with another registration of the same name
+		return "", nil, ErrAlreadyInUse
+	} else if err != nil {
+		log.Error("couldn't insert account data", "err", err)
 		return "", nil, err
 	}
 
-	// create the jwt token data
-	t := jwt.New(jwt.SigningMethodRS256)
-	t.Claims["uid"] = uid
-	t.Claims["iat"] = time.Now()
-
 	// create signed token string
-	token, err := t.SignedString(signKey)
+	token, err := IssueToken(auth.PlayerClaims(uid, playerTokenTTL))
 	if err != nil {
 		return "", nil, err
 	}
@@ -296,7 +270,7 @@ func RegisterAccount(username string, password string) (string, []int, error) {
 	var charIds []int = []int{}
 	rows, err := db.Query("SELECT id FROM characters where uid=$1", uid)
 	if err != nil {
-		log.Print("error querying character ids from uid: ", err)
+		log.Error("couldn't query character ids", "uid", uid, "err", err)
 		return "", nil, err
 	}
 	defer rows.Close()
@@ -304,7 +278,7 @@ func RegisterAccount(username string, password string) (string, []int, error) {
 	for rows.Next() {
 		err = rows.Scan(&charId)
 		if err != nil {
-			log.Print("error scanning row to get character ID: ", err)
+			log.Error("couldn't scan character id", "uid", uid, "err", err)
 		}
 		charIds = append(charIds, charId)
 	}
@@ -324,13 +298,15 @@ func LoginAccount(username string, password string) (string, []int, error) {
 	var uid int
 
 	// get the account info from the database
-	err := db.QueryRow("SELECT password, salt, user_id FROM account_data WHERE username LIKE $1", username).Scan(&hashedPassword, &salt, &uid)
+	err := db.QueryRow("SELECT password, salt, user_id FROM account_data WHERE username_key = $1", validation.Key(username)).Scan(&hashedPassword, &salt, &uid)
 	switch {
 	case err == sql.ErrNoRows:
-		log.Printf("thordb: user does not exist %s", username)
-		return "", nil, errors.New("thordb: does not exist")
+		// hash anyway so an unknown user takes as long as a wrong password
+		log.Info("login for unknown user", "username", username)
+		salt = make([]byte, 16+sha1.Size)
+		hashedPassword = nil
 	case err != nil:
-		log.Print(err)
+		log.Error("couldn't read account", "err", err)
 		return "", nil, err
 	}
 
@@ -339,18 +315,18 @@ func LoginAccount(username string, password string) (string, []int, error) {
 	io.WriteString(passwordHash, combination)
 
 	// compare password hashes
-	match := bytes.Equal(passwordHash.Sum(nil), hashedPassword)
+	match := subtle.ConstantTimeCompare(passwordHash.Sum(nil), hashedPassword) == 1
 	if !match {
-		return "", nil, errors.New("thordb: invalid password")
+		return "", nil, ErrInvalidCredentials
 	}
 
-	// create the jwt token data
-	t := jwt.New(jwt.SigningMethodRS256)
-	t.Claims["uid"] = uid
-	t.Claims["iat"] = time.Now()
+	_, err = checkStanding(uid)
+	if err != nil {
+		return "", nil, err
+	}
 
 	// create signed token string
-	token, err := t.SignedString(signKey)
+	token, err := IssueToken(auth.PlayerClaims(uid, playerTokenTTL))
 	if err != nil {
 		return "", nil, err
 	}
@@ -376,7 +352,7 @@ func LoginAccount(username string, password string) (string, []int, error) {
 	var charIds []int = []int{}
 	rows, err := db.Query("SELECT id FROM characters where uid=$1", uid)
 	if err != nil {
-		log.Print("error querying character ids from uid: ", err)
+		log.Error("couldn't query character ids", "uid", uid, "err", err)
 		return "", nil, err
 	}
 	defer rows.Close()
@@ -384,7 +360,7 @@ func LoginAccount(username string, password string) (string, []int, error) {
 	for rows.Next() {
 		err = rows.Scan(&charId)
 		if err != nil {
-			log.Print("error scanning row to get character ID: ", err)
+			log.Error("couldn't scan character id", "uid", uid, "err", err)
 		}
 		charIds = append(charIds, charId)
 	}
@@ -397,109 +373,21 @@ func LoginAccount(username string, password string) (string, []int, error) {
 	return token, charIds, nil
 }
 
-func Disconnect(userToken string) error {
-
-	uid, err := validateToken(userToken)
-	if err != nil {
-		return err
-	}
-
-	var charToken string
-	var charData string
-	var foundCharacter bool = true
-
-	charToken, err = kvstore.HGet(fmt.Sprintf(sessionKey, uid), hkeyCharacterToken).Result()
-	if err != nil {
-		// no character to save
-		switch err.Error() {
-		case "redis: nil":
-			// no character to save
-			foundCharacter = false
-		default:
-			return err
-		}
-		log.Print(err)
-	}
-
-	// decrypt the token and get character id
-	if foundCharacter {
-		var token *jwt.Token
-		token, err = jwt.Parse(charToken, func(token *jwt.Token) (interface{}, error) {
-			return verifyKey, nil
-		})
-		if err != nil {
-			log.Print("thordb couldn't parse stored character token")
-			log.Print(err)
-			return err
-		}
-		idFloat, ok := token.Claims["id"].(float64)
-		if !ok {
-			log.Print("thordb couldn't parse stored character token")
-			log.Print(err)
-
-		}
-		id := int(idFloat)
-		charData, err = kvstore.HGet(fmt.Sprintf(sessionKey, uid), hkeyCharacterData).Result()
-		if err != nil {
-			// no character to save
-			switch err.Error() {
-			case "redis: nil":
-				// no character to save
-			default:
-				return err
-			}
-		}
-
-		var res sql.Result
-		res, err = db.Exec("UPDATE characters SET game_data = $1 WHERE id = $2 AND uid = $3", charData, id, uid)
-		if err != nil {
-			return err
-		}
-
-		var rows int64
-		rows, err = res.RowsAffected()
-		if err != nil {
-			return err
-		}
-
-		if rows == 0 {
-			// character does not exist
-			return errors.New("thordb: does not exist")
-		}
-
-		res, err = db.Exec("UPDATE account_data SET lastlogin = $1 WHERE user_id = $2", time.Now(), uid)
-		if err != nil {
-			return err
-		}
-
-		rows, err = res.RowsAffected()
-		if err != nil {
-			return err
-		}
-
-		if rows == 0 {
-			// character does not exist
-			return errors.New("thordb: does not exist")
-		}
-
-		if err != nil {
-			return err
-		}
-
-	}
+// Disconnect ends the session. game servers save characters as players
+// leave, so there is nothing left to save here.
+func Disconnect(uid int) error {
 
-	var count int64
-	count, err = kvstore.Del(fmt.Sprintf(sessionKey, uid)).Result()
+	count, err := kvstore.Del(fmt.Sprintf(sessionKey, uid)).Result()
 	if err != nil {
 		return err
 	}
 
 	if count == 0 {
-		log.Print("couldnt find session")
+		log.Warn("disconnect without a session", "uid", uid)
 		return errors.New("thordb: invalid session")
 	}
 
-	log.Print("client disconnected %d", uid)
+	log.Info("client disconnected", "uid", uid)
 	return nil
 }
 
@@ -508,79 +396,23 @@ func storeAccount(session *AccountSession) {
 	// use this to store an account update in postgres
 }
 
-func validateToken(token_str string) (int, error) {
-
-	token, err := jwt.Parse(token_str, func(t *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
-
-	if err != nil {
-		return 0, err
-	}
-
-	var uidFloat64 float64
-	uidFloat64, ok := token.Claims["uid"].(float64)
-	uid := int(uidFloat64)
-	if !ok {
-		return 0, errors.New("thordb: invalid session")
-	}
-
-	// ToDo: update account + character in postgres before deleting from redis
-
-	var savedToken string
-	savedToken, err = kvstore.HGet(fmt.Sprintf(sessionKey, uid), hkeyUserToken).Result()
-
-	if err != nil {
-		return 0, err
-	}
-
-	if token_str == savedToken {
-		return uid, nil
-	} else {
-		return 0, errors.New("thordb: invalid session")
-	}
-}
-
-func readMachineKey(machineKey string) (machineId int, err error) {
-
-	token, err := jwt.Parse(machineKey, func(t *jwt.Token) (interface{}, error) {
-		return verifyKey, nil
-	})
+func CreateCharacter(uid int, name string, classId int) (int, error) {
 
-	if err != nil {
-
-		return 0, err
-	}
-
-	var rawId float64
-	rawId, ok := token.Claims["machineId"].(float64)
-	if !ok {
-
-		return 0, errors.New("thordb: invalid machine key")
-	}
-
-	machineId = int(rawId)
-
-	return machineId, nil
-}
-
-func CreateCharacter(sessionKey string, name string, classId int) (int, error) {
-
-	uid, err := validateToken(sessionKey)
+	name, nameKey, err := validation.CharacterName(name)
 	if err != nil {
 		return 0, err
 	}
 
 	var foundname string
-	err = db.QueryRow("SELECT name FROM characters WHERE name LIKE $1", name).Scan(&foundname)
+	err = db.QueryRow("SELECT name FROM characters WHERE name_key = $1", nameKey).Scan(&foundname)
 	switch {
 	case err == sql.ErrNoRows:
-		log.Printf("thordb: name is available %s", name)
+		log.Debug("character name available", "name", name)
 	case err != nil:
-		log.Print(err)
+		log.Error("couldn't check character name", "err", err)
 		return 0, err
 	default:
-		return 0, errors.New("thordb: already in use")
+		return 0, ErrAlreadyInUse
 	}
 
 	character := model.NewCharacter()
@@ -594,8 +426,10 @@ func CreateCharacter(sessionKey string, name string, classId int) (int, error) {
 	}
 
 	var id int
-	err = db.QueryRow("INSERT INTO characters (uid, name, game_data) VALUES ($1, $2, $3) RETURNING id", uid, character.Name, string(jsonBytes)).Scan(&id)
-	if err != nil {
+	err = db.QueryRow("INSERT INTO characters (uid, name, name_key, game_data) VALUES ($1, $2, $3, $4) RETURNING id", uid, character.Name, nameKey, string(jsonBytes)).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrAlreadyInUse
+	} else if err != nil {
 		return 0, err
 	}
 
@@ -611,54 +445,43 @@ func GetServerInfo(gameId int) (*model.HostServer, bool, error) {
 	// return err otherwise
 
 	var host model.HostServer
+	var state string
+	var address sql.NullString
+	var port sql.NullInt64
 
-	err := db.QueryRow("SELECT remote_address, port FROM games JOIN hosts USING (game_id) JOIN machines USING (machine_id) WHERE game_id = $1", gameId).Scan(&host.RemoteAddress, &host.ListenPort)
+	err := db.QueryRow("SELECT g.state, m.remote_address, g.port FROM games g LEFT JOIN machines m USING (machine_id) WHERE g.game_id = $1", gameId).Scan(&state, &address, &port)
 	switch {
-
-	// if game is not found in hosts then check loading_hosts too
 	case err == sql.ErrNoRows:
+		return nil, false, GameNotExistError
+	case err != nil:
+		return nil, false, err
+	}
 
-		var kickoff time.Time
-		err := db.QueryRow("SELECT kickoff_time FROM loading_hosts WHERE game_id = $1", gameId).Scan(&kickoff)
-		switch {
-
-		case err == sql.ErrNoRows:
-
-			return nil, false, GameNotExistError
-
-		case err != nil:
-
-			return nil, false, err
-
-		}
-
-		// todo: compare kickoff time to current time and reprovision if elapsed time
-		// is greater than a wait threshold constant
+	switch state {
+	case model.GameRequested, model.GameProvisioning, model.GameLoading:
 
+		// todo: reprovision games that have been loading for too long
 		return nil, false, nil
 
-	case err != nil:
+	case model.GameRunning:
 
-		return nil, false, err
+		host.GameId = gameId
+		host.RemoteAddress = address.String
+		host.ListenPort = int(port.Int64)
+		return &host, true, nil
 	}
 
-	host.GameId = gameId
-	return &host, true, nil
+	return nil, false, ErrGameEnded
 }
 
-func SelectCharacter(sessionKey string, characterId int) (*model.Character, error) {
-
-	uid, err := validateToken(sessionKey)
-	if err != nil {
-		return nil, err
-	}
+func SelectCharacter(uid int, characterId int) (*model.Character, error) {
 
 	var character model.Character
 	character.CharacterId = characterId
 
 	var gameData string
 
-	err = db.QueryRow("SELECT name, last_game_id, game_data FROM characters WHERE id = $1 AND uid = $2", characterId, uid).Scan(&character.Name, &character.LastGameId, &gameData)
+	err := db.QueryRow("SELECT name, last_game_id, game_data FROM characters WHERE id = $1 AND uid = $2", characterId, uid).Scan(&character.Name, &character.LastGameId, &gameData)
 	if err != nil {
 		return nil, err
 	}
@@ -674,39 +497,38 @@ func SelectCharacter(sessionKey string, characterId int) (*model.Character, erro
 	return &character, nil
 }
 
-func PlayerConnect(gameId int, machineKey string, sessionKey string, characterId int) (*model.Character, error) {
+// PlayerConnect admits the holder of sessionKey to a game on machineId, in
+// the seat the join ticket holds if there is one. the game server is
+// authenticated by the caller, the player here.
+func PlayerConnect(gameId int, machineId int, sessionKey string, characterId int, joinTicket string) (*model.Character, []string, error) {
 
-	machineId, valid, err := validateMachineKey(machineKey)
-	if err != nil {
-
-		return nil, err
+	session, err := VerifyToken(sessionKey, auth.RolePlayer)
+	switch {
+	case err == ErrAccountBanned || err == ErrAccountSuspended:
+		return nil, nil, err
+	case TokenRefused(err):
+		return nil, nil, ErrInvalidSessionKey
+	case err != nil:
+		return nil, nil, err
 	}
 
-	if !valid {
+	userId := session.UserId
 
-		return nil, ErrInvalidMachineKey
+	reservationId := 0
+	if joinTicket != "" {
+		reservationId, err = checkJoinTicket(joinTicket, gameId, userId, characterId)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	userId, err := validateToken(sessionKey)
+	active, err := GetActiveRestrictions(userId)
 	if err != nil {
-
-		return nil, ErrInvalidSessionKey
-	}
-
-	var playerCount int
-	var maxPlayers int
-
-	err = db.QueryRow("SELECT player_count, maximum_players FROM games JOIN hosts USING (game_id) JOIN machines USING (machine_id) WHERE game_id = $1 AND machine_id = $2", gameId, machineId).Scan(&playerCount, &maxPlayers)
-	switch {
-	case err == sql.ErrNoRows:
-		return nil, ErrGameNotExist
-	case err != nil:
-		log.Print(err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	if playerCount >= maxPlayers {
-		return nil, ErrGameFull
+	if hasRestriction(active, RestrictMatchmaking) {
+		return nil, nil, ErrMatchmakingRestricted
 	}
 
 	var character model.Character
@@ -716,128 +538,120 @@ func PlayerConnect(gameId int, machineKey string, sessionKey string, characterId
 
 	err = db.QueryRow("SELECT name, last_game_id, game_data FROM characters WHERE id = $1 AND uid = $2", characterId, userId).Scan(&character.Name, &character.LastGameId, &gameData)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var state model.CharacterState
 	err = json.Unmarshal([]byte(gameData), &state)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	character.CharacterState = state
 
-	// increment playercount
-	_, err = db.Exec("UPDATE games SET player_count = $1 WHERE game_id = $2", playerCount+1, gameId)
+	tx, err := db.Begin()
 	if err != nil {
-
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &character, nil
-}
-
-func PlayerDisconnect(machineKey string, gameId int, character *model.Character) error {
-
-	_, valid, err := validateMachineKey(machineKey)
+	err = admitPlayer(tx, gameId, machineId, characterId, reservationId)
 	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
 
-		return err
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if !valid {
+	return &character, restrictionKinds(active), nil
+}
 
-		return ErrInvalidMachineKey
-	}
+// admitPlayer checks the game can take the player, counts them in it and
+// records that the character played it
+func admitPlayer(tx *sql.Tx, gameId int, machineId int, characterId int, reservationId int) error {
 
-	// todo: use gameId and machineId to validate that player exists in game
-	// this will require a new "players" table that links to hosts table
-	// and reworking the player_count into a count(*) of players table
+	var playerCount int
+	var maxPlayers int
+	var machineState string
 
-	json, err := json.Marshal(&character.CharacterState)
-	if err != nil {
+	var gameState string
 
+	err := tx.QueryRow("SELECT g.player_count, g.maximum_players, g.state, m.state FROM games g JOIN machines m USING (machine_id) WHERE g.game_id = $1 AND g.machine_id = $2 FOR UPDATE OF g", gameId, machineId).Scan(&playerCount, &maxPlayers, &gameState, &machineState)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrGameNotExist
+	case err != nil:
+		log.Error("couldn't read game", "game", gameId, "machine", machineId, "err", err)
 		return err
 	}
 
-	_, err = db.Exec("UPDATE characters SET last_game_id = $1, game_data = $2 WHERE id = $3", character.LastGameId, string(json), character.CharacterId)
-	if err != nil {
+	if gameState != model.GameRunning {
+		return ErrGameNotExist
+	}
 
-		return err
+	if machineState == MachineDraining {
+		return ErrMachineDraining
 	}
 
-	// increment playercount
-	_, err = db.Exec("UPDATE games SET player_count = player_count - 1 WHERE game_id = $1", gameId)
+	err = takeSeat(tx, gameId, reservationId, playerCount, maxPlayers)
 	if err != nil {
-
 		return err
 	}
 
-	return nil
-}
-
-func ShutdownServer(machineKey string, gameId int) error {
-
-	_, valid, err := validateMachineKey(machineKey)
+	// a reconnect is already recorded
+	_, err = tx.Exec("INSERT INTO game_players (game_id, character_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", gameId, characterId)
 	if err != nil {
-
 		return err
 	}
 
-	if !valid {
-		return ErrInvalidMachineKey
-	}
+	// only this game's server may save the character from now on
+	_, err = tx.Exec("UPDATE characters SET last_game_id = $1 WHERE id = $2", gameId, characterId)
+	return err
+}
+
+// PlayerDisconnect saves the character a player leaves gameId with.
+// ErrNotCurrentGame means the character was last admitted to another game.
+func PlayerDisconnect(gameId int, character *model.Character) error {
 
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM hosts where game_id= $1", gameId)
+	err = saveCharacter(tx, gameId, character)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM games where game_id= $1", gameId)
+	// decrement playercount
+	_, err = tx.Exec("UPDATE games SET player_count = player_count - 1 WHERE game_id = $1", gameId)
 	if err != nil {
-		if err = tx.Rollback(); err != nil {
-			return err
-		}
+		tx.Rollback()
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return err
-	}
-
-	return nil
+	return tx.Commit()
 }
 
-func GetCharacter(machineKey string, characterId int) (*model.Character, error) {
+// ShutdownServer records that the game server of a game has stopped. the
+// game is kept, ended, for its history.
+func ShutdownServer(gameId int) error {
 
-	machineId, err := readMachineKey(machineKey)
-	if err != nil {
-
-		return nil, err
-	}
-
-	var realMachineKey string
-	err = db.QueryRow("SELECT machine_key FROM machines WHERE machine_id = $1", machineId).Scan(&realMachineKey)
-	if err != nil {
-		return nil, err
-	}
+	res, err := db.Exec("UPDATE games SET state = $1 WHERE game_id = $2 AND state IN "+movableTo(model.GameEnded, model.GameLoading, model.GameRunning, model.GameEnding), model.GameEnded, gameId)
+	return moved(db, res, err, gameId)
+}
 
-	if machineKey != realMachineKey {
-
-		return nil, ErrInvalidMachineKey
-	}
+func GetCharacter(characterId int) (*model.Character, error) {
 
 	var character model.Character
 	character.CharacterId = characterId
 
 	var gameData string
 
-	err = db.QueryRow("SELECT name, last_game_id, game_data FROM characters WHERE id = $1", characterId).Scan(&character.Name, &character.LastGameId, &gameData)
+	err := db.QueryRow("SELECT name, last_game_id, game_data FROM characters WHERE id = $1", characterId).Scan(&character.Name, &character.LastGameId, &gameData)
 	if err != nil {
 		return nil, err
 	}
@@ -853,18 +667,27 @@ func GetCharacter(machineKey string, characterId int) (*model.Character, error)
 	return &character, nil
 }
 
-func UpdateCharacter(machineKey string, character *model.Character) error {
+// UpdateCharacter saves a snapshot of a character playing in gameId.
+// ErrNotCurrentGame means the character was last admitted to another game.
+func UpdateCharacter(gameId int, character *model.Character) error {
 
-	_, valid, err := validateMachineKey(machineKey)
+	tx, err := db.Begin()
 	if err != nil {
+		return err
+	}
 
+	err = saveCharacter(tx, gameId, character)
+	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	if !valid {
+	return tx.Commit()
+}
 
-		return ErrInvalidMachineKey
-	}
+// saveCharacter writes a character's state if gameId is the game that last
+// admitted it. the snapshot can't move the character to another game.
+func saveCharacter(tx *sql.Tx, gameId int, character *model.Character) error {
 
 	json, err := json.Marshal(&character.CharacterState)
 	if err != nil {
@@ -872,18 +695,27 @@ func UpdateCharacter(machineKey string, character *model.Character) error {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE characters SET last_game_id = $1, game_data = $2 WHERE id = $3", character.LastGameId, string(json), character.CharacterId)
+	res, err := tx.Exec("UPDATE characters SET game_data = $1 WHERE id = $2 AND last_game_id = $3", string(json), character.CharacterId, gameId)
 	if err != nil {
 
 		return err
 	}
 
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotCurrentGame
+	}
+
 	return nil
 }
 
 func GetGamesList() ([]model.Game, error) {
 
-	rows, err := db.Query("SELECT * FROM games")
+	rows, err := db.Query("SELECT game_id, map_name, game_mode, minimum_level, player_count, maximum_players FROM games WHERE state IN " + sqlList(listedGameStates))
 	if err != nil {
 		return nil, err
 	}
@@ -894,7 +726,7 @@ func GetGamesList() ([]model.Game, error) {
 		var game model.Game
 		err = rows.Scan(&game.GameId, &game.Map, &game.Mode, &game.MinimumLevel, &game.PlayerCount, &game.MaximumPlayers)
 		if err != nil {
-			log.Print("game read error:", err)
+			log.Error("game read error", "err", err)
 		} else {
 			list = append(list, game)
 		}
@@ -905,7 +737,7 @@ func GetGamesList() ([]model.Game, error) {
 
 func GetMachineList() ([]model.Machine, error) {
 
-	rows, err := db.Query("SELECT machine_id, remote_address, service_listen_port, most_recent_key FROM machines JOIN machines_metadata USING (machine_id)")
+	rows, err := db.Query("SELECT machine_id, remote_address, service_listen_port, most_recent_key FROM machines JOIN machines_metadata USING (machine_id) WHERE state = $1 AND approval = $2", MachineActive, MachineApproved)
 	if err != nil {
 		return nil, err
 	}
@@ -916,7 +748,7 @@ func GetMachineList() ([]model.Machine, error) {
 		var m model.Machine
 		err = rows.Scan(&m.MachineId, &m.RemoteAddress, &m.ListenPort, &m.MachineKey)
 		if err != nil {
-			log.Print("machine read error:", err)
+			log.Error("machine read error", "err", err)
 		} else {
 			list = append(list, m)
 		}
@@ -953,25 +785,45 @@ func StoreCharacterSnapshot(charSession *CharacterSession) (bool, error) {
 	return true, nil
 }
 
-func validateMachineKey(machineKey string) (machineId int, valid bool, err error) {
+const passwordAlgorithm string = "sha1"
+const saltSize int = 16
 
-	machineId, err = readMachineKey(machineKey)
-	if err != nil {
+// hashPassword salts and hashes a new password
+func hashPassword(password string) ([]byte, []byte, error) {
 
-		return
-	}
+	//allocates 16+sha1.Size bytes to the bufer
+	//creates slice with length saltSize and capacity of saltSize+sha1.Size
+	buf := make([]byte, saltSize, saltSize+sha1.Size)
 
-	var realMachineKey string
-	err = db.QueryRow("SELECT most_recent_key FROM machines JOIN machines_metadata USING (machine_id) WHERE machine_id = $1", machineId).Scan(&realMachineKey)
+	//fill buf with random data (linux is /dev/urandom)
+	_, err := io.ReadFull(rand.Reader, buf)
 	if err != nil {
-
-		return
+		return nil, nil, err
 	}
 
-	if machineKey != realMachineKey {
+	// create password hash
+	dirtySalt := sha1.New()
+	dirtySalt.Write(buf)
+	dirtySalt.Write([]byte(password))
+	salt := dirtySalt.Sum(buf)
+	combination := string(salt) + string(password)
+	passwordHash := sha1.New()
+	io.WriteString(passwordHash, combination)
+
+	return passwordHash.Sum(nil), salt, nil
+}
 
-		return 0, false, ErrInvalidMachineKey
-	}
+// isUniqueViolation reports whether err is postgres rejecting a duplicate key
+func isUniqueViolation(err error) bool {
+
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err is postgres rejecting a reference
+// to a row that doesn't exist
+func isForeignKeyViolation(err error) bool {
 
-	return machineId, true, nil
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23503"
 }
@@ -0,0 +1,152 @@
+package thordb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/auth"
+	"github.com/jaybennett89/thorium-go/model"
+
+	"gopkg.in/redis.v3"
+)
+
+// token service. every token the master signs goes through IssueToken, and
+// every token it accepts through VerifyToken, which on top of the signature
+// checks the token is still live: the session or machine key is the current
+// one and a game server's game is still on its machine.
+
+const playerTokenTTL time.Duration = 24 * time.Hour
+const machineTokenTTL time.Duration = 30 * 24 * time.Hour
+const gameServerTokenTTL time.Duration = 7 * 24 * time.Hour
+
+var ErrInvalidGameToken = errors.New("thordb: invalid game server key")
+
+// IssueToken signs claims with the active key of the ring.
+func IssueToken(c *auth.Claims) (string, error) {
+
+	key, err := ring.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	return auth.Sign(c, key)
+}
+
+// VerifyToken checks tokenStr is a live token of role and returns its claims.
+// an error TokenRefused doesn't recognize means the token couldn't be checked,
+// not that it is bad.
+func VerifyToken(tokenStr string, role string) (*auth.Claims, error) {
+
+	return verifyToken(tokenStr, role, activeGameStates)
+}
+
+// VerifyShutdownToken is VerifyToken for a game server reporting it stopped.
+// the token of a game that has already ended is taken too, so a retried
+// shutdown finds the game ended instead of having its token refused.
+func VerifyShutdownToken(tokenStr string, role string) (*auth.Claims, error) {
+
+	return verifyToken(tokenStr, role, append([]string{model.GameEnded}, activeGameStates...))
+}
+
+func verifyToken(tokenStr string, role string, gameStates []string) (*auth.Claims, error) {
+
+	c, err := auth.Parse(tokenStr, ring)
+	if err != nil {
+		return nil, err
+	}
+
+	// a retired operator key is only good for admin tokens
+	if c.Role != auth.RoleAdmin && ring.operatorOnly(c.KeyId) {
+		return nil, auth.ErrInvalidToken
+	}
+
+	err = c.Require(role, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch role {
+	case auth.RolePlayer:
+		err = checkSession(c.UserId, tokenStr)
+	case auth.RoleMachine:
+		err = checkMachineSession(c.MachineId, tokenStr)
+	case auth.RoleGameServer:
+		err = checkGameServer(c.GameId, c.MachineId, gameStates)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// checkSession makes sure token is the account's current session and the
+// account is in good standing
+func checkSession(uid int, token string) error {
+
+	savedToken, err := kvstore.HGet(fmt.Sprintf(sessionKey, uid), hkeyUserToken).Result()
+	if err == redis.Nil {
+		return ErrInvalidSessionKey
+	}
+	if err != nil {
+		return err
+	}
+
+	if token != savedToken {
+		return ErrInvalidSessionKey
+	}
+
+	// a ban ends the session, but check anyway in case the kick failed
+	_, err = checkStanding(uid)
+	return err
+}
+
+// checkMachineSession makes sure token is the machine's current key
+func checkMachineSession(machineId int, token string) error {
+
+	savedToken, err := kvstore.HGet(fmt.Sprintf(machineSessionKey, machineId), hkeyMachineToken).Result()
+	if err == redis.Nil {
+		return ErrInvalidMachineKey
+	}
+	if err != nil {
+		return err
+	}
+
+	if token != savedToken {
+		return ErrInvalidMachineKey
+	}
+
+	return nil
+}
+
+// checkGameServer makes sure the game is still on the machine the token was
+// issued for and in one of states
+func checkGameServer(gameId int, machineId int, states []string) error {
+
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM games WHERE game_id = $1 AND machine_id = $2 AND state IN "+sqlList(states)+")", gameId, machineId).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrInvalidGameToken
+	}
+
+	return nil
+}
+
+// TokenRefused reports whether err, from VerifyToken, says the token is bad
+// rather than that redis or postgres couldn't be asked about it
+func TokenRefused(err error) bool {
+
+	switch err {
+	case auth.ErrInvalidToken, auth.ErrWrongRole, auth.ErrMissingScope, auth.ErrUnknownKey,
+		ErrInvalidSessionKey, ErrInvalidMachineKey, ErrInvalidGameToken, ErrAccountBanned, ErrAccountSuspended:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readiness checks. each part of a process that must work before the process
+// is sent traffic registers a named Check with a Registry, usually Default.
+// the registry's handler runs them all, at once, for /readyz and answers 200
+// only when every check passes. /healthz only tells that the process is up
+// to answer, and runs no checks.
+
+const DefaultTimeout time.Duration = 2 * time.Second
+
+// report statuses
+const StatusOK string = "ok"
+const StatusUnavailable string = "unavailable"
+
+var Default = NewRegistry(DefaultTimeout)
+
+// Check returns a short description of what it found, or an error when the
+// process shouldn't be sent traffic. a check that outlives the registry's
+// timeout fails.
+type Check func() (string, error)
+
+type Result struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Detail  string  `json:"detail,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Latency float64 `json:"latencyMs"`
+}
+
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+
+	return &Registry{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Register panics on a duplicate name, like a duplicate route would. checks
+// are reported in the order they were registered.
+func (r *Registry) Register(name string, check Check) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.checks[name]; exists {
+		panic("health: duplicate check " + name)
+	}
+
+	r.names = append(r.names, name)
+	r.checks[name] = check
+}
+
+// Run runs every check at once and waits for them, or for the timeout
+func (r *Registry) Run() Report {
+
+	r.mu.Lock()
+	names := append([]string(nil), r.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	r.mu.Unlock()
+
+	report := Report{Status: StatusOK, Checks: make([]Result, len(names))}
+
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = run(names[i], checks[i], r.timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if !result.OK {
+			report.Status = StatusUnavailable
+		}
+	}
+
+	return report
+}
+
+func run(name string, check Check, timeout time.Duration) Result {
+
+	type outcome struct {
+		detail string
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		detail, err := check()
+		done <- outcome{detail, err}
+	}()
+
+	result := Result{Name: name}
+
+	select {
+	case o := <-done:
+		result.Detail = o.detail
+		if o.err != nil {
+			result.Error = o.err.Error()
+		}
+	case <-time.After(timeout):
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	}
+
+	result.OK = result.Error == ""
+	result.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+	return result
+}
+
+// Handler serves the report, 200 when every check passes and 503 otherwise
+func (r *Registry) Handler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		report := r.Run()
+
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, code, &report)
+	})
+}
+
+// Register registers a check with Default
+func Register(name string, check Check) {
+
+	Default.Register(name, check)
+}
+
+// Handler serves the report of Default
+func Handler() http.Handler {
+
+	return Default.Handler()
+}
+
+// LiveHandler answers 200 for as long as the process can answer at all
+func LiveHandler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		writeJSON(w, http.StatusOK, &Report{Status: StatusOK, Checks: []Result{}})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, report *Report) {
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(body)
+}
@@ -0,0 +1,131 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+
+	r := NewRegistry(time.Second)
+	r.Register("postgres", func() (string, error) { return "3 open connections", nil })
+	r.Register("redis", func() (string, error) { return "", errors.New("connection refused") })
+
+	report := r.Run()
+	if report.Status != StatusUnavailable {
+		t.Fatalf("status %q, want %q", report.Status, StatusUnavailable)
+	}
+
+	if len(report.Checks) != 2 || report.Checks[0].Name != "postgres" || report.Checks[1].Name != "redis" {
+		t.Fatalf("checks out of registration order: %+v", report.Checks)
+	}
+
+	pg := report.Checks[0]
+	if !pg.OK || pg.Detail != "3 open connections" || pg.Error != "" {
+		t.Errorf("postgres %+v", pg)
+	}
+
+	redis := report.Checks[1]
+	if redis.OK || redis.Error != "connection refused" {
+		t.Errorf("redis %+v", redis)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+
+	block := make(chan struct{})
+	defer close(block)
+
+	r := NewRegistry(10 * time.Millisecond)
+	r.Register("stuck", func() (string, error) {
+		<-block
+		return "", nil
+	})
+
+	report := r.Run()
+	if report.Status != StatusUnavailable || report.Checks[0].OK {
+		t.Fatalf("a check past the timeout passed: %+v", report)
+	}
+
+	if report.Checks[0].Latency < 10 {
+		t.Errorf("latency %vms, want at least the timeout", report.Checks[0].Latency)
+	}
+}
+
+func TestPanic(t *testing.T) {
+
+	r := NewRegistry(time.Second)
+	r.Register("broken", func() (string, error) { panic("nil map") })
+
+	report := r.Run()
+	if report.Checks[0].OK || report.Checks[0].Error != "panic: nil map" {
+		t.Fatalf("%+v", report.Checks[0])
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+
+	r := NewRegistry(time.Second)
+	r.Register("redis", func() (string, error) { return "", nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a check twice didn't panic")
+		}
+	}()
+	r.Register("redis", func() (string, error) { return "", nil })
+}
+
+func TestHandler(t *testing.T) {
+
+	failing := false
+
+	r := NewRegistry(time.Second)
+	r.Register("signing-key", func() (string, error) {
+		if failing {
+			return "", errors.New("no active signing key")
+		}
+		return "kid abc", nil
+	})
+
+	for _, tc := range []struct {
+		failing bool
+		code    int
+		status  string
+	}{
+		{false, 200, StatusOK},
+		{true, 503, StatusUnavailable},
+	} {
+		failing = tc.failing
+
+		rec := httptest.NewRecorder()
+		r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rec.Code != tc.code {
+			t.Errorf("failing=%v: code %d, want %d", tc.failing, rec.Code, tc.code)
+		}
+
+		var report Report
+		err := json.Unmarshal(rec.Body.Bytes(), &report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Status != tc.status {
+			t.Errorf("failing=%v: status %q, want %q", tc.failing, report.Status, tc.status)
+		}
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+
+	rec := httptest.NewRecorder()
+	LiveHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("code %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -1,9 +1,13 @@
 package launch
 
 import (
-	"log"
+	"errors"
 	"strconv"
+	"sync"
+
 	"github.com/jaybennett89/thorium-go/cmd/host-server/hostconf"
+	"github.com/jaybennett89/thorium-go/logging"
+	"github.com/jaybennett89/thorium-go/metrics"
 	"github.com/jaybennett89/thorium-go/model"
 )
 import "os"
@@ -14,14 +18,52 @@ type GameServerProcess struct {
 	Game            *model.Game
 	Process         *os.Process
 	ListenPort      int
+
+	// closed once the process has exited and left the list
+	exited chan struct{}
 }
 
+var log = logging.New("launch")
+
+var ErrGameServerNotFound = errors.New("launch: game server not found")
+var ErrNoFreePorts = errors.New("launch: no free game server ports")
+
+// the game servers running, read and changed by request handlers, the
+// renewal loop and metrics scrapes alike
 var list []GameServerProcess = make([]GameServerProcess, 0)
+var listMu sync.Mutex
+
+// game servers listen on ports from this range, which the host publishes
 var baseListenPort int = 10100
+var listenPortCount int = 50
+
+var launches = metrics.NewCounter("thorium_host_game_server_launches_total", "Game server launches by result.", "result")
 
 func NewGameServer(machineKey string, servicePort int, gameId int, mapName string, mode string, minLevel int, maxPlayers int) error {
-	listenPort := baseListenPort + len(list)
-	log.Printf("Starting new game server: \nmachineId %d\nservicePort %d\n listenPort %d\n map %s\n mode %s\n minLevel %d\n maxPlayers %d\n", gameId, servicePort, listenPort, mapName, mode, minLevel, maxPlayers)
+
+	err := startGameServer(machineKey, servicePort, gameId, mapName, mode, minLevel, maxPlayers)
+	if err != nil {
+		launches.Inc("failed")
+		return err
+	}
+
+	launches.Inc("started")
+	return nil
+}
+
+func startGameServer(machineKey string, servicePort int, gameId int, mapName string, mode string, minLevel int, maxPlayers int) error {
+
+	// held until the game server is listed, so two launches can't pick the
+	// same port
+	listMu.Lock()
+	defer listMu.Unlock()
+
+	listenPort, ok := freePort()
+	if !ok {
+		return ErrNoFreePorts
+	}
+
+	log.Info("starting new game server", "game", gameId, "servicePort", servicePort, "listenPort", listenPort, "map", mapName, "mode", mode, "minLevel", minLevel, "maxPlayers", maxPlayers)
 
 	cmd := exec.Command(
 		hostconf.GameserverBinaryPath(),
@@ -43,12 +85,6 @@ func NewGameServer(machineKey string, servicePort int, gameId int, mapName strin
 
 	cmd.Stdout = log
 
-	err = cmd.Start()
-	if err != nil {
-
-		return err
-	}
-
 	game := model.Game{
 
 		GameId:         gameId,
@@ -59,20 +95,114 @@ func NewGameServer(machineKey string, servicePort int, gameId int, mapName strin
 		MaximumPlayers: maxPlayers,
 	}
 
+	return start(cmd, &game, listenPort)
+}
+
+// start runs cmd as the game server for game and lists it until it exits.
+// the caller holds listMu.
+func start(cmd *exec.Cmd, game *model.Game, listenPort int) error {
+
+	err := cmd.Start()
+	if err != nil {
+
+		return err
+	}
+
 	gameServer := GameServerProcess{
 
-		ApplicationName: hostconf.GameserverBinaryPath(),
-		Game:            &game,
+		ApplicationName: cmd.Path,
+		Game:            game,
 		Process:         cmd.Process,
 		ListenPort:      listenPort,
+		exited:          make(chan struct{}),
 	}
 
 	list = append(list, gameServer)
 
+	go reap(cmd, gameServer)
+
 	return nil
 }
 
+// reap waits for a game server to exit, however it ends, and frees its port
+func reap(cmd *exec.Cmd, gs GameServerProcess) {
+
+	err := cmd.Wait()
+	log.Info("game server exited", "game", gs.Game.GameId, "listenPort", gs.ListenPort, "err", err)
+
+	listMu.Lock()
+	for i := range list {
+		if list[i].Process == gs.Process {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	listMu.Unlock()
+
+	close(gs.exited)
+}
+
+// StopGameServer kills the game server process for gameId and waits for it
+// to exit.
+func StopGameServer(gameId int) error {
+
+	listMu.Lock()
+
+	for _, gs := range list {
+
+		if gs.Game.GameId != gameId {
+			continue
+		}
+
+		err := gs.Process.Kill()
+		listMu.Unlock()
+
+		// one that exited on its own is being reaped already
+		if err != nil && err != os.ErrProcessDone {
+			return err
+		}
+
+		<-gs.exited
+		return nil
+	}
+
+	listMu.Unlock()
+	return ErrGameServerNotFound
+}
+
+// GetServerList returns a copy of the game servers running
 func GetServerList() []GameServerProcess {
 
-	return list
+	listMu.Lock()
+	defer listMu.Unlock()
+
+	return append([]GameServerProcess(nil), list...)
+}
+
+// PortUsage returns how many game server ports are taken, and how many
+// there are.
+func PortUsage() (int, int) {
+
+	listMu.Lock()
+	defer listMu.Unlock()
+
+	return len(list), listenPortCount
+}
+
+// freePort returns the lowest port in the range no game server holds. the
+// caller holds listMu.
+func freePort() (int, bool) {
+
+	taken := make(map[int]bool)
+	for _, gs := range list {
+		taken[gs.ListenPort] = true
+	}
+
+	for port := baseListenPort; port < baseListenPort+listenPortCount; port++ {
+		if !taken[port] {
+			return port, true
+		}
+	}
+
+	return 0, false
 }
@@ -0,0 +1,83 @@
+package launch
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+)
+
+// hostconf loads config/host.config when the package starts, so the tests
+// carry an empty one. they start stand-in processes and never read it.
+
+func startFake(t *testing.T, gameId int, name string, args ...string) int {
+
+	listMu.Lock()
+	defer listMu.Unlock()
+
+	port, ok := freePort()
+	if !ok {
+		t.Fatal("no free port")
+	}
+
+	err := start(exec.Command(name, args...), &model.Game{GameId: gameId}, port)
+	if err != nil {
+		t.Fatalf("couldn't start %s: %s", name, err)
+	}
+
+	return port
+}
+
+func waitForPorts(t *testing.T, want int) {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		used, _ := PortUsage()
+		if used == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d ports in use, want %d", used, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExitedGameServerFreesPort(t *testing.T) {
+
+	port := startFake(t, 1, "true")
+	waitForPorts(t, 0)
+
+	if len(GetServerList()) != 0 {
+		t.Fatal("exited game server still listed")
+	}
+
+	// the next game gets the port back
+	listMu.Lock()
+	next, ok := freePort()
+	listMu.Unlock()
+	if !ok || next != port {
+		t.Fatalf("got port %d %v, want %d", next, ok, port)
+	}
+}
+
+func TestStopGameServer(t *testing.T) {
+
+	startFake(t, 2, "sleep", "30")
+	waitForPorts(t, 1)
+
+	err := StopGameServer(2)
+	if err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+
+	used, _ := PortUsage()
+	if used != 0 {
+		t.Fatalf("%d ports in use after stop", used)
+	}
+
+	if StopGameServer(2) != ErrGameServerNotFound {
+		t.Fatal("stopped game server still found")
+	}
+}
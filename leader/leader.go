@@ -0,0 +1,280 @@
+package leader
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// this package elects a single master replica to run singleton background
+// jobs (reapers, schedulers). every replica competes for a named lease in a
+// shared Store and only the holder runs the jobs. each new lease is given a
+// fencing token that increases monotonically, so writes made by a node that
+// lost its lease without noticing can be rejected downstream.
+
+var ErrNotLeader = errors.New("leader: lease not held")
+
+type Store interface {
+	// Acquire takes the lease for name if it is free, or extends it if node
+	// already holds it. ok is false when another node holds the lease.
+	Acquire(name string, node string, ttl time.Duration) (token int64, ok bool, err error)
+
+	// Renew extends the lease only if node still holds it with token.
+	Renew(name string, node string, token int64, ttl time.Duration) (bool, error)
+
+	// Release gives the lease up early if node still holds it with token.
+	Release(name string, node string, token int64) error
+
+	// Holder returns the current lease holder, or an empty node if the lease is free.
+	Holder(name string) (node string, token int64, err error)
+}
+
+type Elector struct {
+	Name string
+	Node string
+	TTL  time.Duration
+
+	store Store
+
+	mu      sync.Mutex
+	token   int64
+	leading bool
+	jobs    []*job
+
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	started bool
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(token int64) error
+
+	// signalled when this node takes the lease, so the job runs at once
+	// instead of an interval later
+	acquired chan struct{}
+}
+
+func NewElector(store Store, name string, node string, ttl time.Duration) *Elector {
+
+	return &Elector{
+		Name:  name,
+		Node:  node,
+		TTL:   ttl,
+		store: store,
+		stop:  make(chan struct{}),
+	}
+}
+
+// NodeName identifies this process among the master replicas. THORIUM_NODE_ID
+// wins if set, otherwise the hostname and pid are used.
+func NodeName() string {
+
+	if id := os.Getenv("THORIUM_NODE_ID"); id != "" {
+		return id
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Every registers a singleton job. the job only runs on the node currently
+// holding the lease and is handed the fencing token for that lease. it runs
+// as soon as the node takes the lease, then every interval.
+// jobs must be registered before Start.
+func (e *Elector) Every(name string, interval time.Duration, run func(token int64) error) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.jobs = append(e.jobs, &job{name: name, interval: interval, run: run, acquired: make(chan struct{}, 1)})
+}
+
+// Step tries to acquire or renew the lease once and reports whether this node leads.
+func (e *Elector) Step() (bool, error) {
+
+	e.mu.Lock()
+	token := e.token
+	leading := e.leading
+	e.mu.Unlock()
+
+	if leading {
+
+		ok, err := e.store.Renew(e.Name, e.Node, token, e.TTL)
+		if err != nil {
+
+			// we can't prove we still hold the lease, so stop acting as leader
+			e.setLeading(0, false)
+			return false, err
+		}
+
+		if ok {
+			return true, nil
+		}
+
+		log.Printf("leader: %s lost lease %s (token %d)", e.Node, e.Name, token)
+		e.setLeading(0, false)
+	}
+
+	token, ok, err := e.store.Acquire(e.Name, e.Node, e.TTL)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	log.Printf("leader: %s acquired lease %s (token %d)", e.Node, e.Name, token)
+	e.setLeading(token, true)
+	return true, nil
+}
+
+// Start runs the election loop and the registered jobs in the background.
+func (e *Elector) Start() {
+
+	e.mu.Lock()
+	if e.started {
+		e.mu.Unlock()
+		return
+	}
+	e.started = true
+	jobs := e.jobs
+	e.mu.Unlock()
+
+	e.wg.Add(1)
+	go e.loop()
+
+	for _, j := range jobs {
+		e.wg.Add(1)
+		go e.runJob(j)
+	}
+}
+
+// Stop halts the election loop and jobs and releases the lease if held, so
+// another replica can take over without waiting for it to expire.
+func (e *Elector) Stop() {
+
+	e.mu.Lock()
+	if !e.started {
+		e.mu.Unlock()
+		return
+	}
+	e.started = false
+	e.mu.Unlock()
+
+	close(e.stop)
+	e.wg.Wait()
+
+	token, leading := e.Token()
+	if leading {
+
+		err := e.store.Release(e.Name, e.Node, token)
+		if err != nil {
+			log.Print("leader: release failed: ", err)
+		}
+
+		e.setLeading(0, false)
+	}
+}
+
+// IsLeader reports whether this node believes it holds the lease.
+func (e *Elector) IsLeader() bool {
+
+	_, leading := e.Token()
+	return leading
+}
+
+// Token returns the fencing token of the lease held by this node.
+func (e *Elector) Token() (int64, bool) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.token, e.leading
+}
+
+// Leader asks the store which node currently holds the lease.
+func (e *Elector) Leader() (string, int64, error) {
+
+	return e.store.Holder(e.Name)
+}
+
+func (e *Elector) setLeading(token int64, leading bool) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.token = token
+	e.leading = leading
+
+	if !leading {
+		return
+	}
+
+	for _, j := range e.jobs {
+		select {
+		case j.acquired <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Elector) loop() {
+
+	defer e.wg.Done()
+
+	// renew well inside the ttl so a slow store call doesn't cost us the lease
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+
+		_, err := e.Step()
+		if err != nil {
+			log.Print("leader: election step failed: ", err)
+		}
+
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) runJob(j *job) {
+
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		case <-j.acquired:
+		}
+
+		token, leading := e.Token()
+		if !leading {
+			continue
+		}
+
+		err := j.run(token)
+		if err != nil {
+			log.Printf("leader: job %s failed: %s", j.name, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package leader
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// two master replicas sharing the in-process store
+
+func TestOnlyOneMasterLeads(t *testing.T) {
+
+	store := NewMemoryStore()
+	a := NewElector(store, "jobs", "master-a", time.Second)
+	b := NewElector(store, "jobs", "master-b", time.Second)
+
+	leadA, err := a.Step()
+	if err != nil || !leadA {
+		t.Fatalf("master-a should lead, got %v %v", leadA, err)
+	}
+
+	leadB, err := b.Step()
+	if err != nil || leadB {
+		t.Fatalf("master-b should not lead, got %v %v", leadB, err)
+	}
+
+	// renewing keeps the same token
+	tokenBefore, _ := a.Token()
+	a.Step()
+	tokenAfter, _ := a.Token()
+	if tokenBefore != tokenAfter {
+		t.Fatalf("renew changed token %d -> %d", tokenBefore, tokenAfter)
+	}
+
+	node, token, err := b.Leader()
+	if err != nil || node != "master-a" || token != tokenBefore {
+		t.Fatalf("master-b sees leader %s/%d, want master-a/%d", node, token, tokenBefore)
+	}
+}
+
+func TestFailoverIssuesNewerFencingToken(t *testing.T) {
+
+	store := NewMemoryStore()
+	a := NewElector(store, "jobs", "master-a", 20*time.Millisecond)
+	b := NewElector(store, "jobs", "master-b", 20*time.Millisecond)
+
+	a.Step()
+	oldToken, _ := a.Token()
+
+	// master-a stalls past its ttl
+	time.Sleep(40 * time.Millisecond)
+
+	leadB, err := b.Step()
+	if err != nil || !leadB {
+		t.Fatalf("master-b should take over, got %v %v", leadB, err)
+	}
+
+	newToken, _ := b.Token()
+	if newToken <= oldToken {
+		t.Fatalf("fencing token did not increase: %d -> %d", oldToken, newToken)
+	}
+
+	// master-a wakes up and must notice it lost the lease
+	leadA, err := a.Step()
+	if err != nil || leadA || a.IsLeader() {
+		t.Fatalf("master-a should have stepped down, got %v %v", leadA, err)
+	}
+}
+
+func TestStopReleasesLease(t *testing.T) {
+
+	store := NewMemoryStore()
+	a := NewElector(store, "jobs", "master-a", time.Minute)
+	b := NewElector(store, "jobs", "master-b", time.Minute)
+
+	a.Start()
+	waitFor(t, a.IsLeader)
+	a.Stop()
+
+	leadB, err := b.Step()
+	if err != nil || !leadB {
+		t.Fatalf("master-b should lead after master-a stopped, got %v %v", leadB, err)
+	}
+}
+
+func TestJobsRunOnlyOnLeader(t *testing.T) {
+
+	store := NewMemoryStore()
+	a := NewElector(store, "jobs", "master-a", time.Minute)
+	b := NewElector(store, "jobs", "master-b", time.Minute)
+
+	var runsA, runsB int32
+	a.Every("reaper", 5*time.Millisecond, func(token int64) error {
+		atomic.AddInt32(&runsA, 1)
+		return nil
+	})
+	b.Every("reaper", 5*time.Millisecond, func(token int64) error {
+		atomic.AddInt32(&runsB, 1)
+		return nil
+	})
+
+	a.Start()
+	waitFor(t, a.IsLeader)
+	b.Start()
+
+	waitFor(t, func() bool { return atomic.LoadInt32(&runsA) >= 3 })
+
+	a.Stop()
+	b.Stop()
+
+	if n := atomic.LoadInt32(&runsB); n != 0 {
+		t.Fatalf("job ran %d times on the follower", n)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("condition not met before deadline")
+}
+
+func TestJobsRunWhenLeaseIsTaken(t *testing.T) {
+
+	store := NewMemoryStore()
+	a := NewElector(store, "jobs", "master-a", time.Minute)
+
+	var runs int32
+	a.Every("rotate-keys", time.Hour, func(token int64) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	a.Start()
+	defer a.Stop()
+
+	waitFor(t, func() bool { return atomic.LoadInt32(&runs) == 1 })
+}
@@ -0,0 +1,109 @@
+package leader
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps leases in process. it is meant for tests and single node
+// development where several electors share one store.
+type MemoryStore struct {
+	mu     sync.Mutex
+	leases map[string]*memoryLease
+	fences map[string]int64
+}
+
+type memoryLease struct {
+	node    string
+	token   int64
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+
+	return &MemoryStore{
+		leases: make(map[string]*memoryLease),
+		fences: make(map[string]int64),
+	}
+}
+
+func (s *MemoryStore) Acquire(name string, node string, ttl time.Duration) (int64, bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	lease := s.live(name, now)
+	if lease != nil {
+
+		if lease.node != node {
+			return 0, false, nil
+		}
+
+		lease.expires = now.Add(ttl)
+		return lease.token, true, nil
+	}
+
+	s.fences[name]++
+	s.leases[name] = &memoryLease{node: node, token: s.fences[name], expires: now.Add(ttl)}
+
+	return s.fences[name], true, nil
+}
+
+func (s *MemoryStore) Renew(name string, node string, token int64, ttl time.Duration) (bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	lease := s.live(name, now)
+	if lease == nil || lease.node != node || lease.token != token {
+		return false, nil
+	}
+
+	lease.expires = now.Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) Release(name string, node string, token int64) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease := s.live(name, time.Now())
+	if lease != nil && lease.node == node && lease.token == token {
+		delete(s.leases, name)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Holder(name string) (string, int64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease := s.live(name, time.Now())
+	if lease == nil {
+		return "", 0, nil
+	}
+
+	return lease.node, lease.token, nil
+}
+
+func (s *MemoryStore) live(name string, now time.Time) *memoryLease {
+
+	lease, ok := s.leases[name]
+	if !ok {
+		return nil
+	}
+
+	if !now.Before(lease.expires) {
+		delete(s.leases, name)
+		return nil
+	}
+
+	return lease
+}
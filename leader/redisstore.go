@@ -0,0 +1,127 @@
+package leader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// redis keys
+const leaseKey string = "leader/%s"
+const fenceKey string = "leader/%s/fence"
+
+// the lease value is "node|token". the fence counter lives in its own key
+// without an expiry so tokens keep increasing across lease holders.
+var acquireScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+	local sep = string.find(cur, '|', 1, true)
+	if string.sub(cur, 1, sep - 1) == ARGV[1] then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		return tonumber(string.sub(cur, sep + 1))
+	end
+	return 0
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. '|' .. token, 'PX', ARGV[2])
+return token
+`)
+
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Acquire(name string, node string, ttl time.Duration) (int64, bool, error) {
+
+	keys := []string{fmt.Sprintf(leaseKey, name), fmt.Sprintf(fenceKey, name)}
+	args := []string{node, strconv.FormatInt(millis(ttl), 10)}
+
+	res, err := acquireScript.Run(s.client, keys, args).Result()
+	if err != nil {
+		return 0, false, err
+	}
+
+	token, ok := res.(int64)
+	if !ok {
+		return 0, false, fmt.Errorf("leader: unexpected acquire reply %v", res)
+	}
+
+	return token, token > 0, nil
+}
+
+func (s *RedisStore) Renew(name string, node string, token int64, ttl time.Duration) (bool, error) {
+
+	keys := []string{fmt.Sprintf(leaseKey, name)}
+	args := []string{leaseValue(node, token), strconv.FormatInt(millis(ttl), 10)}
+
+	res, err := renewScript.Run(s.client, keys, args).Result()
+	if err != nil {
+		return false, err
+	}
+
+	n, _ := res.(int64)
+	return n == 1, nil
+}
+
+func (s *RedisStore) Release(name string, node string, token int64) error {
+
+	keys := []string{fmt.Sprintf(leaseKey, name)}
+	args := []string{leaseValue(node, token)}
+
+	return releaseScript.Run(s.client, keys, args).Err()
+}
+
+func (s *RedisStore) Holder(name string) (string, int64, error) {
+
+	val, err := s.client.Get(fmt.Sprintf(leaseKey, name)).Result()
+	if err == redis.Nil {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	sep := strings.LastIndex(val, "|")
+	if sep < 0 {
+		return "", 0, fmt.Errorf("leader: malformed lease %q", val)
+	}
+
+	token, err := strconv.ParseInt(val[sep+1:], 10, 64)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return val[:sep], token, nil
+}
+
+func leaseValue(node string, token int64) string {
+
+	return fmt.Sprintf("%s|%d", node, token)
+}
+
+func millis(d time.Duration) int64 {
+
+	return int64(d / time.Millisecond)
+}
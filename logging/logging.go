@@ -0,0 +1,446 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/redact"
+)
+
+// leveled, structured logging. every entry has a time, a level, the
+// component that wrote it, a message and any number of key/value fields:
+//
+//	var log = logging.New("thordb")
+//	log.Info("machine registered", "machine", id, "addr", addr)
+//
+// entries are written as text or as one json object per line, and always
+// pass through the redact package first. levels can be changed while the
+// process runs, for every component or for one.
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var ErrUnknownLevel = errors.New("logging: unknown level")
+var ErrUnknownFormat = errors.New("logging: unknown format")
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+
+	if l < Debug || l > Error {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "warning" {
+		s = "warn"
+	}
+
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+
+	return Info, ErrUnknownLevel
+}
+
+type Format int
+
+const (
+	Text Format = iota
+	JSON
+)
+
+func ParseFormat(s string) (Format, error) {
+
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "text", "":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+
+	return Text, ErrUnknownFormat
+}
+
+var (
+	mu        sync.Mutex
+	out       io.Writer = redact.NewWriter(os.Stderr)
+	format    Format    = Text
+	level     Level     = Info
+	overrides           = map[string]Level{}
+
+	// tests replace it
+	now = time.Now
+)
+
+// SetOutput sets where entries go. w is wrapped so credentials are masked.
+func SetOutput(w io.Writer) {
+
+	mu.Lock()
+	out = redact.NewWriter(w)
+	mu.Unlock()
+}
+
+func SetFormat(f Format) {
+
+	mu.Lock()
+	format = f
+	mu.Unlock()
+}
+
+// SetLevel sets the level for every component without a level of its own.
+func SetLevel(l Level) {
+
+	mu.Lock()
+	level = l
+	mu.Unlock()
+}
+
+// SetComponentLevel overrides the level for one component.
+func SetComponentLevel(component string, l Level) {
+
+	mu.Lock()
+	overrides[component] = l
+	mu.Unlock()
+}
+
+// ClearComponentLevel puts the component back on the global level.
+func ClearComponentLevel(component string) {
+
+	mu.Lock()
+	delete(overrides, component)
+	mu.Unlock()
+}
+
+// Levels returns the global level and every component override.
+func Levels() (Level, map[string]Level) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	copied := make(map[string]Level, len(overrides))
+	for k, v := range overrides {
+		copied[k] = v
+	}
+
+	return level, copied
+}
+
+// ParseLevels applies a level spec such as "info" or "warn,thordb=debug":
+// a bare level sets the global level, component=level sets an override.
+func ParseLevels(spec string) error {
+
+	global, components, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if global != nil {
+		level = *global
+	}
+
+	for k, v := range components {
+		overrides[k] = v
+	}
+
+	return nil
+}
+
+func parseSpec(spec string) (*Level, map[string]Level, error) {
+
+	var global *Level
+	components := map[string]Level{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if i := strings.Index(part, "="); i >= 0 {
+			l, err := ParseLevel(part[i+1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %q", err, part)
+			}
+			components[strings.TrimSpace(part[:i])] = l
+			continue
+		}
+
+		l, err := ParseLevel(part)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %q", err, part)
+		}
+		global = &l
+	}
+
+	return global, components, nil
+}
+
+// Configure reads THORIUM_LOG_LEVEL and THORIUM_LOG_FORMAT.
+func Configure() error {
+
+	if spec := os.Getenv("THORIUM_LOG_LEVEL"); spec != "" {
+		err := ParseLevels(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := ParseFormat(os.Getenv("THORIUM_LOG_FORMAT"))
+	if err != nil {
+		return err
+	}
+	SetFormat(f)
+
+	return nil
+}
+
+type Logger struct {
+	component string
+	fields    []interface{}
+}
+
+// New returns the logger for a component. loggers are cheap and usually
+// kept in a package level var.
+func New(component string) *Logger {
+
+	return &Logger{component: component}
+}
+
+// With returns a logger that adds the given key/value pairs to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	return &Logger{component: l.component, fields: fields}
+}
+
+func (l *Logger) Enabled(at Level) bool {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	return at >= l.levelLocked()
+}
+
+func (l *Logger) levelLocked() Level {
+
+	if v, ok := overrides[l.component]; ok {
+		return v
+	}
+
+	return level
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(Debug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(Info, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(Warn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(Error, msg, kv) }
+
+// Fatal logs at error level and exits.
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+
+	l.log(Error, msg, kv)
+	os.Exit(1)
+}
+
+func (l *Logger) log(at Level, msg string, kv []interface{}) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if at < l.levelLocked() {
+		return
+	}
+
+	fields := kv
+	if len(l.fields) > 0 {
+		fields = append(append([]interface{}{}, l.fields...), kv...)
+	}
+
+	e := entry{
+		time:      now().UTC(),
+		level:     at,
+		component: l.component,
+		msg:       redact.String(msg),
+		fields:    pairs(fields),
+	}
+
+	var line []byte
+	if format == JSON {
+		line = e.json()
+	} else {
+		line = e.text()
+	}
+
+	out.Write(line)
+}
+
+type pair struct {
+	key   string
+	value interface{}
+}
+
+// pairs turns key/value arguments into fields. a key without a value, or a
+// key that isn't a string, is kept under "!badkey" rather than dropped.
+func pairs(kv []interface{}) []pair {
+
+	var ps []pair
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			ps = append(ps, pair{"!badkey", kv[i]})
+			i--
+			continue
+		}
+		ps = append(ps, pair{key, scrub(kv[i+1])})
+	}
+
+	return ps
+}
+
+// scrub redacts strings and errors before they are quoted or escaped, when
+// the output writer could no longer recognize them.
+func scrub(v interface{}) interface{} {
+
+	switch v := v.(type) {
+	case string:
+		return redact.String(v)
+	case error:
+		return redact.String(v.Error())
+	}
+
+	return v
+}
+
+type entry struct {
+	time      time.Time
+	level     Level
+	component string
+	msg       string
+	fields    []pair
+}
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+func (e *entry) text() []byte {
+
+	var b strings.Builder
+	b.WriteString(e.time.Format(timeFormat))
+	b.WriteString(" ")
+	b.WriteString(strings.ToUpper(e.level.String()))
+	if e.component != "" {
+		b.WriteString(" [")
+		b.WriteString(e.component)
+		b.WriteString("]")
+	}
+	b.WriteString(" ")
+	b.WriteString(e.msg)
+
+	for _, p := range e.fields {
+		b.WriteString(" ")
+		b.WriteString(p.key)
+		b.WriteString("=")
+		b.WriteString(quote(textValue(p.value)))
+	}
+
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+func textValue(v interface{}) string {
+
+	switch v := v.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return fmt.Sprint(v)
+}
+
+func quote(s string) string {
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+func (e *entry) json() []byte {
+
+	m := make(map[string]interface{}, len(e.fields)+4)
+	for _, p := range e.fields {
+		m[p.key] = jsonValue(p.value)
+	}
+
+	// the fixed keys win over fields of the same name
+	m["time"] = e.time.Format(timeFormat)
+	m["level"] = e.level.String()
+	m["msg"] = e.msg
+	if e.component != "" {
+		m["component"] = e.component
+	}
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		// a field that can't be marshaled falls back to its text form
+		for _, p := range e.fields {
+			m[p.key] = textValue(p.value)
+		}
+		line, _ = json.Marshal(m)
+	}
+
+	return append(line, '\n')
+}
+
+func jsonValue(v interface{}) interface{} {
+
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return v
+}
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func capture(t *testing.T) *bytes.Buffer {
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetFormat(Text)
+	SetLevel(Info)
+	now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	t.Cleanup(func() {
+		SetLevel(Info)
+		SetFormat(Text)
+		mu.Lock()
+		overrides = map[string]Level{}
+		mu.Unlock()
+		now = time.Now
+	})
+
+	return &buf
+}
+
+func TestText(t *testing.T) {
+
+	buf := capture(t)
+
+	New("thordb").With("machine", 4).Info("machine registered", "addr", "10.0.0.1", "note", "two words", "err", errors.New("boom"))
+
+	want := `2026-01-02T03:04:05.000Z INFO [thordb] machine registered machine=4 addr=10.0.0.1 note="two words" err=boom` + "\n"
+	if buf.String() != want {
+		t.Fatalf("got %q\nwant %q", buf.String(), want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+
+	buf := capture(t)
+	SetFormat(JSON)
+
+	New("host").Warn("heartbeat failed", "status", 502, "err", errors.New("bad gateway"), "msg", "ignored")
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry["level"] != "warn" || entry["component"] != "host" || entry["msg"] != "heartbeat failed" {
+		t.Fatalf("fixed keys: %v", entry)
+	}
+	if entry["status"] != float64(502) || entry["err"] != "bad gateway" {
+		t.Fatalf("fields: %v", entry)
+	}
+}
+
+func TestLevels(t *testing.T) {
+
+	buf := capture(t)
+
+	db := New("thordb")
+	master := New("master")
+
+	db.Debug("hidden")
+	master.Info("shown")
+
+	err := ParseLevels("warn,thordb=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Debug("query")
+	master.Info("hidden")
+	master.Warn("slow")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("entry below its level was written:\n%s", out)
+	}
+	for _, msg := range []string{"shown", "query", "slow"} {
+		if !strings.Contains(out, msg) {
+			t.Fatalf("missing %q:\n%s", msg, out)
+		}
+	}
+
+	ClearComponentLevel("thordb")
+	if db.Enabled(Info) {
+		t.Fatal("thordb should be back on the global level")
+	}
+
+	if ParseLevels("loud") == nil || ParseLevels("thordb=") == nil {
+		t.Fatal("bad level specs should fail")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+
+	buf := capture(t)
+
+	New("master").Info("login", "body", `{"password":"hunter2"}`)
+
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("password reached the log: %s", buf.String())
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+
+	buf := capture(t)
+
+	New("http").StdLogger(Info).Print("[martini] Started GET /status")
+
+	if !strings.Contains(buf.String(), `INFO [http] [martini] Started GET /status`) {
+		t.Fatalf("got %q", buf.String())
+	}
+}
+
+func TestBadKey(t *testing.T) {
+
+	buf := capture(t)
+
+	New("").Info("odd", "lonely")
+
+	if !strings.Contains(buf.String(), "!badkey=lonely") {
+		t.Fatalf("got %q", buf.String())
+	}
+}
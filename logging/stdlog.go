@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+)
+
+// some code only knows how to write to a *log.Logger: martini's request log,
+// vendored packages and anything still calling the log package. these turn
+// each line it writes into an entry.
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(line) > 0 {
+			w.logger.log(w.level, string(line), nil)
+		}
+	}
+
+	return len(p), nil
+}
+
+// StdLogger returns a *log.Logger whose lines are logged at the given level.
+func (l *Logger) StdLogger(at Level) *log.Logger {
+
+	return log.New(&lineWriter{logger: l, level: at}, "", 0)
+}
+
+// CaptureStdLog sends the log package's default logger through l.
+func CaptureStdLog(l *Logger) {
+
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&lineWriter{logger: l, level: Info})
+}
@@ -0,0 +1,397 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counters, gauges and histograms with labels, written in the prometheus text
+// exposition format. metrics are created once at package level and register
+// themselves with a Registry, usually Default.
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var Default = NewRegistry()
+
+type metric interface {
+	desc() *desc
+	write(w io.Writer)
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+	hooks   []func()
+}
+
+func NewRegistry() *Registry {
+
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// register panics on a duplicate name, like a duplicate route would.
+func (r *Registry) register(m metric) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := m.desc().name
+	if _, exists := r.metrics[name]; exists {
+		panic("metrics: duplicate metric " + name)
+	}
+
+	r.metrics[name] = m
+}
+
+// OnScrape runs fn before every scrape, to set gauges that are cheaper to
+// read on demand than to keep current.
+func (r *Registry) OnScrape(fn func()) {
+
+	r.mu.Lock()
+	r.hooks = append(r.hooks, fn)
+	r.mu.Unlock()
+}
+
+// WriteTo writes every metric in the text exposition format, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+
+	r.mu.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		m := r.metrics[name]
+		d := m.desc()
+		fmt.Fprintf(&buf, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", d.name, d.kind)
+		m.write(&buf)
+	}
+	r.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) Handler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+
+	c := &Counter{vec: newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+
+	g := &Gauge{vec: newVec(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram creates a histogram with the given upper bounds, in
+// increasing order. nil uses DefaultBuckets.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	h := &Histogram{
+		d:       desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+
+	return Default.NewCounter(name, help, labels...)
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+
+	return Default.NewGauge(name, help, labels...)
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func Handler() http.Handler {
+
+	return Default.Handler()
+}
+
+// vec holds one value per combination of label values
+type vec struct {
+	d      desc
+	mu     sync.Mutex
+	series map[string]*valueSeries
+}
+
+type valueSeries struct {
+	labels []string
+	value  float64
+}
+
+func newVec(name string, help string, kind string, labels []string) vec {
+
+	return vec{d: desc{name: name, help: help, kind: kind, labels: labels}, series: make(map[string]*valueSeries)}
+}
+
+func (v *vec) desc() *desc {
+
+	return &v.d
+}
+
+// get returns the series for labelValues. the caller holds v.mu.
+func (v *vec) get(labelValues []string) *valueSeries {
+
+	checkLabels(&v.d, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &valueSeries{labels: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+
+	v.mu.Lock()
+	v.get(labelValues).value += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) write(w io.Writer) {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.d.name, formatLabels(v.d.labels, s.labels, ""), formatValue(s.value))
+	}
+}
+
+type Counter struct {
+	vec
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+
+	c.add(1, labelValues)
+}
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+
+	if delta < 0 {
+		panic("metrics: counter " + c.d.name + " decreased")
+	}
+
+	c.add(delta, labelValues)
+}
+
+type Gauge struct {
+	vec
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+
+	g.mu.Lock()
+	g.get(labelValues).value = value
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+
+	g.add(delta, labelValues)
+}
+
+// Reset forgets every series, for gauges set in full on every scrape.
+func (g *Gauge) Reset() {
+
+	g.mu.Lock()
+	g.series = make(map[string]*valueSeries)
+	g.mu.Unlock()
+}
+
+type Histogram struct {
+	d       desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) desc() *desc {
+
+	return &h.d
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+
+	checkLabels(&h.d, labelValues)
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, formatLabels(h.d.labels, s.labels, formatValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, formatLabels(h.d.labels, s.labels, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.d.name, formatLabels(h.d.labels, s.labels, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.d.name, formatLabels(h.d.labels, s.labels, ""), s.count)
+	}
+}
+
+func checkLabels(d *desc, labelValues []string) {
+
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+}
+
+func sortedKeys(series map[string]*valueSeries) []string {
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders {name="value",...}, with an le label for histogram
+// buckets when le is not empty
+func formatLabels(names []string, values []string, le string) string {
+
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if le != "" {
+		if len(names) > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "le=\"%s\"", le)
+	}
+	buf.WriteByte('}')
+
+	return buf.String()
+}
+
+func formatValue(v float64) string {
+
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+
+	return helpEscaper.Replace(s)
+}
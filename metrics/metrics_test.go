@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+)
+
+func TestExposition(t *testing.T) {
+
+	r := NewRegistry()
+
+	logins := r.NewCounter("thorium_logins_total", "Logins by result.", "result")
+	logins.Inc("success")
+	logins.Inc("success")
+	logins.Inc("failure")
+
+	games := r.NewGauge("thorium_games", "Games by state.", "state")
+	games.Set(3, "running")
+
+	latency := r.NewHistogram("thorium_placement_seconds", "Placement latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+
+	quoted := r.NewGauge("thorium_quoted", "Help with a \\ backslash.", "name")
+	quoted.Set(1, "a \"b\"\nc")
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+
+	want := `# HELP thorium_games Games by state.
+# TYPE thorium_games gauge
+thorium_games{state="running"} 3
+# HELP thorium_logins_total Logins by result.
+# TYPE thorium_logins_total counter
+thorium_logins_total{result="failure"} 1
+thorium_logins_total{result="success"} 2
+# HELP thorium_placement_seconds Placement latency.
+# TYPE thorium_placement_seconds histogram
+thorium_placement_seconds_bucket{le="0.1"} 1
+thorium_placement_seconds_bucket{le="1"} 2
+thorium_placement_seconds_bucket{le="+Inf"} 3
+thorium_placement_seconds_sum 5.55
+thorium_placement_seconds_count 3
+# HELP thorium_quoted Help with a \\ backslash.
+# TYPE thorium_quoted gauge
+thorium_quoted{name="a \"b\"\nc"} 1
+`
+
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestOnScrape(t *testing.T) {
+
+	r := NewRegistry()
+	sessions := r.NewGauge("thorium_sessions", "Active sessions.")
+
+	n := 0
+	r.OnScrape(func() {
+		n++
+		sessions.Set(float64(n))
+	})
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	buf.Reset()
+	r.WriteTo(&buf)
+
+	if !strings.Contains(buf.String(), "thorium_sessions 2\n") {
+		t.Fatalf("gauge not refreshed on scrape:\n%s", buf.String())
+	}
+}
+
+func TestMiddlewareLabelsByRoute(t *testing.T) {
+
+	m := martini.Classic()
+	m.Use(Middleware("test"))
+	m.Get("/games/:id", func() (int, string) { return 404, "Game Not Found" })
+	m.Get("/metrics", Handler().ServeHTTP)
+
+	for _, path := range []string{"/games/1", "/games/2", "/nowhere"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	res := httptest.NewRecorder()
+	m.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := res.Body.String()
+	for _, line := range []string{
+		`test_http_requests_total{method="GET",route="/games/:id",status="404"} 2`,
+		`test_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`test_http_request_duration_seconds_count{method="GET",route="/games/:id"} 2`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("missing %s in\n%s", line, body)
+		}
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("metrics returned %d", res.Code)
+	}
+}
+
+func TestLabelCountChecked(t *testing.T) {
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("wrong number of label values accepted")
+		}
+	}()
+
+	NewRegistry().NewCounter("thorium_x_total", "x", "a").Inc()
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+var routeType = reflect.TypeOf((*martini.Route)(nil)).Elem()
+
+// Middleware counts requests and times them per route, by the route's
+// pattern so /games/:id is one series. it registers <namespace>_http_requests_total
+// and <namespace>_http_request_duration_seconds with Default, so call it
+// once per process.
+func Middleware(namespace string) martini.Handler {
+
+	requests := NewCounter(namespace+"_http_requests_total", "HTTP requests by route and status.", "method", "route", "status")
+	latency := NewHistogram(namespace+"_http_request_duration_seconds", "HTTP request latency by route.", nil, "method", "route")
+
+	return func(res http.ResponseWriter, req *http.Request, c martini.Context) {
+
+		start := time.Now()
+		c.Next()
+
+		// the router maps the route it matched into the request context
+		route := "unmatched"
+		if v := c.Get(routeType); v.IsValid() {
+			route = v.Interface().(martini.Route).Pattern()
+		}
+
+		status := 200
+		if rw, ok := res.(martini.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+
+		requests.Inc(req.Method, route, strconv.Itoa(status))
+		latency.Since(start, req.Method, route)
+	}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Account struct {
 	UserId       int    `json:"uid"`
 	Username     string `json:"username"`
@@ -12,6 +14,101 @@ type Machine struct {
 	RemoteAddress string `json:"remoteAddress"`
 	ListenPort    int    `json:"listenPort"`
 	MachineKey    string `json:"machineKey"`
+	Region        string `json:"region"`
+	Zone          string `json:"zone"`
+}
+
+type MachineStatus struct {
+	MachineId      int       `json:"machineId"`
+	RemoteAddress  string    `json:"remoteAddress"`
+	ListenPort     int       `json:"listenPort"`
+	Region         string    `json:"region"`
+	Zone           string    `json:"zone"`
+	State          string    `json:"state"`
+	Approval       string    `json:"approval"`
+	EnrolledWith   string    `json:"enrolledWith,omitempty"`
+	LastHeartbeat  time.Time `json:"lastHeartbeat"`
+	UsageCPU       float64   `json:"cpuUsagePct"`
+	UsageNetwork   float64   `json:"networkUsagePct"`
+	PlayerCapacity float64   `json:"playerCapacityPct"`
+	RunningGames   []int     `json:"runningGames"`
+	LoadingGames   []int     `json:"loadingGames"`
+}
+
+// a region as GET /v2/regions lists it. available machines are the active
+// ones with room for another game. running games, players and open seats
+// count the games of hosts that aren't draining.
+type RegionCapacity struct {
+	Region            string   `json:"region"`
+	Zones             []string `json:"zones"`
+	Machines          int      `json:"machines"`
+	AvailableMachines int      `json:"availableMachines"`
+	RunningGames      int      `json:"runningGames"`
+	Players           int      `json:"players"`
+	OpenSeats         int      `json:"openSeats"`
+}
+
+type JoinToken struct {
+	TokenId     int        `json:"tokenId"`
+	Label       string     `json:"label"`
+	MaxUses     int        `json:"maxUses"`
+	Uses        int        `json:"uses"`
+	AutoApprove bool       `json:"autoApprove"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedOn   time.Time  `json:"createdOn"`
+	ExpiresOn   *time.Time `json:"expiresOn,omitempty"`
+	RevokedOn   *time.Time `json:"revokedOn,omitempty"`
+}
+
+type LoadingHost struct {
+	GameId      int       `json:"gameId"`
+	MachineId   int       `json:"machineId"`
+	Map         string    `json:"map"`
+	Mode        string    `json:"mode"`
+	KickoffTime time.Time `json:"kickoffTime"`
+}
+
+type Session struct {
+	UserId    int    `json:"uid"`
+	Username  string `json:"username"`
+	ExpiresIn int    `json:"expiresInSeconds"`
+}
+
+type Restriction struct {
+	RestrictionId int        `json:"restrictionId"`
+	UserId        int        `json:"uid"`
+	Kind          string     `json:"kind"`
+	Reason        string     `json:"reason"`
+	Actor         string     `json:"actor"`
+	CreatedOn     time.Time  `json:"createdOn"`
+	ExpiresOn     *time.Time `json:"expiresOn,omitempty"`
+	LiftedOn      *time.Time `json:"liftedOn,omitempty"`
+	LiftedBy      string     `json:"liftedBy,omitempty"`
+}
+
+type ModerationRecord struct {
+	RecordId      int       `json:"recordId"`
+	RestrictionId int       `json:"restrictionId"`
+	UserId        int       `json:"uid"`
+	Action        string    `json:"action"`
+	Kind          string    `json:"kind"`
+	Reason        string    `json:"reason"`
+	Actor         string    `json:"actor"`
+	RecordedOn    time.Time `json:"recordedOn"`
+}
+
+type AuditEvent struct {
+	EventId       int       `json:"eventId"`
+	Type          string    `json:"type"`
+	OccurredOn    time.Time `json:"occurredOn"`
+	Success       bool      `json:"success"`
+	Actor         string    `json:"actor,omitempty"`
+	UserId        int       `json:"uid,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	MachineId     int       `json:"machineId,omitempty"`
+	RemoteAddress string    `json:"remoteAddress"`
+	UserAgent     string    `json:"userAgent"`
+	Detail        string    `json:"detail,omitempty"`
 }
 
 type HostServer struct {
@@ -29,6 +126,112 @@ type Game struct {
 	MaximumPlayers int    `json:"maxPlayers"`
 }
 
+// game states. a game is requested until a host is picked for it, and
+// provisioning while that host is asked to start its game server. it is
+// loading until the game server registers, and running until the game server
+// shuts down, when it has ended. ending is a game being stopped that hasn't
+// stopped yet. a game that never started, or whose host was lost, has failed.
+const GameRequested string = "requested"
+const GameProvisioning string = "provisioning"
+const GameLoading string = "loading"
+const GameRunning string = "running"
+const GameEnding string = "ending"
+const GameEnded string = "ended"
+const GameFailed string = "failed"
+
+// GameTransitions lists the states each state may move to. ended and failed
+// are final. the database enforces the same moves, see sql/baseline.sql.
+var GameTransitions = map[string][]string{
+	GameRequested:    {GameProvisioning, GameFailed},
+	GameProvisioning: {GameLoading, GameRunning, GameFailed},
+	GameLoading:      {GameRunning, GameEnding, GameEnded, GameFailed},
+	GameRunning:      {GameEnding, GameEnded, GameFailed},
+	GameEnding:       {GameEnded, GameFailed},
+	GameEnded:        {},
+	GameFailed:       {},
+}
+
+// CanTransition reports whether a game may move from one state to another
+func CanTransition(from string, to string) bool {
+
+	for _, state := range GameTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+type GameTransition struct {
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to"`
+	OccurredOn time.Time `json:"occurredOn"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// a game as the server browser lists it
+type GameListing struct {
+	Game
+	State     string `json:"state"`
+	Region    string `json:"region"`
+	OpenSlots int    `json:"openSlots"`
+}
+
+// a game and how it got to its state
+type GameDetail struct {
+	GameListing
+	MachineId   int              `json:"machineId,omitempty"`
+	RequestedOn time.Time        `json:"requestedOn"`
+	Transitions []GameTransition `json:"transitions"`
+}
+
+// how a match went for a player
+const OutcomeWin string = "win"
+const OutcomeLoss string = "loss"
+const OutcomeDraw string = "draw"
+const OutcomeAbandoned string = "abandoned"
+
+// a match a character played, as its game server reported it
+type CharacterMatch struct {
+	GameId     int                `json:"gameId"`
+	Map        string             `json:"map"`
+	Mode       string             `json:"mode"`
+	ReportedOn time.Time          `json:"reportedOn"`
+	Outcome    string             `json:"outcome"`
+	Team       int                `json:"team"`
+	Score      int                `json:"score"`
+	Stats      map[string]float64 `json:"stats"`
+}
+
+// a stat summed over every match a character played, and its best match
+type StatTotal struct {
+	Total float64 `json:"total"`
+	Best  float64 `json:"best"`
+}
+
+// a character's lifetime record
+type CharacterRecord struct {
+	Matches     int                  `json:"matches"`
+	Wins        int                  `json:"wins"`
+	Losses      int                  `json:"losses"`
+	Draws       int                  `json:"draws"`
+	Abandoned   int                  `json:"abandoned"`
+	TotalScore  int64                `json:"totalScore"`
+	BestScore   int                  `json:"bestScore"`
+	LastMatchOn *time.Time           `json:"lastMatchOn,omitempty"`
+	Stats       map[string]StatTotal `json:"stats"`
+}
+
+// what anyone may see of a character
+type CharacterProfile struct {
+	CharacterId int             `json:"characterId"`
+	Name        string          `json:"name"`
+	ClassId     int             `json:"classId"`
+	Level       int             `json:"level"`
+	Record      CharacterRecord `json:"record"`
+}
+
 type Vector3 struct {
 	X float64 `json:"x"`
 	Y float64 `json:"y"`
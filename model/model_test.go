@@ -0,0 +1,71 @@
+package model
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// the database refuses the moves model.GameTransitions doesn't list, in the
+// game_transition trigger. the two lists have to agree.
+func TestGameTransitionsMatchSchema(t *testing.T) {
+
+	schema, err := ioutil.ReadFile("../sql/baseline.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(schema)
+	start := strings.Index(body, "CREATE FUNCTION game_transition()")
+	if start < 0 {
+		t.Fatal("no game_transition function in the schema")
+	}
+	body = body[start:]
+	body = body[:strings.Index(body, "$$ language plpgsql;")]
+
+	var inSchema []string
+	for _, m := range regexp.MustCompile(`\('(\w+)', '(\w+)'\)`).FindAllStringSubmatch(body, -1) {
+		inSchema = append(inSchema, m[1]+" -> "+m[2])
+	}
+
+	var inModel []string
+	for from, targets := range GameTransitions {
+		for _, to := range targets {
+			inModel = append(inModel, from+" -> "+to)
+		}
+	}
+
+	sort.Strings(inSchema)
+	sort.Strings(inModel)
+
+	if strings.Join(inSchema, "\n") != strings.Join(inModel, "\n") {
+		t.Fatalf("the schema allows\n%s\n\nthe model allows\n%s", strings.Join(inSchema, "\n"), strings.Join(inModel, "\n"))
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+
+	for _, tc := range []struct {
+		from string
+		to   string
+		ok   bool
+	}{
+		{GameRequested, GameProvisioning, true},
+		{GameProvisioning, GameRunning, true},
+		{GameRunning, GameEnded, true},
+		{GameRunning, GameLoading, false},
+		{GameEnded, GameRunning, false},
+		{GameFailed, GameRequested, false},
+		{GameRequested, GameRequested, false},
+	} {
+		if CanTransition(tc.from, tc.to) != tc.ok {
+			t.Errorf("%s -> %s: got %v, want %v", tc.from, tc.to, !tc.ok, tc.ok)
+		}
+	}
+
+	if len(GameTransitions[GameEnded]) != 0 || len(GameTransitions[GameFailed]) != 0 {
+		t.Error("a final state has moves")
+	}
+}
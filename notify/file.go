@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// FileNotifier appends every message to a writer instead of delivering it. it
+// is meant for local development, where the reset link can be read from the
+// file.
+type FileNotifier struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewWriterNotifier(out io.Writer) *FileNotifier {
+
+	return &FileNotifier{out: out}
+}
+
+func NewFileNotifier(path string) (*FileNotifier, error) {
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileNotifier{out: file}, nil
+}
+
+func (n *FileNotifier) Send(msg Message) error {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err := n.out.Write(append(format("thorium", msg), '\n'))
+	return err
+}
@@ -0,0 +1,26 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+)
+
+// this package delivers messages to players outside the game, such as password
+// reset links. the master picks an implementation at startup.
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// format renders msg as a minimal rfc 5322 message
+func format(from string, msg Message) []byte {
+
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		from, msg.To, msg.Subject, time.Now().UTC().Format(time.RFC1123Z), msg.Body))
+}
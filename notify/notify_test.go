@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFileNotifierWritesMessage(t *testing.T) {
+
+	var buf bytes.Buffer
+	n := NewWriterNotifier(&buf)
+
+	err := n.Send(Message{To: "alice@example.com", Subject: "Password reset", Body: "token abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"To: alice@example.com\r\n", "Subject: Password reset\r\n", "\r\n\r\ntoken abc"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("message missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSMTPNotifierRejectsHeaderInjection(t *testing.T) {
+
+	n, err := NewSMTPNotifier("localhost:25", "noreply@example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Send(Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "x", Body: "y"})
+	if err != ErrInvalidRecipient {
+		t.Fatalf("got %v, want ErrInvalidRecipient", err)
+	}
+}
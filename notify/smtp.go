@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+var ErrInvalidRecipient = errors.New("notify: invalid recipient")
+
+// SMTPNotifier sends mail through a relay. Auth may be nil for relays that
+// accept mail without logging in.
+type SMTPNotifier struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func NewSMTPNotifier(addr string, from string, username string, password string) (*SMTPNotifier, error) {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &SMTPNotifier{Addr: addr, From: from}
+	if username != "" {
+		n.Auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return n, nil
+}
+
+func (n *SMTPNotifier) Send(msg Message) error {
+
+	// a newline in the address would let the caller inject headers
+	if msg.To == "" || strings.ContainsAny(msg.To, "\r\n") {
+		return ErrInvalidRecipient
+	}
+
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{msg.To}, format(n.From, msg))
+}
@@ -0,0 +1,336 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+// an OpenAPI 3 document built from the go types requests and responses are
+// made of. a server lists its routes as Operations with zero values of their
+// body types, and New reflects over the types for the schemas, taking
+// names from json tags and constraints from validate tags. nothing about the
+// wire format is written twice.
+
+const Version string = "3.0.3"
+
+// security schemes an operation can name in Auth
+const AuthSession string = "session"
+const AuthGameServer string = "gameServer"
+const AuthMachine string = "machine"
+const AuthAdmin string = "admin"
+
+type Operation struct {
+	Method  string
+	Path    string // a martini pattern, /games/:id
+	Tag     string
+	Summary string
+	Auth    string
+	Query   []Param
+
+	// zero values of the body types, nil for none. a response that is a
+	// list is given as a slice.
+	Request  interface{}
+	Response interface{}
+
+	// the success status, 200 if unset
+	Status int
+
+	// routes kept for old clients that new ones shouldn't use
+	Deprecated bool
+}
+
+type Param struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// Document is an OpenAPI document. it marshals with sorted keys, so the same
+// operations always give the same bytes.
+type Document map[string]interface{}
+
+type object = map[string]interface{}
+
+func New(title string, version string, ops []Operation) Document {
+
+	b := &builder{schemas: object{}}
+	paths := object{}
+
+	for _, op := range ops {
+		path, params := convertPath(op.Path)
+
+		item, ok := paths[path].(object)
+		if !ok {
+			item = object{}
+			paths[path] = item
+		}
+
+		item[strings.ToLower(op.Method)] = b.operation(op, params)
+	}
+
+	return Document{
+		"openapi": Version,
+		"info":    object{"title": title, "version": version},
+		"paths":   paths,
+		"components": object{
+			"schemas": b.schemas,
+			"securitySchemes": object{
+				AuthSession:    bearer("a player's session key"),
+				AuthGameServer: bearer("a game server's key, from its host"),
+				AuthMachine:    bearer("a host's machine key"),
+				AuthAdmin:      bearer("an operator token from cmd/admin-token"),
+			},
+		},
+	}
+}
+
+func bearer(description string) object {
+
+	return object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": description}
+}
+
+// Handler serves the document as json.
+func (d Document) Handler() http.HandlerFunc {
+
+	body, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		res.Write(body)
+	}
+}
+
+// Find returns the operation for a route, or nil.
+func Find(ops []Operation, method string, pattern string) *Operation {
+
+	for i := range ops {
+		if ops[i].Method == method && ops[i].Path == pattern {
+			return &ops[i]
+		}
+	}
+
+	return nil
+}
+
+// convertPath turns /games/:id into /games/{id} and lists its parameters
+func convertPath(pattern string) (string, []string) {
+
+	var params []string
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+
+	return strings.Join(parts, "/"), params
+}
+
+type builder struct {
+	schemas object
+}
+
+func (b *builder) operation(op Operation, pathParams []string) object {
+
+	o := object{
+		"summary":     op.Summary,
+		"operationId": operationId(op),
+	}
+
+	if op.Tag != "" {
+		o["tags"] = []string{op.Tag}
+	}
+
+	if op.Deprecated {
+		o["deprecated"] = true
+	}
+
+	if op.Auth != "" {
+		o["security"] = []object{{op.Auth: []string{}}}
+	}
+
+	var params []object
+	for _, name := range pathParams {
+		params = append(params, object{"name": name, "in": "path", "required": true, "schema": object{"type": "string"}})
+	}
+	for _, q := range op.Query {
+		params = append(params, object{"name": q.Name, "in": "query", "description": q.Description, "schema": object{"type": q.Type}})
+	}
+	if params != nil {
+		o["parameters"] = params
+	}
+
+	if op.Request != nil {
+		o["requestBody"] = object{
+			"required": true,
+			"content":  object{"application/json": object{"schema": b.schema(reflect.TypeOf(op.Request))}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = 200
+	}
+
+	success := object{"description": http.StatusText(status)}
+	if op.Response != nil {
+		success["content"] = object{"application/json": object{"schema": b.schema(reflect.TypeOf(op.Response))}}
+	}
+
+	responses := object{strconv.Itoa(status): success}
+	if op.Request != nil {
+		responses["400"] = object{"description": "the body is malformed or breaks a constraint, and the response names the field"}
+	}
+	if op.Auth != "" {
+		responses["401"] = object{"description": "missing or invalid token"}
+	}
+	o["responses"] = responses
+
+	return o
+}
+
+// operationId is the method and path in camel case, postGamesRegisterServer
+func operationId(op Operation) string {
+
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '_' || r == '.' || r == '-' }) {
+		if strings.HasPrefix(part, ":") {
+			part = "by" + strings.Title(part[1:])
+		}
+		id += strings.Title(part)
+	}
+
+	return id
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *builder) schema(t reflect.Type) object {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return object{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		name := t.String()
+		if _, done := b.schemas[name]; !done {
+			// registered before the fields so a type can refer to itself
+			b.schemas[name] = object{}
+			b.schemas[name] = b.structSchema(t)
+		}
+		return object{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return object{"type": "string", "format": "byte"}
+		}
+		return object{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return object{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	}
+
+	return object{}
+}
+
+func (b *builder) structSchema(t reflect.Type) object {
+
+	properties := object{}
+	var required []string
+
+	b.fields(t, properties, &required)
+
+	s := object{"type": "object", "properties": properties}
+	if required != nil {
+		s["required"] = required
+	}
+
+	return s
+}
+
+func (b *builder) fields(t reflect.Type, properties object, required *[]string) {
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		// embedded structs without a json name are flattened, as json does
+		if f.Anonymous && f.Tag.Get("json") == "" && f.Type.Kind() == reflect.Struct {
+			b.fields(f.Type, properties, required)
+			continue
+		}
+
+		name, ok := validation.JSONName(f)
+		if !ok {
+			continue
+		}
+
+		s := b.schema(f.Type)
+		for _, r := range validation.Rules(f) {
+			constrain(s, f.Type, r, name, required)
+		}
+		properties[name] = s
+	}
+}
+
+func constrain(s object, t reflect.Type, r validation.Rule, name string, required *[]string) {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch r.Name {
+	case "required":
+		*required = append(*required, name)
+		return
+	case "oneof":
+		s["enum"] = strings.Split(r.Arg, "|")
+		return
+	}
+
+	bound, _ := strconv.ParseFloat(r.Arg, 64)
+
+	var key string
+	switch t.Kind() {
+	case reflect.String:
+		key = "Length"
+	case reflect.Slice, reflect.Array:
+		key = "Items"
+	case reflect.Map:
+		key = "Properties"
+	}
+
+	if key == "" {
+		// numbers
+		if r.Name == "min" {
+			s["minimum"] = bound
+		} else {
+			s["maximum"] = bound
+		}
+		return
+	}
+
+	s[r.Name+key] = int(bound)
+}
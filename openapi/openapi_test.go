@@ -0,0 +1,110 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type newGame struct {
+	Map        string `json:"map" validate:"required"`
+	Mode       string `json:"mode" validate:"required,oneof=ffa|team"`
+	MaxPlayers int    `json:"maxPlayers" validate:"min=0,max=64"`
+	Tags       []string
+	Secret     string `json:"-"`
+}
+
+type game struct {
+	GameId  int               `json:"gameId"`
+	Created time.Time         `json:"created"`
+	Parent  *game             `json:"parent,omitempty"`
+	Labels  map[string]string `json:"labels"`
+}
+
+var ops = []Operation{
+	{Method: "POST", Path: "/games", Tag: "games", Auth: AuthSession, Request: newGame{}, Response: game{}, Status: 201},
+	{Method: "GET", Path: "/games/:id/server_info", Response: []game{}},
+}
+
+// get walks a decoded document by keys
+func get(t *testing.T, v interface{}, keys ...string) interface{} {
+
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			t.Fatalf("no object at %s in %v", k, keys)
+		}
+		v = m[k]
+	}
+
+	return v
+}
+
+func TestDocument(t *testing.T) {
+
+	res := httptest.NewRecorder()
+	New("test", "1", ops).Handler()(res, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	post := get(t, doc, "paths", "/games", "post")
+	if get(t, post, "operationId") != "postGames" {
+		t.Errorf("operationId %v", get(t, post, "operationId"))
+	}
+	if get(t, post, "responses", "201") == nil || get(t, post, "responses", "400") == nil || get(t, post, "responses", "401") == nil {
+		t.Errorf("responses %v", get(t, post, "responses"))
+	}
+	if ref := get(t, post, "requestBody", "content", "application/json", "schema", "$ref"); ref != "#/components/schemas/openapi.newGame" {
+		t.Errorf("request ref %v", ref)
+	}
+
+	info := get(t, doc, "paths", "/games/{id}/server_info", "get")
+	params := get(t, info, "parameters").([]interface{})
+	if len(params) != 1 || get(t, params[0], "name") != "id" || get(t, params[0], "in") != "path" {
+		t.Errorf("path parameters %v", params)
+	}
+	if get(t, info, "responses", "200", "content", "application/json", "schema", "type") != "array" {
+		t.Error("a slice response should be an array")
+	}
+
+	ng := get(t, doc, "components", "schemas", "openapi.newGame")
+	if !reflect.DeepEqual(get(t, ng, "required"), []interface{}{"map", "mode"}) {
+		t.Errorf("required %v", get(t, ng, "required"))
+	}
+	if !reflect.DeepEqual(get(t, ng, "properties", "mode", "enum"), []interface{}{"ffa", "team"}) {
+		t.Error("oneof should become an enum")
+	}
+	if get(t, ng, "properties", "maxPlayers", "minimum") != 0.0 || get(t, ng, "properties", "maxPlayers", "maximum") != 64.0 {
+		t.Errorf("maxPlayers %v", get(t, ng, "properties", "maxPlayers"))
+	}
+	if get(t, ng, "properties", "Tags", "type") != "array" || get(t, ng, "properties", "Secret") != nil {
+		t.Errorf("properties %v", get(t, ng, "properties"))
+	}
+
+	g := get(t, doc, "components", "schemas", "openapi.game")
+	if get(t, g, "properties", "created", "format") != "date-time" {
+		t.Error("time.Time should be a date-time string")
+	}
+	if get(t, g, "properties", "parent", "$ref") != "#/components/schemas/openapi.game" {
+		t.Error("a type should be able to refer to itself")
+	}
+	if get(t, g, "properties", "labels", "additionalProperties", "type") != "string" {
+		t.Error("maps should use additionalProperties")
+	}
+}
+
+func TestFind(t *testing.T) {
+
+	if op := Find(ops, "GET", "/games/:id/server_info"); op == nil || op.Response == nil {
+		t.Fatal("couldn't find a listed route")
+	}
+
+	if Find(ops, "DELETE", "/games") != nil {
+		t.Fatal("found an unlisted route")
+	}
+}
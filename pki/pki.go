@@ -0,0 +1,263 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+// a small certificate authority for the cluster. the master and every host
+// get a certificate from it that is good for both ends of a connection, so
+// the same pair serves their tls listener and authenticates their calls to
+// each other.
+
+var ErrNoCertificate = errors.New("pki: no certificate in pem data")
+var ErrNoPrivateKey = errors.New("pki: no private key in pem data")
+
+type CA struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// NewCA creates a self signed certificate authority.
+func NewCA(name string, ttl time.Duration) (*CA, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"thorium"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(ttl),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// LoadCA reads a certificate authority written by Save.
+func LoadCA(certFile string, keyFile string) (*CA, error) {
+
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, ErrNoPrivateKey
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// Save writes the authority's certificate and key as pem. the key file is
+// only readable by its owner.
+func (ca *CA) Save(certFile string, keyFile string) error {
+
+	keyPEM, err := encodeKey(ca.Key)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(certFile, ca.CertPEM(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(keyFile, keyPEM, 0600)
+}
+
+func (ca *CA) CertPEM() []byte {
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
+
+// Issue signs a certificate for name, valid for the dns names and ip
+// addresses in hosts, that can serve tls and authenticate as a client. it
+// returns the certificate and its private key as pem.
+func (ca *CA) Issue(name string, hosts []string, ttl time.Duration) ([]byte, []byte, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"thorium"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// ServerConfig is the tls config of a listener serving certFile. with a
+// caFile, clients presenting a certificate must have one signed by that
+// authority, and requireClientCert refuses clients without one.
+func ServerConfig(certFile string, keyFile string, caFile string, requireClientCert bool) (*tls.Config, error) {
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	cfg.ClientCAs, err = loadPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig is the tls config for calling servers whose certificates are
+// signed by caFile. with a certFile the client authenticates with it.
+func ClientConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	var err error
+	if caFile != "" {
+		cfg.RootCAs, err = loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, ErrNoCertificate
+	}
+
+	return pool, nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, ErrNoCertificate
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerial() (*big.Int, error) {
+
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
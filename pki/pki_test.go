@@ -0,0 +1,121 @@
+package pki
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes a fresh authority and two certificates signed by it to a temp dir
+func testFiles(t *testing.T) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := NewCA("thorium test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ca.Save(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"master", "host"} {
+		cert, key, err := ca.Issue(name, []string{"localhost", "127.0.0.1"}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, name+".crt"), cert, 0644)
+		ioutil.WriteFile(filepath.Join(dir, name+".key"), key, 0600)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestMutualTLS(t *testing.T) {
+
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	serverCfg, err := ServerConfig(file("master.crt"), file("master.key"), file("ca.crt"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = serverCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	// the host authenticates with its certificate
+	clientCfg, err := ClientConfig(file("ca.crt"), file("host.crt"), file("host.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "host" {
+		t.Fatalf("server saw client %q, want host", body)
+	}
+
+	// without a certificate the handshake fails
+	anonCfg, err := ClientConfig(file("ca.crt"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = &http.Client{Transport: &http.Transport{TLSClientConfig: anonCfg}}
+	if _, err = c.Get(srv.URL); err == nil {
+		t.Fatal("client without a certificate was accepted")
+	}
+
+	// and so does a certificate from another authority
+	other, _ := NewCA("other", time.Hour)
+	cert, key, _ := other.Issue("intruder", []string{"localhost"}, time.Hour)
+	ioutil.WriteFile(file("intruder.crt"), cert, 0644)
+	ioutil.WriteFile(file("intruder.key"), key, 0600)
+
+	intruderCfg, err := ClientConfig(file("ca.crt"), file("intruder.crt"), file("intruder.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = &http.Client{Transport: &http.Transport{TLSClientConfig: intruderCfg}}
+	if _, err = c.Get(srv.URL); err == nil {
+		t.Fatal("client with a foreign certificate was accepted")
+	}
+}
+
+func TestLoadCA(t *testing.T) {
+
+	dir, cleanup := testFiles(t)
+	defer cleanup()
+
+	ca, err := LoadCA(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ca.Cert.IsCA || ca.Cert.Subject.CommonName != "thorium test ca" {
+		t.Fatalf("unexpected authority %v", ca.Cert.Subject)
+	}
+}
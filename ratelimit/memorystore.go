@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in process. it is meant for tests and single node
+// development.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	count   int64
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) Incr(key string, window time.Duration) (int64, time.Duration, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	e := s.live(key, now)
+	if e == nil {
+		e = &memoryEntry{expires: now.Add(window)}
+		s.entries[key] = e
+	}
+
+	e.count++
+	return e.count, e.expires.Sub(now), nil
+}
+
+func (s *MemoryStore) Lock(key string, d time.Duration) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &memoryEntry{count: 1, expires: time.Now().Add(d)}
+	return nil
+}
+
+func (s *MemoryStore) LockedFor(key string) (time.Duration, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	e := s.live(key, now)
+	if e == nil {
+		return 0, nil
+	}
+
+	return e.expires.Sub(now), nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) live(key string, now time.Time) *memoryEntry {
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if !now.Before(e.expires) {
+		delete(s.entries, key)
+		return nil
+	}
+
+	return e
+}
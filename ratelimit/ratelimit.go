@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// this package throttles requests with counters kept in a shared Store, so
+// every master replica enforces the same limits.
+
+type Store interface {
+	// Incr bumps the counter for key, starting a new window if it has none,
+	// and returns the count and the time left in the window.
+	Incr(key string, window time.Duration) (count int64, ttl time.Duration, err error)
+
+	// Lock blocks key for d.
+	Lock(key string, d time.Duration) error
+
+	// LockedFor returns how much longer key is blocked, or zero.
+	LockedFor(key string) (time.Duration, error)
+
+	// Reset forgets the counter for key.
+	Reset(key string) error
+}
+
+// TrustForwardedFor makes ClientIP use the X-Forwarded-For header. only set it
+// when the master sits behind a load balancer that overwrites the header.
+var TrustForwardedFor bool = false
+
+// Limiter allows Limit requests per key in each Window.
+type Limiter struct {
+	Name   string
+	Limit  int64
+	Window time.Duration
+
+	store Store
+}
+
+func NewLimiter(store Store, name string, limit int64, window time.Duration) *Limiter {
+
+	return &Limiter{Name: name, Limit: limit, Window: window, store: store}
+}
+
+// Allow counts a request for key. when the limit is exceeded it returns false
+// and how long the caller should wait.
+func (l *Limiter) Allow(key string) (bool, time.Duration, error) {
+
+	count, ttl, err := l.store.Incr(fmt.Sprintf("ratelimit/%s/%s", l.Name, key), l.Window)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if count > l.Limit {
+		return false, ttl, nil
+	}
+
+	return true, 0, nil
+}
+
+// Lockout blocks a key after repeated failures. the first Threshold failures
+// are free, then each further failure doubles the lock starting at Base, up
+// to Max. failures are counted over a Memory window that starts at the first.
+type Lockout struct {
+	Name      string
+	Threshold int64
+	Base      time.Duration
+	Max       time.Duration
+	Memory    time.Duration
+
+	store Store
+}
+
+func NewLockout(store Store, name string, threshold int64, base time.Duration, max time.Duration, memory time.Duration) *Lockout {
+
+	return &Lockout{Name: name, Threshold: threshold, Base: base, Max: max, Memory: memory, store: store}
+}
+
+// Check returns how much longer key is locked out, or zero.
+func (l *Lockout) Check(key string) (time.Duration, error) {
+
+	return l.store.LockedFor(l.lockKey(key))
+}
+
+// Fail records a failure for key and returns the lock it earned, if any.
+func (l *Lockout) Fail(key string) (time.Duration, error) {
+
+	count, _, err := l.store.Incr(l.failKey(key), l.Memory)
+	if err != nil {
+		return 0, err
+	}
+
+	if count <= l.Threshold {
+		return 0, nil
+	}
+
+	lock := l.Base
+	for i := l.Threshold + 1; i < count && lock < l.Max; i++ {
+		lock *= 2
+	}
+	if lock > l.Max {
+		lock = l.Max
+	}
+
+	err = l.store.Lock(l.lockKey(key), lock)
+	if err != nil {
+		return 0, err
+	}
+
+	return lock, nil
+}
+
+// Succeed clears the failures for key.
+func (l *Lockout) Succeed(key string) error {
+
+	return l.store.Reset(l.failKey(key))
+}
+
+func (l *Lockout) failKey(key string) string {
+
+	return fmt.Sprintf("lockout/%s/failures/%s", l.Name, key)
+}
+
+func (l *Lockout) lockKey(key string) string {
+
+	return fmt.Sprintf("lockout/%s/locked/%s", l.Name, key)
+}
+
+// Middleware limits the routes it wraps by the key keyFn picks from the request.
+// the limiter fails open if the store is unreachable.
+func Middleware(l *Limiter, keyFn func(*http.Request) string) martini.Handler {
+
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		ok, retry, err := l.Allow(keyFn(req))
+		if err != nil {
+			log.Print("ratelimit: ", err)
+			return
+		}
+
+		if !ok {
+			TooManyRequests(res, retry)
+		}
+	}
+}
+
+// TooManyRequests writes a 429 with a Retry-After header.
+func TooManyRequests(res http.ResponseWriter, retry time.Duration) {
+
+	SetRetryAfter(res, retry)
+	http.Error(res, "Too Many Requests", 429)
+}
+
+// SetRetryAfter sets the Retry-After header, rounded up to whole seconds.
+func SetRetryAfter(res http.ResponseWriter, retry time.Duration) {
+
+	seconds := int((retry + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	res.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// ClientIP returns the address of the caller without the port.
+func ClientIP(req *http.Request) string {
+
+	if TrustForwardedFor {
+		if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func TestLimiterBlocksAfterLimit(t *testing.T) {
+
+	l := NewLimiter(NewMemoryStore(), "login", 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := l.Allow("10.0.0.1")
+		if err != nil || !ok {
+			t.Fatalf("request %d should be allowed, got %v %v", i, ok, err)
+		}
+	}
+
+	ok, retry, err := l.Allow("10.0.0.1")
+	if err != nil || ok {
+		t.Fatalf("4th request should be limited, got %v %v", ok, err)
+	}
+	if retry <= 0 || retry > time.Minute {
+		t.Fatalf("unexpected retry after %s", retry)
+	}
+
+	// other keys have their own budget
+	ok, _, _ = l.Allow("10.0.0.2")
+	if !ok {
+		t.Fatal("another ip should not be limited")
+	}
+}
+
+func TestLockoutDoublesEachFailure(t *testing.T) {
+
+	l := NewLockout(NewMemoryStore(), "login", 2, time.Second, 5*time.Second, time.Hour)
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		got, err := l.Fail("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Fatalf("failure %d locked for %s, want %s", i+1, got, w)
+		}
+	}
+
+	locked, _ := l.Check("alice")
+	if locked <= 0 {
+		t.Fatal("alice should be locked out")
+	}
+
+	l.Succeed("alice")
+	got, _ := l.Fail("alice")
+	if got != 0 {
+		t.Fatalf("failures should reset after success, got lock %s", got)
+	}
+}
+
+func TestMiddlewareRespondsTooManyRequests(t *testing.T) {
+
+	l := NewLimiter(NewMemoryStore(), "register", 1, time.Minute)
+
+	m := martini.New()
+	r := martini.NewRouter()
+	r.Post("/clients/register", Middleware(l, ClientIP), func() (int, string) {
+		return 200, "OK"
+	})
+	m.Action(r.Handle)
+
+	codes := make([]int, 0)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/clients/register", nil)
+		req.RemoteAddr = "10.0.0.1:5555"
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+
+		if rec.Code == 429 && rec.Header().Get("Retry-After") == "" {
+			t.Fatal("429 without Retry-After")
+		}
+	}
+
+	if codes[0] != 200 || codes[1] != 429 {
+		t.Fatalf("got status codes %v, want [200 429]", codes)
+	}
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// returns {count, pttl}. the window starts on the first hit and is not
+// extended by later ones.
+var incrScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {count, redis.call('PTTL', KEYS[1])}
+`)
+
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Incr(key string, window time.Duration) (int64, time.Duration, error) {
+
+	res, err := incrScript.Run(s.client, []string{key}, []string{strconv.FormatInt(int64(window/time.Millisecond), 10)}).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, 0, fmt.Errorf("ratelimit: unexpected incr reply %v", res)
+	}
+
+	count, _ := vals[0].(int64)
+	pttl, _ := vals[1].(int64)
+	if pttl < 0 {
+		pttl = 0
+	}
+
+	return count, time.Duration(pttl) * time.Millisecond, nil
+}
+
+func (s *RedisStore) Lock(key string, d time.Duration) error {
+
+	return s.client.Set(key, "1", d).Err()
+}
+
+func (s *RedisStore) LockedFor(key string) (time.Duration, error) {
+
+	ttl, err := s.client.PTTL(key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// -2 means no key and -1 means no expiry, neither is a lock we set
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+func (s *RedisStore) Reset(key string) error {
+
+	return s.client.Del(key).Err()
+}
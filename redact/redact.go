@@ -0,0 +1,64 @@
+package redact
+
+import (
+	"io"
+	"regexp"
+)
+
+// this package scrubs credentials out of text before it reaches a log or the
+// audit trail. it is a guard, not a license to log secrets: call sites should
+// still avoid passing them in the first place.
+
+const mask string = "[REDACTED]"
+
+var rules = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	// json web tokens: session keys, machine keys, admin tokens
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), mask},
+
+	// json fields that carry secrets
+	{regexp.MustCompile(`(?i)("(?:password|passwd|secret|token|sessionKey|machineKey|machineToken|joinToken)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `$1"` + mask + `"`},
+
+	// key=value and key: value pairs
+	{regexp.MustCompile(`(?i)\b(password|passwd|secret|token)(\s*[=:]\s*)[^\s&,;"]+`), `$1$2` + mask},
+
+	// authorization headers
+	{regexp.MustCompile(`(?i)(Bearer\s+)[A-Za-z0-9._~+/-]+=*`), `$1` + mask},
+
+	// pem private keys
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), mask},
+}
+
+// String returns s with every credential it recognizes masked.
+func String(s string) string {
+
+	for _, r := range rules {
+		s = r.pattern.ReplaceAllString(s, r.replace)
+	}
+
+	return s
+}
+
+type writer struct {
+	out io.Writer
+}
+
+// NewWriter wraps out so everything written through it is redacted. the log
+// package writes one entry per call, so each entry is scrubbed whole.
+func NewWriter(out io.Writer) io.Writer {
+
+	return &writer{out: out}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+
+	_, err := io.WriteString(w.out, String(string(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	// report the original length so callers don't treat the rewrite as a short write
+	return len(p), nil
+}
@@ -0,0 +1,196 @@
+package request
+
+import (
+	"github.com/jaybennett89/thorium-go/auth"
+	"github.com/jaybennett89/thorium-go/health"
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/openapi"
+	"github.com/jaybennett89/thorium-go/trace"
+)
+
+// the master's routes and the types they take and return. MasterAPI is the
+// unversioned part, status and operator routes, and V1API and V2API are the
+// game api under /v1 and /v2. v1 is also served without a prefix for clients
+// that shipped before there were versions. the master serves the document for
+// each version, and the contract test checks these lists against the routes
+// the master registers and against the committed openapi-v1.json and
+// openapi-v2.json, so a change to either shows up in review.
+
+const V1 string = "v1"
+const V2 string = "v2"
+
+// the version new clients should use, and the one /openapi.json describes
+const CurrentVersion string = V2
+
+var Versions = []string{V1, V2}
+
+var MasterAPI = []openapi.Operation{
+
+	// status
+	{Method: "GET", Path: "/", Tag: "status", Summary: "the replica's status and the current leader, 503 while it drains", Response: StatusResponse{}},
+	{Method: "GET", Path: "/status", Tag: "status", Summary: "the replica's status and the current leader, 503 while it drains", Response: StatusResponse{}},
+	{Method: "GET", Path: "/healthz", Tag: "status", Summary: "200 while the process runs", Response: health.Report{}},
+	{Method: "GET", Path: "/readyz", Tag: "status", Summary: "the readiness checks, 503 when any fails or while the replica drains", Response: health.Report{}},
+	{Method: "GET", Path: "/.well-known/jwks.json", Tag: "status", Summary: "public keys tokens may be signed with", Response: auth.JWKS{}},
+	{Method: "GET", Path: "/metrics", Tag: "status", Summary: "prometheus metrics, in the text exposition format"},
+	{Method: "GET", Path: "/openapi.json", Tag: "status", Summary: "the description of the current api version"},
+	{Method: "GET", Path: "/v1/openapi.json", Tag: "status", Summary: "the description of api v1"},
+	{Method: "GET", Path: "/v2/openapi.json", Tag: "status", Summary: "the description of api v2"},
+
+	// operators
+	{Method: "GET", Path: "/admin/machines", Tag: "admin", Summary: "list hosts", Auth: openapi.AuthAdmin, Response: []model.MachineStatus{},
+		Query: []openapi.Param{{Name: "approval", Type: "string", Description: "pending, approved or revoked"}}},
+	{Method: "GET", Path: "/admin/machines/:id", Tag: "admin", Summary: "a host's state and load", Auth: openapi.AuthAdmin, Response: model.MachineStatus{}},
+	{Method: "POST", Path: "/admin/machines/:id/cordon", Tag: "admin", Summary: "stop placing games on a host", Auth: openapi.AuthAdmin},
+	{Method: "POST", Path: "/admin/machines/:id/drain", Tag: "admin", Summary: "stop placing games and players on a host", Auth: openapi.AuthAdmin},
+	{Method: "POST", Path: "/admin/machines/:id/uncordon", Tag: "admin", Summary: "put a host back in service", Auth: openapi.AuthAdmin},
+	{Method: "POST", Path: "/admin/machines/:id/approve", Tag: "admin", Summary: "approve a pending host", Auth: openapi.AuthAdmin},
+	{Method: "POST", Path: "/admin/machines/:id/revoke", Tag: "admin", Summary: "revoke a host's enrollment", Auth: openapi.AuthAdmin},
+	{Method: "GET", Path: "/admin/join_tokens", Tag: "admin", Summary: "list join tokens", Auth: openapi.AuthAdmin, Response: []model.JoinToken{}},
+	{Method: "POST", Path: "/admin/join_tokens", Tag: "admin", Summary: "create a join token", Auth: openapi.AuthAdmin, Request: CreateJoinToken{}, Response: JoinTokenResponse{}, Status: 201},
+	{Method: "DELETE", Path: "/admin/join_tokens/:id", Tag: "admin", Summary: "revoke a join token", Auth: openapi.AuthAdmin},
+	{Method: "DELETE", Path: "/admin/games/:id", Tag: "admin", Summary: "end a game", Auth: openapi.AuthAdmin},
+	{Method: "GET", Path: "/admin/loading_hosts", Tag: "admin", Summary: "games that are still loading", Auth: openapi.AuthAdmin, Response: []model.LoadingHost{}},
+	{Method: "GET", Path: "/admin/sessions", Tag: "admin", Summary: "list player sessions", Auth: openapi.AuthAdmin, Response: []model.Session{}},
+	{Method: "DELETE", Path: "/admin/sessions/:uid", Tag: "admin", Summary: "kick a player", Auth: openapi.AuthAdmin},
+	{Method: "GET", Path: "/admin/accounts/:uid/restrictions", Tag: "admin", Summary: "an account's restrictions and moderation history", Auth: openapi.AuthAdmin, Response: AccountStandingResponse{}},
+	{Method: "POST", Path: "/admin/accounts/:uid/restrictions", Tag: "admin", Summary: "restrict an account", Auth: openapi.AuthAdmin, Request: AddRestriction{}, Response: model.Restriction{}, Status: 201},
+	{Method: "DELETE", Path: "/admin/accounts/:uid/restrictions/:id", Tag: "admin", Summary: "lift a restriction", Auth: openapi.AuthAdmin, Request: LiftRestriction{}},
+	{Method: "GET", Path: "/admin/audit", Tag: "admin", Summary: "query the audit log", Auth: openapi.AuthAdmin, Response: []model.AuditEvent{},
+		Query: []openapi.Param{
+			{Name: "type", Type: "string", Description: "event type"},
+			{Name: "username", Type: "string"},
+			{Name: "uid", Type: "integer"},
+			{Name: "ip", Type: "string", Description: "remote address"},
+			{Name: "since", Type: "string", Description: "RFC 3339 timestamp"},
+			{Name: "until", Type: "string", Description: "RFC 3339 timestamp"},
+			{Name: "limit", Type: "integer"},
+		}},
+	{Method: "GET", Path: "/admin/requests/:id", Tag: "admin", Summary: "the spans this replica recorded for a request id", Auth: openapi.AuthAdmin, Response: []trace.Span{}},
+	{Method: "GET", Path: "/admin/log_level", Tag: "admin", Summary: "the replica's log levels", Auth: openapi.AuthAdmin, Response: LogLevelResponse{}},
+	{Method: "PUT", Path: "/admin/log_level", Tag: "admin", Summary: "change the replica's log levels", Auth: openapi.AuthAdmin, Request: SetLogLevel{}, Response: LogLevelResponse{}},
+}
+
+// the api as it was before versioning. frozen, see v2.go
+var V1API = []openapi.Operation{
+
+	// clients
+	{Method: "POST", Path: "/clients/login", Tag: "clients", Summary: "log in and get a session key", Request: Authentication{}, Response: LoginResponse{}},
+	{Method: "POST", Path: "/clients/register", Tag: "clients", Summary: "create an account and log in", Request: Authentication{}, Response: LoginResponse{}},
+	{Method: "POST", Path: "/clients/disconnect", Tag: "clients", Summary: "end the caller's session", Auth: openapi.AuthSession},
+	{Method: "POST", Path: "/clients/recovery", Tag: "clients", Summary: "set the account's recovery email", Auth: openapi.AuthSession, Request: SetRecoveryContact{}},
+	{Method: "POST", Path: "/clients/password/forgot", Tag: "clients", Summary: "mail a password reset token, if the account has a recovery email", Request: ForgotPassword{}},
+	{Method: "POST", Path: "/clients/password/reset", Tag: "clients", Summary: "set a new password with a reset token", Request: ResetPassword{}},
+
+	// characters
+	{Method: "POST", Path: "/characters/new", Tag: "characters", Summary: "create a character", Auth: openapi.AuthSession, Request: CreateCharacter{}, Response: NewCharacterResponse{}},
+	{Method: "POST", Path: "/characters/select", Tag: "characters", Summary: "choose the character to play", Auth: openapi.AuthSession, Request: SelectCharacter{}, Response: model.Character{}},
+	{Method: "GET", Path: "/characters/:id/profile", Tag: "characters", Summary: "a character's public profile and lifetime record", Response: model.CharacterProfile{}},
+	{Method: "GET", Path: "/characters", Tag: "characters", Summary: "read a character for a game server", Auth: openapi.AuthGameServer, Request: GetCharacter{}, Response: model.Character{}},
+	{Method: "POST", Path: "/characters", Tag: "characters", Summary: "save a character's state from a game server", Auth: openapi.AuthGameServer, Request: UpdateCharacter{}},
+
+	// games
+	{Method: "POST", Path: "/games/register_server", Tag: "games", Summary: "a game server reports that it is listening", Auth: openapi.AuthGameServer, Request: RegisterGameServer{}},
+	{Method: "POST", Path: "/games/player_connect", Tag: "games", Summary: "a player joined a game server", Auth: openapi.AuthGameServer, Request: PlayerConnect{}, Response: PlayerConnectResponse{}},
+	{Method: "POST", Path: "/games/player_disconnect", Tag: "games", Summary: "a player left a game server", Auth: openapi.AuthGameServer, Request: PlayerDisconnect{}},
+	{Method: "POST", Path: "/games/shutdown_server", Tag: "games", Summary: "a game server is exiting", Auth: openapi.AuthGameServer, Request: ShutdownServer{}},
+	{Method: "POST", Path: "/games/server_status", Tag: "games", Summary: "game server status reports, not implemented yet"},
+	{Method: "POST", Path: "/games", Tag: "games", Summary: "place a new game on a host", Auth: openapi.AuthSession, Request: CreateNewGame{}, Response: CreateNewGameResponse{}, Status: 201},
+	{Method: "GET", Path: "/games", Tag: "games", Summary: "list games", Response: []model.Game{}},
+	{Method: "GET", Path: "/games/:id", Tag: "games", Summary: "a game's details, not implemented yet"},
+	{Method: "GET", Path: "/games/:id/server_info", Tag: "games", Summary: "where to connect to a game, 202 while it is still loading", Response: ServerInfoResponse{}},
+	{Method: "POST", Path: "/games/join", Tag: "games", Summary: "reserve a seat in a running game and get a join ticket", Auth: openapi.AuthSession, Request: JoinGame{}, Response: JoinGameResponse{}},
+	{Method: "POST", Path: "/games/join_queue", Tag: "games", Summary: "matchmaking, not implemented yet"},
+
+	// machines
+	{Method: "POST", Path: "/machines/register", Tag: "machines", Summary: "enroll a host with a join token, or renew one with its machine key", Request: RegisterMachine{}, Response: MachineRegisterResponse{}},
+	{Method: "POST", Path: "/machines/status", Tag: "machines", Summary: "host heartbeat", Auth: openapi.AuthMachine, Request: MachineStatus{}, Response: MachineStatusResponse{}},
+	{Method: "POST", Path: "/machines/:id/disconnect", Tag: "machines", Summary: "unregister the calling host", Auth: openapi.AuthMachine},
+	{Method: "DELETE", Path: "/machines/:id", Tag: "machines", Summary: "unregister the calling host", Auth: openapi.AuthMachine},
+}
+
+// v2 takes ids in the path instead of the body and tokens only in the
+// Authorization header, and drops the v1 routes that were never implemented
+var V2API = []openapi.Operation{
+
+	// clients
+	{Method: "POST", Path: "/clients/login", Tag: "clients", Summary: "log in and get a session key", Request: Authentication{}, Response: LoginResponse{}},
+	{Method: "POST", Path: "/clients/register", Tag: "clients", Summary: "create an account and log in", Request: Authentication{}, Response: LoginResponse{}},
+	{Method: "POST", Path: "/clients/disconnect", Tag: "clients", Summary: "end the caller's session", Auth: openapi.AuthSession},
+	{Method: "PUT", Path: "/clients/recovery", Tag: "clients", Summary: "set the account's recovery email", Auth: openapi.AuthSession, Request: RecoveryContact{}},
+	{Method: "POST", Path: "/clients/password/forgot", Tag: "clients", Summary: "mail a password reset token, if the account has a recovery email", Request: ForgotPassword{}},
+	{Method: "POST", Path: "/clients/password/reset", Tag: "clients", Summary: "set a new password with a reset token", Request: ResetPassword{}},
+
+	// characters
+	{Method: "POST", Path: "/characters", Tag: "characters", Summary: "create a character", Auth: openapi.AuthSession, Request: NewCharacter{}, Response: NewCharacterResponse{}},
+	{Method: "POST", Path: "/characters/:id/select", Tag: "characters", Summary: "choose the character to play", Auth: openapi.AuthSession, Response: model.Character{}},
+	{Method: "GET", Path: "/characters/:id", Tag: "characters", Summary: "read a character for a game server", Auth: openapi.AuthGameServer, Response: model.Character{}},
+	{Method: "PUT", Path: "/characters/:id", Tag: "characters", Summary: "save a character's state from a game server", Auth: openapi.AuthGameServer, Request: CharacterSnapshot{}},
+	{Method: "GET", Path: "/characters/:id/profile", Tag: "characters", Summary: "a character's public profile and lifetime record", Response: model.CharacterProfile{}},
+	{Method: "GET", Path: "/characters/:id/matches", Tag: "characters", Summary: "a character's matches a page at a time, newest first", Response: MatchHistory{},
+		Query: []openapi.Param{
+			{Name: "limit", Type: "integer", Description: "matches per page, at most 100"},
+			{Name: "cursor", Type: "string", Description: "the nextCursor of the previous page"},
+		}},
+
+	// games
+	{Method: "POST", Path: "/games", Tag: "games", Summary: "place a new game on a host", Auth: openapi.AuthSession, Request: NewGame{}, Response: CreateNewGameResponse{}, Status: 201},
+	{Method: "GET", Path: "/games", Tag: "games", Summary: "list games a page at a time", Response: GameList{},
+		Query: []openapi.Param{
+			{Name: "map", Type: "string"},
+			{Name: "mode", Type: "string"},
+			{Name: "minLevel", Type: "integer", Description: "lowest minimum level"},
+			{Name: "maxLevel", Type: "integer", Description: "highest minimum level"},
+			{Name: "openSlots", Type: "boolean", Description: "only games with room for another player"},
+			{Name: "state", Type: "string", Description: "requested, provisioning, loading, running, ending, ended or failed. loading and running games when left out"},
+			{Name: "region", Type: "string", Description: "the region of the game's host"},
+			{Name: "sort", Type: "string", Description: "newest, oldest, most_players or fewest_players"},
+			{Name: "limit", Type: "integer", Description: "games per page, at most 100"},
+			{Name: "cursor", Type: "string", Description: "the nextCursor of the previous page"},
+		}},
+	{Method: "GET", Path: "/games/:id", Tag: "games", Summary: "a game in any state and the transitions that brought it there", Response: model.GameDetail{}},
+	{Method: "POST", Path: "/games/:id/join", Tag: "games", Summary: "reserve a seat in a running game and get a join ticket", Auth: openapi.AuthSession, Request: GameJoin{}, Response: JoinGameResponse{}},
+	{Method: "GET", Path: "/games/:id/server", Tag: "games", Summary: "where to connect to a game, 202 while it is still loading", Response: ServerInfoResponse{}},
+	{Method: "PUT", Path: "/games/:id/server", Tag: "games", Summary: "a game server reports that it is listening", Auth: openapi.AuthGameServer, Request: ServerListening{}},
+	{Method: "DELETE", Path: "/games/:id/server", Tag: "games", Summary: "a game server is exiting", Auth: openapi.AuthGameServer},
+	{Method: "POST", Path: "/games/:id/players", Tag: "games", Summary: "a player joined a game server", Auth: openapi.AuthGameServer, Request: PlayerJoin{}, Response: PlayerConnectResponse{}},
+	{Method: "DELETE", Path: "/games/:id/players/:characterId", Tag: "games", Summary: "a player left a game server", Auth: openapi.AuthGameServer, Request: PlayerLeave{}},
+	{Method: "POST", Path: "/games/:id/results", Tag: "games", Summary: "a game server reports how its match went, once, before it shuts down", Auth: openapi.AuthGameServer, Request: MatchResults{}},
+
+	// regions
+	{Method: "GET", Path: "/regions", Tag: "regions", Summary: "the regions hosts run in and the room each has", Response: []model.RegionCapacity{}},
+
+	// machines
+	{Method: "POST", Path: "/machines", Tag: "machines", Summary: "enroll a host with a join token, or renew one with its machine key", Request: RegisterMachine{}, Response: MachineRegisterResponse{}},
+	{Method: "PUT", Path: "/machines/:id/status", Tag: "machines", Summary: "host heartbeat", Auth: openapi.AuthMachine, Request: MachineLoad{}, Response: MachineStatusResponse{}},
+	{Method: "DELETE", Path: "/machines/:id", Tag: "machines", Summary: "unregister the calling host", Auth: openapi.AuthMachine},
+}
+
+// VersionAPI returns the operations of a version, with paths relative to its
+// prefix, or nil for a version that doesn't exist.
+func VersionAPI(version string) []openapi.Operation {
+
+	switch version {
+	case V1:
+		return V1API
+	case V2:
+		return V2API
+	}
+
+	return nil
+}
+
+// Spec is the OpenAPI document for a version: the unversioned routes and the
+// version's own under its prefix. every route of a version older than
+// CurrentVersion is marked deprecated.
+func Spec(version string) openapi.Document {
+
+	ops := append([]openapi.Operation{}, MasterAPI...)
+	for _, op := range VersionAPI(version) {
+		op.Path = "/" + version + op.Path
+		op.Deprecated = version != CurrentVersion
+		ops = append(ops, op)
+	}
+
+	return openapi.New("thorium master", version, ops)
+}
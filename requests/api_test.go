@@ -0,0 +1,253 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jaybennett89/thorium-go/openapi"
+)
+
+// the contract between the master's code and its published spec. run
+//
+//	go test ./requests -update
+//
+// after changing a request or response type, and commit openapi-v1.json and
+// openapi-v2.json with the change so the diff shows what clients will see.
+// openapi-v1.json should only ever change in the unversioned routes.
+
+var update = flag.Bool("update", false, "rewrite openapi-<version>.json from the route lists")
+
+const masterDir string = "../cmd/masterserver"
+
+// where the master mounts each version's routes, by the function that
+// registers them
+var mounts = map[string][]string{
+	"registerV1Routes": {"", "/v1"},
+	"registerV2Routes": {"/v2"},
+}
+
+var mountVersions = map[string]string{
+	"registerV1Routes": V1,
+	"registerV2Routes": V2,
+}
+
+func TestSpecMatchesCommitted(t *testing.T) {
+
+	for _, version := range Versions {
+		specFile := "openapi-" + version + ".json"
+
+		generated, err := json.MarshalIndent(Spec(version), "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated = append(generated, '\n')
+
+		if *update {
+			err = ioutil.WriteFile(specFile, generated, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		committed, err := ioutil.ReadFile(specFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(generated, committed) {
+			t.Errorf("%s is out of date with the request types, run go test ./requests -update and review the diff", specFile)
+		}
+	}
+}
+
+func TestSpecCoversMasterRoutes(t *testing.T) {
+
+	src, err := readMaster()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(src.routes[""]) == 0 {
+		t.Fatal("found no routes in " + masterDir)
+	}
+
+	// each list against the routes registered for it, both ways
+	lists := map[string][]openapi.Operation{"": MasterAPI}
+	for fn, version := range mountVersions {
+		lists[fn] = VersionAPI(version)
+	}
+
+	for fn, ops := range lists {
+		name := "MasterAPI"
+		if fn != "" {
+			name = strings.ToUpper(mountVersions[fn]) + "API"
+		}
+
+		served := map[string]bool{}
+		for _, route := range src.routes[fn] {
+			served[route] = true
+
+			parts := strings.SplitN(route, " ", 2)
+			if openapi.Find(ops, parts[0], parts[1]) == nil {
+				t.Errorf("the master serves %s but %s doesn't describe it", route, name)
+			}
+		}
+
+		seen := map[string]bool{}
+		for _, op := range ops {
+			route := op.Method + " " + op.Path
+			if seen[route] {
+				t.Errorf("%s describes %s twice", name, route)
+			}
+			seen[route] = true
+
+			if !served[route] {
+				t.Errorf("%s describes %s but the master doesn't serve it", name, route)
+			}
+		}
+	}
+
+	for fn, prefixes := range mounts {
+		got := src.mounts[fn]
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(prefixes, ",") {
+			t.Errorf("%s is mounted at %q, want %q", fn, got, prefixes)
+		}
+	}
+}
+
+type masterSource struct {
+	// "METHOD pattern" by the function registering the route, "" for any
+	// function that isn't a version's
+	routes map[string][]string
+
+	// group prefixes by the function mounted there
+	mounts map[string][]string
+}
+
+// readMaster reads the routes the master registers out of its source, for
+// each m.Get("/...") style call and the calls inside a m.Group("/prefix",
+// ...), and notes where the version functions are mounted
+func readMaster() (*masterSource, error) {
+
+	files, err := filepath.Glob(filepath.Join(masterDir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	src := &masterSource{routes: map[string][]string{}, mounts: map[string][]string{}}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+
+			owner := ""
+			if _, ok := mountVersions[fn.Name.Name]; ok {
+				owner = fn.Name.Name
+			}
+			src.collect(fn, owner, "")
+		}
+	}
+
+	return src, nil
+}
+
+var routeMethods = map[string]string{"Get": "GET", "Post": "POST", "Put": "PUT", "Delete": "DELETE", "Patch": "PATCH"}
+
+func (src *masterSource) collect(node ast.Node, owner string, prefix string) {
+
+	ast.Inspect(node, func(n ast.Node) bool {
+
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		if sel.Sel.Name == "Group" {
+			groupPrefix, ok := groupPattern(call.Args[0])
+			if !ok {
+				return true
+			}
+
+			for _, arg := range call.Args[1:] {
+				if ident, ok := arg.(*ast.Ident); ok {
+					src.mounts[ident.Name] = append(src.mounts[ident.Name], prefix+groupPrefix)
+					continue
+				}
+				src.collect(arg, owner, prefix+groupPrefix)
+			}
+			return false
+		}
+
+		pattern, ok := routePattern(call.Args[0])
+		if !ok {
+			return true
+		}
+
+		if method, ok := routeMethods[sel.Sel.Name]; ok {
+			src.routes[owner] = append(src.routes[owner], method+" "+prefix+pattern)
+		}
+
+		return true
+	})
+}
+
+// routePattern returns a string literal that looks like a path. header and
+// query lookups take string literals too, but never ones starting with /
+func routePattern(expr ast.Expr) (string, bool) {
+
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil || !strings.HasPrefix(s, "/") {
+		return "", false
+	}
+
+	return s, true
+}
+
+// groupPattern returns a group's prefix, which may be empty
+func groupPattern(expr ast.Expr) (string, bool) {
+
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", false
+	}
+
+	return s, true
+}
@@ -0,0 +1,133 @@
+package request
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+// GET /v2/games sort orders
+const SortNewest string = "newest"
+const SortOldest string = "oldest"
+const SortMostPlayers string = "most_players"
+const SortFewestPlayers string = "fewest_players"
+
+const DefaultGamePage int = 50
+const MaxGamePage int = 100
+
+// GET /v2/games query. the zero value lists every game, newest first, a page
+// at a time. the json names are the query parameter names.
+type GameFilter struct {
+	Map  string `json:"map"`
+	Mode string `json:"mode"`
+
+	// bounds on the games' minimum level, 0 for no bound
+	MinLevel int `json:"minLevel" validate:"min=0"`
+	MaxLevel int `json:"maxLevel" validate:"min=0"`
+
+	// only games with room for another player
+	OpenSlots bool `json:"openSlots"`
+
+	// loading and running games when empty
+	State  string `json:"state" validate:"oneof=requested|provisioning|loading|running|ending|ended|failed"`
+	Region string `json:"region"`
+	Sort   string `json:"sort" validate:"oneof=newest|oldest|most_players|fewest_players"`
+
+	// 0 for DefaultGamePage
+	Limit int `json:"limit" validate:"min=0,max=100"`
+
+	// the nextCursor of the previous page, with the same filter and sort
+	Cursor string `json:"cursor"`
+}
+
+// Query encodes the filter as query parameters, leaving out the zero values
+func (f *GameFilter) Query() url.Values {
+
+	q := url.Values{}
+	set := func(name string, value string) {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	setInt := func(name string, value int) {
+		if value != 0 {
+			q.Set(name, strconv.Itoa(value))
+		}
+	}
+
+	set("map", f.Map)
+	set("mode", f.Mode)
+	setInt("minLevel", f.MinLevel)
+	setInt("maxLevel", f.MaxLevel)
+	if f.OpenSlots {
+		q.Set("openSlots", "true")
+	}
+	set("state", f.State)
+	set("region", f.Region)
+	set("sort", f.Sort)
+	setInt("limit", f.Limit)
+	set("cursor", f.Cursor)
+
+	return q
+}
+
+// ParseGameFilter reads and validates a filter from query parameters. a
+// parameter that doesn't parse is reported like any other broken rule, as a
+// *validation.FieldError.
+func ParseGameFilter(q url.Values) (GameFilter, error) {
+
+	f := GameFilter{
+		Map:    q.Get("map"),
+		Mode:   q.Get("mode"),
+		State:  q.Get("state"),
+		Region: q.Get("region"),
+		Sort:   q.Get("sort"),
+		Cursor: q.Get("cursor"),
+	}
+
+	var err error
+	parseInt := func(name string, dst *int) {
+		v := q.Get(name)
+		if v == "" || err != nil {
+			return
+		}
+		*dst, err = strconv.Atoi(v)
+		if err != nil {
+			err = &validation.FieldError{Field: name, Rule: validation.Rule{Name: "integer"}}
+		}
+	}
+
+	parseInt("minLevel", &f.MinLevel)
+	parseInt("maxLevel", &f.MaxLevel)
+	parseInt("limit", &f.Limit)
+
+	if v := q.Get("openSlots"); v != "" && err == nil {
+		f.OpenSlots, err = strconv.ParseBool(v)
+		if err != nil {
+			err = &validation.FieldError{Field: "openSlots", Rule: validation.Rule{Name: "bool"}}
+		}
+	}
+
+	if err != nil {
+		return f, err
+	}
+
+	if f.Sort == "" {
+		f.Sort = SortNewest
+	}
+	if f.Limit == 0 {
+		f.Limit = DefaultGamePage
+	}
+
+	return f, validation.Struct(&f)
+}
+
+// GET /v2/games
+type GameList struct {
+	Games []model.GameListing `json:"games"`
+
+	// empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,70 @@
+package request
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+func TestGameFilterRoundTrip(t *testing.T) {
+
+	f := GameFilter{
+		Map:       "forest",
+		Mode:      "ctf",
+		MinLevel:  5,
+		MaxLevel:  20,
+		OpenSlots: true,
+		State:     "running",
+		Region:    "eu-west",
+		Sort:      SortMostPlayers,
+		Limit:     10,
+		Cursor:    "abc",
+	}
+
+	parsed, err := ParseGameFilter(f.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parsed, f) {
+		t.Fatalf("got %+v, want %+v", parsed, f)
+	}
+}
+
+func TestGameFilterDefaults(t *testing.T) {
+
+	f, err := ParseGameFilter(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Sort != SortNewest || f.Limit != DefaultGamePage {
+		t.Fatalf("sort %q limit %d", f.Sort, f.Limit)
+	}
+
+	if len((&GameFilter{}).Query()) != 0 {
+		t.Fatal("the zero filter has query parameters")
+	}
+}
+
+func TestGameFilterRejects(t *testing.T) {
+
+	for query, field := range map[string]string{
+		"minLevel=x":     "minLevel",
+		"minLevel=-1":    "minLevel",
+		"limit=500":      "limit",
+		"openSlots=most": "openSlots",
+		"state=paused":   "state",
+		"sort=random":    "sort",
+	} {
+		q, _ := url.ParseQuery(query)
+		_, err := ParseGameFilter(q)
+
+		fieldErr, ok := err.(*validation.FieldError)
+		if !ok || fieldErr.Field != field {
+			t.Errorf("%s: got %v, want an error on %s", query, err, field)
+		}
+	}
+}
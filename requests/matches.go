@@ -0,0 +1,120 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+// stat names are lowercase words joined by underscores, damage_dealt
+var statName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// POST /v2/games/:id/results, sent once by the game server when its match is
+// over, before it shuts down
+type MatchResults struct {
+	Players []PlayerResult `json:"players" validate:"required,max=64"`
+}
+
+type PlayerResult struct {
+	CharacterId int    `json:"characterId" validate:"required"`
+	Outcome     string `json:"outcome" validate:"required,oneof=win|loss|draw|abandoned"`
+
+	// 0 in a game without teams
+	Team  int `json:"team" validate:"min=0"`
+	Score int `json:"score"`
+
+	// any numbers the game keeps, kills or damage_dealt. each is added to the
+	// character's lifetime total of the same name.
+	Stats map[string]float64 `json:"stats,omitempty" validate:"max=32"`
+}
+
+// Check applies the rules tags can't express: each player's own tags, stat
+// names, and each character at most once
+func (r *MatchResults) Check() error {
+
+	seen := make(map[int]bool, len(r.Players))
+
+	for i := range r.Players {
+		p := &r.Players[i]
+		field := fmt.Sprintf("players[%d]", i)
+
+		err := validation.Struct(p)
+		if fieldErr, ok := err.(*validation.FieldError); ok {
+			fieldErr.Field = field + "." + fieldErr.Field
+			return fieldErr
+		}
+
+		if seen[p.CharacterId] {
+			return &validation.FieldError{Field: field + ".characterId", Rule: validation.Rule{Name: "unique"}}
+		}
+		seen[p.CharacterId] = true
+
+		for name := range p.Stats {
+			if !statName.MatchString(name) {
+				return &validation.FieldError{Field: field + ".stats." + name, Rule: validation.Rule{Name: "name"}}
+			}
+		}
+	}
+
+	return nil
+}
+
+const DefaultMatchPage int = 20
+const MaxMatchPage int = 100
+
+// GET /v2/characters/:id/matches query, newest first
+type MatchPage struct {
+
+	// 0 for DefaultMatchPage
+	Limit int `json:"limit" validate:"min=0,max=100"`
+
+	// the nextCursor of the previous page
+	Cursor string `json:"cursor"`
+}
+
+// Query encodes the page as query parameters, leaving out the zero values
+func (p *MatchPage) Query() url.Values {
+
+	q := url.Values{}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+
+	return q
+}
+
+// ParseMatchPage reads a MatchPage from query parameters and fills in the
+// default limit
+func ParseMatchPage(q url.Values) (MatchPage, error) {
+
+	p := MatchPage{Cursor: q.Get("cursor")}
+
+	if v := q.Get("limit"); v != "" {
+		var err error
+		p.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			return p, &validation.FieldError{Field: "limit", Rule: validation.Rule{Name: "integer"}}
+		}
+	}
+
+	if p.Limit == 0 {
+		p.Limit = DefaultMatchPage
+	}
+
+	return p, validation.Struct(&p)
+}
+
+// GET /v2/characters/:id/matches
+type MatchHistory struct {
+	Matches []model.CharacterMatch `json:"matches"`
+
+	// empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
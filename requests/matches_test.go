@@ -0,0 +1,85 @@
+package request
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaybennett89/thorium-go/model"
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+func TestMatchResultsCheck(t *testing.T) {
+
+	valid := func() *MatchResults {
+		return &MatchResults{Players: []PlayerResult{
+			{CharacterId: 1, Outcome: model.OutcomeWin, Team: 1, Score: 30, Stats: map[string]float64{"kills": 12, "damage_dealt": 4210.5}},
+			{CharacterId: 2, Outcome: model.OutcomeLoss, Team: 2, Score: 10},
+		}}
+	}
+
+	if err := valid().Check(); err != nil {
+		t.Fatalf("valid results refused: %s", err)
+	}
+
+	cases := []struct {
+		change func(*MatchResults)
+		field  string
+	}{
+		{func(r *MatchResults) { r.Players[1].Outcome = "surrendered" }, "players[1].outcome"},
+		{func(r *MatchResults) { r.Players[0].CharacterId = 0 }, "players[0].characterId"},
+		{func(r *MatchResults) { r.Players[1].Team = -1 }, "players[1].team"},
+		{func(r *MatchResults) { r.Players[1].CharacterId = 1 }, "players[1].characterId"},
+		{func(r *MatchResults) { r.Players[0].Stats["Head Shots"] = 3 }, "players[0].stats.Head Shots"},
+	}
+
+	for _, c := range cases {
+		r := valid()
+		c.change(r)
+
+		fieldErr, ok := r.Check().(*validation.FieldError)
+		if !ok || fieldErr.Field != c.field {
+			t.Errorf("got %v, want an error on %s", fieldErr, c.field)
+		}
+	}
+}
+
+// the outcomes a game server may report are the ones the model names
+func TestOutcomesMatchModel(t *testing.T) {
+
+	f, _ := reflect.TypeOf(PlayerResult{}).FieldByName("Outcome")
+
+	var oneof string
+	for _, r := range validation.Rules(f) {
+		if r.Name == "oneof" {
+			oneof = r.Arg
+		}
+	}
+
+	want := strings.Join([]string{model.OutcomeWin, model.OutcomeLoss, model.OutcomeDraw, model.OutcomeAbandoned}, "|")
+	if oneof != want {
+		t.Fatalf("outcome oneof=%s, want oneof=%s", oneof, want)
+	}
+}
+
+func TestMatchPage(t *testing.T) {
+
+	p, err := ParseMatchPage(url.Values{})
+	if err != nil || p.Limit != DefaultMatchPage {
+		t.Fatalf("%+v %v", p, err)
+	}
+
+	want := MatchPage{Limit: 5, Cursor: "abc"}
+	p, err = ParseMatchPage(want.Query())
+	if err != nil || p != want {
+		t.Fatalf("got %+v %v, want %+v", p, err, want)
+	}
+
+	for _, query := range []string{"limit=x", "limit=-1", "limit=101"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := ParseMatchPage(q); err == nil {
+			t.Errorf("%s accepted", query)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package request
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+// region and zone names are lowercase words joined by dashes, eu-west or
+// eu-west-1a
+var regionName = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// ValidRegion reports whether name can be used as a region or zone
+func ValidRegion(name string) bool {
+
+	return regionName.MatchString(name)
+}
+
+// Check applies the rules tags can't express: region and zone names, and no
+// zone without a region
+func (r *RegisterMachine) Check() error {
+
+	if r.Region != "" && !ValidRegion(r.Region) {
+		return &validation.FieldError{Field: "region", Rule: validation.Rule{Name: "name"}}
+	}
+
+	if r.Zone != "" {
+		if r.Region == "" {
+			return &validation.FieldError{Field: "region", Rule: validation.Rule{Name: "required"}}
+		}
+		if !ValidRegion(r.Zone) {
+			return &validation.FieldError{Field: "zone", Rule: validation.Rule{Name: "name"}}
+		}
+	}
+
+	return nil
+}
+
+// Check applies the rules tags can't express: region names, each region at
+// most once, and latencies that aren't negative
+func (g *NewGame) Check() error {
+
+	seen := make(map[string]bool, len(g.Regions))
+
+	for i, region := range g.Regions {
+		field := fmt.Sprintf("regions[%d]", i)
+
+		if !ValidRegion(region) {
+			return &validation.FieldError{Field: field, Rule: validation.Rule{Name: "name"}}
+		}
+
+		if seen[region] {
+			return &validation.FieldError{Field: field, Rule: validation.Rule{Name: "unique"}}
+		}
+		seen[region] = true
+	}
+
+	for region, ms := range g.Latency {
+		field := "latency." + region
+
+		if !ValidRegion(region) {
+			return &validation.FieldError{Field: field, Rule: validation.Rule{Name: "name"}}
+		}
+
+		if ms < 0 {
+			return &validation.FieldError{Field: field, Rule: validation.Rule{Name: "min", Arg: "0"}}
+		}
+	}
+
+	return nil
+}
+
+// RegionOrder returns the regions to try placing the game in, best first:
+// the regions the client measured, fastest first, then the other regions it
+// prefers in the order it listed them. a region measured at the same latency
+// as another keeps its place in Regions, or sorts by name when neither is
+// listed.
+func (g *NewGame) RegionOrder() []string {
+
+	listed := make(map[string]int, len(g.Regions))
+	for i, region := range g.Regions {
+		listed[region] = i
+	}
+
+	rank := func(region string) int {
+		if i, ok := listed[region]; ok {
+			return i
+		}
+		return len(g.Regions)
+	}
+
+	measured := make([]string, 0, len(g.Latency))
+	for region := range g.Latency {
+		measured = append(measured, region)
+	}
+
+	sort.Slice(measured, func(i, j int) bool {
+		a, b := measured[i], measured[j]
+		if g.Latency[a] != g.Latency[b] {
+			return g.Latency[a] < g.Latency[b]
+		}
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		return a < b
+	})
+
+	order := measured
+	for _, region := range g.Regions {
+		if _, ok := g.Latency[region]; !ok {
+			order = append(order, region)
+		}
+	}
+
+	return order
+}
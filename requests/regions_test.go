@@ -0,0 +1,83 @@
+package request
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaybennett89/thorium-go/validation"
+)
+
+func TestRegionOrder(t *testing.T) {
+
+	cases := []struct {
+		game NewGame
+		want []string
+	}{
+		{NewGame{}, []string{}},
+		{NewGame{Regions: []string{"us-east", "eu-west"}}, []string{"us-east", "eu-west"}},
+		{NewGame{Latency: map[string]int{"us-east": 90, "eu-west": 20, "ap-south": 210}}, []string{"eu-west", "us-east", "ap-south"}},
+
+		// measured regions come first, then the listed ones nobody measured
+		{NewGame{Regions: []string{"us-east", "eu-west", "eu-north"}, Latency: map[string]int{"eu-west": 40, "ap-south": 35}}, []string{"ap-south", "eu-west", "us-east", "eu-north"}},
+
+		// a tie keeps the listed order, then goes by name
+		{NewGame{Regions: []string{"us-west", "us-east"}, Latency: map[string]int{"us-east": 50, "us-west": 50, "ca-central": 50}}, []string{"us-west", "us-east", "ca-central"}},
+	}
+
+	for _, c := range cases {
+		got := c.game.RegionOrder()
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%+v: got %v, want %v", c.game, got, c.want)
+		}
+	}
+}
+
+func TestNewGameCheck(t *testing.T) {
+
+	valid := NewGame{Map: "forest", GameMode: "ctf", Regions: []string{"eu-west", "us-east"}, Latency: map[string]int{"eu-west": 30}}
+	if err := valid.Check(); err != nil {
+		t.Fatalf("valid game refused: %s", err)
+	}
+
+	cases := []struct {
+		game  NewGame
+		field string
+	}{
+		{NewGame{Regions: []string{"eu-west", "EU West"}}, "regions[1]"},
+		{NewGame{Regions: []string{"eu-west", "eu-west"}}, "regions[1]"},
+		{NewGame{Latency: map[string]int{"eu-west": -1}}, "latency.eu-west"},
+		{NewGame{Latency: map[string]int{"eu_west": 10}}, "latency.eu_west"},
+	}
+
+	for _, c := range cases {
+		fieldErr, ok := c.game.Check().(*validation.FieldError)
+		if !ok || fieldErr.Field != c.field {
+			t.Errorf("%+v: got %v, want an error on %s", c.game, fieldErr, c.field)
+		}
+	}
+}
+
+func TestRegisterMachineCheck(t *testing.T) {
+
+	for _, r := range []RegisterMachine{{}, {Region: "eu-west"}, {Region: "eu-west", Zone: "eu-west-1a"}} {
+		if err := r.Check(); err != nil {
+			t.Errorf("%+v refused: %s", r, err)
+		}
+	}
+
+	cases := []struct {
+		machine RegisterMachine
+		field   string
+	}{
+		{RegisterMachine{Region: "-west"}, "region"},
+		{RegisterMachine{Zone: "eu-west-1a"}, "region"},
+		{RegisterMachine{Region: "eu-west", Zone: "1a"}, "zone"},
+	}
+
+	for _, c := range cases {
+		fieldErr, ok := c.machine.Check().(*validation.FieldError)
+		if !ok || fieldErr.Field != c.field {
+			t.Errorf("%+v: got %v, want an error on %s", c.machine, fieldErr, c.field)
+		}
+	}
+}
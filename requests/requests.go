@@ -4,14 +4,17 @@ import "github.com/jaybennett89/thorium-go/model"
 
 type CreateNewGame struct {
 	SessionKey   string `json:"sessionKey"`
-	Map          string `json:"map"`
-	GameMode     string `json:"gameMode"`
-	MinimumLevel int    `json:"minimumLevel"`
-	MaxPlayers   int    `json:"maxPlayers"`
+	Map          string `json:"map" validate:"required"`
+	GameMode     string `json:"gameMode" validate:"required"`
+	MinimumLevel int    `json:"minimumLevel" validate:"min=0"`
+
+	// 0, or anything over 64, gets the default of 16
+	MaxPlayers int `json:"maxPlayers" validate:"min=0"`
 }
 
 type NewGameServer struct {
-	GameId         int    `json:"gameId"`
+	GameId         int    `json:"gameId" validate:"required"`
+	GameToken      string `json:"gameToken" validate:"required"`
 	Map            string `json:"map"`
 	Mode           string `json:"mode"`
 	MinimumLevel   int    `json:"minimumLevel"`
@@ -20,12 +23,29 @@ type NewGameServer struct {
 
 type RegisterGameServer struct {
 	MachineKey string `json:"machineKey"`
-	GameId     int    `json:"gameId"`
-	Port       int    `json:"gameListenPort"`
+	GameId     int    `json:"gameId" validate:"required"`
+	Port       int    `json:"gameListenPort" validate:"required,min=1,max=65535"`
 }
 
 type RegisterMachine struct {
-	Port int `json:"serviceListenPort"`
+	Port      int    `json:"serviceListenPort" validate:"required,min=1,max=65535"`
+	JoinToken string `json:"joinToken,omitempty"`
+
+	// where the host runs, eu-west and eu-west-1a. a host without a region
+	// only gets games no region was asked for, or that fell back.
+	Region string `json:"region,omitempty" validate:"max=32"`
+	Zone   string `json:"zone,omitempty" validate:"max=32"`
+
+	// set when a machine re-registers: its last key and the games it is
+	// still running
+	MachineKey string `json:"machineKey,omitempty"`
+	Games      []int  `json:"games,omitempty"`
+}
+
+// sent by a host to its game server when the game server's key is rotated
+type RotateGameServerKey struct {
+	MachineKey    string `json:"machineKey"`
+	NewMachineKey string `json:"newMachineKey" validate:"required"`
 }
 
 type UnregisterMachine struct {
@@ -33,41 +53,81 @@ type UnregisterMachine struct {
 }
 
 type MachineStatus struct {
-	MachineKey     string  `json:"machineToken"`
-	UsageCPU       float64 `json:"cpuUsagePct"`
-	UsageNetwork   float64 `json:"networkUsagePct"`
-	PlayerCapacity float64 `json:"playerCapacityPct"`
+	MachineKey     string  `json:"machineKey"`
+	UsageCPU       float64 `json:"cpuUsagePct" validate:"min=0"`
+	UsageNetwork   float64 `json:"networkUsagePct" validate:"min=0"`
+	PlayerCapacity float64 `json:"playerCapacityPct" validate:"min=0"`
 }
 
 type Authentication struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required,max=128"`
+	Email    string `json:"email,omitempty"`
+}
+
+type ForgotPassword struct {
+	Username string `json:"username" validate:"required"`
+}
+
+type ResetPassword struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,max=128"`
+}
+
+type SetRecoveryContact struct {
+	SessionKey string `json:"sessionKey"`
+	Email      string `json:"email"`
 }
 
 type CreateCharacter struct {
 	SessionKey string `json:"sessionKey"`
-	Name       string `json:"name"`
-	ClassId    int    `json:"classId"`
+	Name       string `json:"name" validate:"required"`
+	ClassId    int    `json:"classId" validate:"min=0"`
 }
 
 type SelectCharacter struct {
 	SessionKey  string `json:"sessionKey"`
-	CharacterId int    `json:"characterId"`
+	CharacterId int    `json:"characterId" validate:"required"`
 }
 
 type GetCharacter struct {
 	MachineKey  string `json:"machineKey"`
-	CharacterId int    `json:"characterId"`
+	CharacterId int    `json:"characterId" validate:"required"`
 }
 
 type UpdateCharacter struct {
 	MachineKey string           `json:"machineKey"`
-	Snapshot   *model.Character `json:"snapshot"`
+	Snapshot   *model.Character `json:"snapshot" validate:"required"`
 }
 
 type JoinGame struct {
-	GameId     int    `json:"gameId"`
-	SessionKey string `json:"sessionKey"`
+	GameId      int    `json:"gameId" validate:"required"`
+	SessionKey  string `json:"sessionKey"`
+	CharacterId int    `json:"characterId" validate:"required"`
+}
+
+type AddRestriction struct {
+	Kind            string `json:"kind" validate:"required,oneof=ban|suspension|chat|matchmaking"`
+	Reason          string `json:"reason" validate:"required"`
+	DurationSeconds int    `json:"durationSeconds" validate:"min=0"`
+}
+
+type CreateJoinToken struct {
+	Label       string `json:"label"`
+	MaxUses     int    `json:"maxUses" validate:"min=0"`
+	TTLSeconds  int    `json:"ttlSeconds" validate:"min=0"`
+	AutoApprove bool   `json:"autoApprove"`
+}
+
+// level is a spec such as "warn,thordb=debug". reset puts the named
+// components back on the global level.
+type SetLogLevel struct {
+	Level string   `json:"level"`
+	Reset []string `json:"reset"`
+}
+
+type LiftRestriction struct {
+	Reason string `json:"reason"`
 }
 
 type Disconnect struct {
@@ -75,19 +135,19 @@ type Disconnect struct {
 }
 
 type PlayerConnect struct {
-	GameId      int    `json:"gameId"`
+	GameId      int    `json:"gameId" validate:"required"`
 	MachineKey  string `json:"machineKey"`
-	SessionKey  string `json:"sessionKey"`
-	CharacterId int    `json:"characterId"`
+	SessionKey  string `json:"sessionKey" validate:"required"`
+	CharacterId int    `json:"characterId" validate:"required"`
 }
 
 type PlayerDisconnect struct {
-	GameId     int              `json:"gameId"`
+	GameId     int              `json:"gameId" validate:"required"`
 	MachineKey string           `json:"machineKey"`
-	Snapshot   *model.Character `json:"snapshot"`
+	Snapshot   *model.Character `json:"snapshot" validate:"required"`
 }
 
 type ShutdownServer struct {
-	GameId     int    `json:"gameId"`
+	GameId     int    `json:"gameId" validate:"required"`
 	MachineKey string `json:"machineKey"`
 }
@@ -1,6 +1,10 @@
 package request
 
-import "github.com/jaybennett89/thorium-go/model"
+import (
+	"time"
+
+	"github.com/jaybennett89/thorium-go/model"
+)
 
 type LoginResponse struct {
 	SessionKey   string `json:"sessionKey"`
@@ -14,6 +18,15 @@ type NewCharacterResponse struct {
 type MachineRegisterResponse struct {
 	MachineId  int    `json:"machineId"`
 	MachineKey string `json:"machineKey"`
+	Approval   string `json:"approval"`
+
+	// new keys for the game servers of a re-registered machine, by game id
+	GameTokens map[int]string `json:"gameTokens,omitempty"`
+}
+
+// answers a heartbeat
+type MachineStatusResponse struct {
+	Approval string `json:"approval"`
 }
 
 type ServerInfoResponse struct {
@@ -33,11 +46,41 @@ type NewGameServerResponse struct {
 	MachineKey string `json:"machineKey"`
 }
 
+// a seat held for a character. the player gives the ticket to the game server
+// at remoteAddress:listenPort, which redeems it when the player connects. the
+// seat is given up at expiresAt if it hasn't been.
 type JoinGameResponse struct {
-	RemoteAddress string `json:"remoteAddress"`
-	ListenPort    int    `json:"listenPort"`
+	RemoteAddress string    `json:"remoteAddress"`
+	ListenPort    int       `json:"listenPort"`
+	JoinTicket    string    `json:"joinTicket"`
+	ExpiresAt     time.Time `json:"expiresAt"`
 }
 
 type PlayerConnectResponse struct {
-	Character *model.Character `json:"character"`
+	Character    *model.Character `json:"character"`
+	Restrictions []string         `json:"restrictions"`
+}
+
+type AccountStandingResponse struct {
+	Active  []model.Restriction      `json:"active"`
+	All     []model.Restriction      `json:"all"`
+	History []model.ModerationRecord `json:"history"`
+}
+
+type StatusResponse struct {
+	Status       string `json:"status"`
+	Node         string `json:"node"`
+	Leader       string `json:"leader"`
+	FencingToken int64  `json:"fencingToken"`
+}
+
+// the token is only ever shown in this response
+type JoinTokenResponse struct {
+	Token     string           `json:"token"`
+	JoinToken *model.JoinToken `json:"joinToken"`
+}
+
+type LogLevelResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
 }
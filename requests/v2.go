@@ -0,0 +1,72 @@
+package request
+
+import "github.com/jaybennett89/thorium-go/model"
+
+// v2 bodies. v2 takes tokens only in the Authorization header and ids only in
+// the path, so these are the v1 bodies without those fields. a v1 body still
+// decodes into its v2 type, which is how the v1 routes share the v2
+// handlers. the v1 types are frozen: a change a shipped client would notice
+// goes into a v2 type instead.
+
+type NewGame struct {
+	Map          string `json:"map" validate:"required"`
+	GameMode     string `json:"gameMode" validate:"required"`
+	MinimumLevel int    `json:"minimumLevel" validate:"min=0"`
+
+	// 0, or anything over 64, gets the default of 16
+	MaxPlayers int `json:"maxPlayers" validate:"min=0"`
+
+	// where to place the game: the regions the player prefers, best first,
+	// and the round trip in milliseconds the client measured to regions. see
+	// RegionOrder. without either the game goes anywhere.
+	Regions []string       `json:"regions,omitempty" validate:"max=8"`
+	Latency map[string]int `json:"latency,omitempty" validate:"max=16"`
+
+	// refuse the game rather than place it outside those regions when none
+	// of them has room
+	StrictRegion bool `json:"strictRegion,omitempty"`
+}
+
+type NewCharacter struct {
+	Name    string `json:"name" validate:"required"`
+	ClassId int    `json:"classId" validate:"min=0"`
+}
+
+type RecoveryContact struct {
+	Email string `json:"email"`
+}
+
+// POST /v2/games/:id/join
+type GameJoin struct {
+	CharacterId int `json:"characterId" validate:"required"`
+}
+
+// POST /v2/games/:id/players, sent by the game server with the player's
+// session key and, if the player reserved a seat, the join ticket
+type PlayerJoin struct {
+	SessionKey  string `json:"sessionKey" validate:"required"`
+	CharacterId int    `json:"characterId" validate:"required"`
+	JoinTicket  string `json:"joinTicket,omitempty"`
+}
+
+// DELETE /v2/games/:id/players/:characterId
+type PlayerLeave struct {
+	Snapshot *model.Character `json:"snapshot" validate:"required"`
+}
+
+// PUT /v2/games/:id/server
+type ServerListening struct {
+	Port int `json:"gameListenPort" validate:"required,min=1,max=65535"`
+}
+
+// PUT /v2/characters/:id
+type CharacterSnapshot struct {
+	Snapshot *model.Character `json:"snapshot" validate:"required"`
+}
+
+// PUT /v2/machines/:id/status
+type MachineLoad struct {
+	UsageCPU       float64 `json:"cpuUsagePct" validate:"min=0"`
+	UsageNetwork   float64 `json:"networkUsagePct" validate:"min=0"`
+	PlayerCapacity float64 `json:"playerCapacityPct" validate:"min=0"`
+}
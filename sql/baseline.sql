@@ -1,16 +1,10 @@
 
-CREATE TABLE "games" (
-	"game_id" SERIAL PRIMARY KEY,
-	"map_name" TEXT NOT NULL,
-	"game_mode" TEXT NOT NULL,
-	"minimum_level" INTEGER DEFAULT 0,
-	"player_count" INTEGER DEFAULT 0,
-	"maximum_players" INTEGER DEFAULT 16
-);
 
 CREATE TABLE "account_data" (
 	"user_id" SERIAL PRIMARY KEY,
 	"username" TEXT NOT NULL,
+	"username_key" TEXT NOT NULL UNIQUE,
+	"email" TEXT,
 	"password" BYTEA NOT NULL,
 	"salt" BYTEA NOT NULL,
 	"algorithm" TEXT NOT NULL,
@@ -18,19 +12,73 @@ CREATE TABLE "account_data" (
 	"lastlogin" TIMESTAMP NOT NULL
 );
 
+CREATE TABLE "password_resets" (
+	"reset_id" SERIAL PRIMARY KEY,
+	"user_id" INTEGER NOT NULL references account_data(user_id) ON DELETE CASCADE,
+	"token_hash" BYTEA NOT NULL UNIQUE,
+	"created_on" TIMESTAMP NOT NULL,
+	"expires_on" TIMESTAMP NOT NULL,
+	"used_on" TIMESTAMP
+);
+
+CREATE TABLE "account_restrictions" (
+	"restriction_id" SERIAL PRIMARY KEY,
+	"user_id" INTEGER NOT NULL references account_data(user_id) ON DELETE CASCADE,
+	"kind" TEXT NOT NULL CHECK ("kind" IN ('ban', 'suspension', 'chat', 'matchmaking')),
+	"reason" TEXT NOT NULL,
+	"actor" TEXT NOT NULL,
+	"created_on" TIMESTAMP NOT NULL,
+	"expires_on" TIMESTAMP,
+	"lifted_on" TIMESTAMP,
+	"lifted_by" TEXT
+);
+
+CREATE INDEX ON "account_restrictions" ("user_id") WHERE "lifted_on" IS NULL;
+
+CREATE TABLE "moderation_history" (
+	"record_id" SERIAL PRIMARY KEY,
+	"restriction_id" INTEGER NOT NULL references account_restrictions(restriction_id) ON DELETE CASCADE,
+	"user_id" INTEGER NOT NULL references account_data(user_id) ON DELETE CASCADE,
+	"action" TEXT NOT NULL,
+	"kind" TEXT NOT NULL,
+	"reason" TEXT NOT NULL,
+	"actor" TEXT NOT NULL,
+	"recorded_on" TIMESTAMP NOT NULL
+);
+
 CREATE TABLE "characters" (
 	"id" SERIAL PRIMARY KEY,
 	"uid" INTEGER references account_data,
 	"name" TEXT,
+	"name_key" TEXT NOT NULL UNIQUE,
 	"game_data" JSON,
 	"last_game_id" INTEGER DEFAULT 0
 );
 
 
+CREATE TABLE "join_tokens" (
+	"token_id" SERIAL PRIMARY KEY,
+	"token_hash" BYTEA NOT NULL UNIQUE,
+	"label" TEXT NOT NULL,
+	"max_uses" INTEGER NOT NULL DEFAULT 1,
+	"uses" INTEGER NOT NULL DEFAULT 0,
+	"auto_approve" BOOLEAN NOT NULL DEFAULT FALSE,
+	"created_by" TEXT NOT NULL,
+	"created_on" TIMESTAMP NOT NULL,
+	"expires_on" TIMESTAMP,
+	"revoked_on" TIMESTAMP
+);
+
 CREATE TABLE "machines" (
 	"machine_id" SERIAL PRIMARY KEY,
 	"remote_address" TEXT,
-	"service_listen_port" INTEGER
+	"service_listen_port" INTEGER,
+	"region" TEXT NOT NULL DEFAULT '',
+	"zone" TEXT NOT NULL DEFAULT '',
+	"state" TEXT NOT NULL DEFAULT 'active',
+	"approval" TEXT NOT NULL DEFAULT 'pending' CHECK ("approval" IN ('pending', 'approved', 'revoked')),
+	"join_token_id" INTEGER references join_tokens(token_id) ON DELETE SET NULL,
+	"enrolled_on" TIMESTAMP
 );
 
 CREATE TABLE "machines_metadata" (
@@ -42,16 +90,163 @@ CREATE TABLE "machines_metadata" (
 	"player_occupancy_pct" REAL
 );
 
-CREATE TABLE "loading_hosts" (
-	"game_id" SERIAL PRIMARY KEY references games(game_id) DEFERRABLE INITIALLY DEFERRED,
-	"machine_id" SERIAL references machines(machine_id) ON DELETE CASCADE,
-	"kickoff_time" TIMESTAMP
+-- a game moves through its states by update only. the triggers below refuse
+-- any move the state machine doesn't allow and record the others, with their
+-- state_reason, in game_transitions. ended and failed games are kept for
+-- their history. a game has a machine from provisioning until it ends, so a
+-- machine can't be removed before its games have ended or failed, and a port
+-- once it runs.
+CREATE TABLE "games" (
+	"game_id" SERIAL PRIMARY KEY,
+	"map_name" TEXT NOT NULL,
+	"game_mode" TEXT NOT NULL,
+	"minimum_level" INTEGER DEFAULT 0,
+	"player_count" INTEGER DEFAULT 0,
+	"maximum_players" INTEGER DEFAULT 16,
+	"state" TEXT NOT NULL DEFAULT 'requested' CHECK ("state" IN ('requested', 'provisioning', 'loading', 'running', 'ending', 'ended', 'failed')),
+	"machine_id" INTEGER references machines(machine_id) ON DELETE SET NULL,
+	"port" INTEGER,
+	"state_reason" TEXT,
+	"requested_on" TIMESTAMP NOT NULL DEFAULT now(),
+	"state_changed_on" TIMESTAMP NOT NULL DEFAULT now(),
+	CHECK ("state" IN ('requested', 'ended', 'failed') OR "machine_id" IS NOT NULL),
+	CHECK ("state" <> 'running' OR "port" IS NOT NULL)
 );
 
-CREATE TABLE "hosts" (
-	"game_id" SERIAL PRIMARY KEY references games(game_id),
-	"machine_id" SERIAL references machines(machine_id) ON DELETE CASCADE,
-	"port" INTEGER
+CREATE INDEX ON "games" ("state", "game_id");
+CREATE INDEX ON "games" ("player_count", "game_id");
+CREATE INDEX ON "games" ("machine_id") WHERE "state" NOT IN ('ended', 'failed');
+
+CREATE TABLE "game_transitions" (
+	"transition_id" SERIAL PRIMARY KEY,
+	"game_id" INTEGER NOT NULL references games(game_id) ON DELETE CASCADE,
+	"from_state" TEXT,
+	"to_state" TEXT NOT NULL,
+	"occurred_on" TIMESTAMP NOT NULL,
+	"reason" TEXT
+);
+
+CREATE INDEX ON "game_transitions" ("game_id", "transition_id");
+
+CREATE FUNCTION game_requested()
+	RETURNS TRIGGER AS
+$$
+BEGIN
+	IF NEW.state <> 'requested' THEN
+		RAISE EXCEPTION 'game % must start out requested, not %', NEW.game_id, NEW.state;
+	END IF;
+
+	INSERT INTO game_transitions (game_id, from_state, to_state, occurred_on)
+	VALUES (NEW.game_id, NULL, NEW.state, NEW.requested_on);
+	RETURN NEW;
+END
+$$ language plpgsql;
+
+-- the moves allowed here must match model.GameTransitions
+CREATE FUNCTION game_transition()
+	RETURNS TRIGGER AS
+$$
+BEGIN
+	IF NEW.state = OLD.state THEN
+		RETURN NEW;
+	END IF;
+
+	IF (OLD.state, NEW.state) NOT IN (
+		('requested', 'provisioning'), ('requested', 'failed'),
+		('provisioning', 'loading'), ('provisioning', 'running'), ('provisioning', 'failed'),
+		('loading', 'running'), ('loading', 'ending'), ('loading', 'ended'), ('loading', 'failed'),
+		('running', 'ending'), ('running', 'ended'), ('running', 'failed'),
+		('ending', 'ended'), ('ending', 'failed')
+	) THEN
+		RAISE EXCEPTION 'game % can''t go from % to %', NEW.game_id, OLD.state, NEW.state;
+	END IF;
+
+	NEW.state_changed_on := now();
+	INSERT INTO game_transitions (game_id, from_state, to_state, occurred_on, reason)
+	VALUES (NEW.game_id, OLD.state, NEW.state, NEW.state_changed_on, NEW.state_reason);
+	RETURN NEW;
+END
+$$ language plpgsql;
+
+CREATE TRIGGER "game_requested" AFTER INSERT ON "games"
+	FOR EACH ROW EXECUTE PROCEDURE game_requested();
+
+CREATE TRIGGER "game_transition" BEFORE UPDATE OF "state" ON "games"
+	FOR EACH ROW EXECUTE PROCEDURE game_transition();
+
+-- seats held for players on their way to a game. a reservation counts
+-- against the game's maximum_players until it is redeemed, when the player
+-- connects and is counted in player_count instead, or until it expires.
+CREATE TABLE "seat_reservations" (
+	"reservation_id" SERIAL PRIMARY KEY,
+	"game_id" INTEGER NOT NULL references games(game_id) ON DELETE CASCADE,
+	"user_id" INTEGER NOT NULL references account_data(user_id) ON DELETE CASCADE,
+	"character_id" INTEGER NOT NULL references characters(id) ON DELETE CASCADE,
+	"reserved_on" TIMESTAMP NOT NULL DEFAULT now(),
+	"expires_on" TIMESTAMP NOT NULL,
+	"redeemed_on" TIMESTAMP
+);
+
+CREATE INDEX ON "seat_reservations" ("game_id", "expires_on") WHERE "redeemed_on" IS NULL;
+CREATE INDEX ON "seat_reservations" ("character_id") WHERE "redeemed_on" IS NULL;
+
+-- every character a game admitted, kept after they leave, so a game's
+-- results can only name its own players
+CREATE TABLE "game_players" (
+	"game_id" INTEGER NOT NULL references games(game_id) ON DELETE CASCADE,
+	"character_id" INTEGER NOT NULL references characters(id) ON DELETE CASCADE,
+	"joined_on" TIMESTAMP NOT NULL DEFAULT now(),
+	PRIMARY KEY ("game_id", "character_id")
+);
+
+-- match results, reported once per game by its game server. each player's
+-- outcome, team and score is a row of match_players and each of their stats a
+-- row of match_stats. character_records and character_stats are the lifetime
+-- totals, added to in the transaction that records the match, so they always
+-- agree with the matches kept here.
+CREATE TABLE "match_results" (
+	"game_id" INTEGER PRIMARY KEY references games(game_id) ON DELETE CASCADE,
+	"reported_on" TIMESTAMP NOT NULL
+);
+
+CREATE TABLE "match_players" (
+	"game_id" INTEGER NOT NULL references match_results(game_id) ON DELETE CASCADE,
+	"character_id" INTEGER NOT NULL references characters(id) ON DELETE CASCADE,
+	"outcome" TEXT NOT NULL CHECK ("outcome" IN ('win', 'loss', 'draw', 'abandoned')),
+	"team" INTEGER NOT NULL DEFAULT 0,
+	"score" INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY ("game_id", "character_id")
+);
+
+CREATE INDEX ON "match_players" ("character_id", "game_id");
+
+CREATE TABLE "match_stats" (
+	"game_id" INTEGER NOT NULL,
+	"character_id" INTEGER NOT NULL,
+	"stat" TEXT NOT NULL,
+	"value" DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY ("game_id", "character_id", "stat"),
+	FOREIGN KEY ("game_id", "character_id") references match_players(game_id, character_id) ON DELETE CASCADE
+);
+
+CREATE TABLE "character_records" (
+	"character_id" INTEGER PRIMARY KEY references characters(id) ON DELETE CASCADE,
+	"matches" INTEGER NOT NULL,
+	"wins" INTEGER NOT NULL,
+	"losses" INTEGER NOT NULL,
+	"draws" INTEGER NOT NULL,
+	"abandoned" INTEGER NOT NULL,
+	"total_score" BIGINT NOT NULL,
+	"best_score" INTEGER NOT NULL,
+	"last_match_on" TIMESTAMP NOT NULL
+);
+
+CREATE TABLE "character_stats" (
+	"character_id" INTEGER NOT NULL references characters(id) ON DELETE CASCADE,
+	"stat" TEXT NOT NULL,
+	"total" DOUBLE PRECISION NOT NULL,
+	"best" DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY ("character_id", "stat")
 );
 
 CREATE FUNCTION get_available_machine()
@@ -66,9 +261,42 @@ BEGIN
 	SELECT m.remote_address, m.service_listen_port, mm.most_recent_key
 	FROM machines m
 	  JOIN machines_metadata mm USING (machine_id)
-	WHERE mm.cpu_usage_pct < 80.0
+	WHERE m.state = 'active'
+	AND m.approval = 'approved'
+	AND mm.cpu_usage_pct < 80.0
 	AND mm.network_usage_pct < 80.0
 	ORDER BY RANDOM()
 	LIMIT 1;
 END
 $$ language plpgsql;
+
+CREATE TABLE "leader_fences" (
+	"name" TEXT PRIMARY KEY,
+	"token" BIGINT NOT NULL
+);
+
+CREATE TABLE "signing_keys" (
+	"kid" TEXT PRIMARY KEY,
+	"private_key" TEXT NOT NULL,
+	"created_on" TIMESTAMP NOT NULL,
+	"activated_on" TIMESTAMP,
+	"retired_on" TIMESTAMP
+);
+
+CREATE TABLE "security_audit" (
+	"event_id" SERIAL PRIMARY KEY,
+	"event_type" TEXT NOT NULL,
+	"occurred_on" TIMESTAMP NOT NULL,
+	"success" BOOLEAN NOT NULL,
+	"actor" TEXT,
+	"user_id" INTEGER,
+	"username" TEXT,
+	"machine_id" INTEGER,
+	"remote_address" TEXT,
+	"user_agent" TEXT,
+	"detail" TEXT
+);
+
+CREATE INDEX ON "security_audit" ("occurred_on");
+CREATE INDEX ON "security_audit" ("event_type", "occurred_on");
+CREATE INDEX ON "security_audit" ("username", "occurred_on");
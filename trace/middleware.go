@@ -0,0 +1,63 @@
+package trace
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/logging"
+)
+
+var routeType = reflect.TypeOf((*martini.Route)(nil)).Elem()
+
+// Middleware gives every request an id, or keeps the one it came with, and
+// echoes it in the response. the id is set on the request's header so
+// handlers can pass it on with FromRequest, and handlers that take a
+// *logging.Logger get log with the id attached. when the request is done it
+// is logged and recorded as a server span.
+func Middleware(log *logging.Logger) martini.Handler {
+
+	return func(res http.ResponseWriter, req *http.Request, c martini.Context) {
+
+		id := FromRequest(req)
+		if id == "" {
+			id = NewID()
+			req.Header.Set(Header, id)
+		}
+		res.Header().Set(Header, id)
+
+		reqLog := log.With("request", id)
+		c.Map(reqLog)
+
+		start := time.Now()
+		c.Next()
+
+		// the router maps the route it matched into the request context
+		route := req.URL.Path
+		if v := c.Get(routeType); v.IsValid() {
+			route = v.Interface().(martini.Route).Pattern()
+		}
+
+		status := 200
+		if rw, ok := res.(martini.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+
+		span := Since(id, KindServer, req.Method+" "+route, start, status)
+		Default.Record(span)
+		reqLog.Info("served", "method", req.Method, "route", route, "status", status, "ms", span.DurationMs)
+	}
+}
+
+// Handler serves the spans recorded for the id in the :id route parameter.
+func Handler(params martini.Params) (int, string) {
+
+	jsonBytes, err := json.Marshal(Default.Spans(params["id"]))
+	if err != nil {
+		return 500, "Internal Server Error"
+	}
+
+	return 200, string(jsonBytes)
+}
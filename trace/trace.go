@@ -0,0 +1,179 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// request correlation across processes. the first process a request reaches
+// gives it an id in the X-Request-Id header, and every process after that
+// passes the same id along, logs it and records a span for each hop it
+// serves or makes. the spans one process holds for an id can be dumped, and
+// lined up with the spans the other processes hold for it.
+
+const Header string = "X-Request-Id"
+
+// ids from other processes are trusted as far as this
+const maxIDLength int = 64
+
+// Process names the process in the spans it records: master, host or
+// gameserver.
+var Process string
+
+var Default = NewRecorder(10*time.Minute, 10000)
+
+// NewID returns a random request id.
+func NewID() string {
+
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// FromRequest returns the request id in req's header, or "" if it has none
+// or the one it has isn't well formed.
+func FromRequest(req *http.Request) string {
+
+	id := req.Header.Get(Header)
+	if !valid(id) {
+		return ""
+	}
+
+	return id
+}
+
+func valid(id string) bool {
+
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// a server span is a request this process served, a client span is a
+// request it made
+const KindServer string = "server"
+const KindClient string = "client"
+
+type Span struct {
+	RequestId  string    `json:"requestId"`
+	Process    string    `json:"process"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"durationMs"`
+	Status     int       `json:"status"`
+}
+
+// Since returns a span that started at start and ends now.
+func Since(id string, kind string, name string, start time.Time, status int) Span {
+
+	return Span{
+		RequestId:  id,
+		Process:    Process,
+		Kind:       kind,
+		Name:       name,
+		Start:      start,
+		DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+		Status:     status,
+	}
+}
+
+// Recorder keeps the spans of recent requests in memory. a request's spans
+// are dropped ttl after its first span, and when more than max requests are
+// held the oldest goes first.
+type Recorder struct {
+	ttl time.Duration
+	max int
+
+	mu       sync.Mutex
+	requests map[string]*recorded
+
+	// request ids in the order they were first seen, oldest first
+	order []string
+}
+
+type recorded struct {
+	first time.Time
+	spans []Span
+}
+
+// spans per request past this are dropped, a retry loop shouldn't fill memory
+const maxSpansPerRequest int = 64
+
+func NewRecorder(ttl time.Duration, max int) *Recorder {
+
+	return &Recorder{ttl: ttl, max: max, requests: make(map[string]*recorded)}
+}
+
+func (r *Recorder) Record(s Span) {
+
+	if s.RequestId == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.evict(now)
+
+	rec, ok := r.requests[s.RequestId]
+	if !ok {
+		rec = &recorded{first: now}
+		r.requests[s.RequestId] = rec
+		r.order = append(r.order, s.RequestId)
+	}
+
+	if len(rec.spans) < maxSpansPerRequest {
+		rec.spans = append(rec.spans, s)
+	}
+}
+
+// Spans returns the spans recorded for id, oldest first.
+func (r *Recorder) Spans(id string) []Span {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.requests[id]
+	if !ok || time.Since(rec.first) > r.ttl {
+		return []Span{}
+	}
+
+	spans := append([]Span{}, rec.spans...)
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	return spans
+}
+
+// evict drops expired requests, and the oldest while the recorder is full
+func (r *Recorder) evict(now time.Time) {
+
+	for len(r.order) > 0 {
+		rec := r.requests[r.order[0]]
+		if len(r.order) < r.max && now.Sub(rec.first) <= r.ttl {
+			break
+		}
+
+		delete(r.requests, r.order[0])
+		r.order = r.order[1:]
+	}
+}
@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/jaybennett89/thorium-go/logging"
+)
+
+func TestFromRequest(t *testing.T) {
+
+	cases := map[string]string{
+		"":                       "",
+		"4f2a9c01d3e5b786":       "4f2a9c01d3e5b786",
+		"client-7.retry_2":       "client-7.retry_2",
+		"has space":              "",
+		"<script>":               "",
+		string(make([]byte, 65)): "",
+	}
+
+	for header, want := range cases {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set(Header, header)
+		if got := FromRequest(req); got != want {
+			t.Errorf("FromRequest(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+
+	r := NewRecorder(time.Minute, 2)
+
+	start := time.Now()
+	r.Record(Span{RequestId: "a", Name: "second", Start: start.Add(time.Millisecond)})
+	r.Record(Span{RequestId: "a", Name: "first", Start: start})
+	r.Record(Span{RequestId: "b", Name: "b"})
+
+	spans := r.Spans("a")
+	if len(spans) != 2 || spans[0].Name != "first" || spans[1].Name != "second" {
+		t.Fatalf("spans for a: %+v", spans)
+	}
+
+	// full, so a third request pushes out the oldest
+	r.Record(Span{RequestId: "c", Name: "c"})
+	if len(r.Spans("a")) != 0 || len(r.Spans("b")) != 1 || len(r.Spans("c")) != 1 {
+		t.Fatal("oldest request should have been evicted")
+	}
+
+	if spans := r.Spans("missing"); spans == nil || len(spans) != 0 {
+		t.Fatal("unknown ids should give an empty list")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	logging.SetOutput(httptest.NewRecorder())
+	Process = "test"
+
+	m := martini.Classic()
+	m.Use(Middleware(logging.New("test")))
+
+	var seen string
+	m.Get("/games/:id", func(req *http.Request) (int, string) {
+		seen = FromRequest(req)
+		return 200, "OK"
+	})
+
+	// an id from upstream is kept
+	req, _ := http.NewRequest("GET", "/games/7", nil)
+	req.Header.Set(Header, "upstream-id")
+	res := httptest.NewRecorder()
+	m.ServeHTTP(res, req)
+
+	if seen != "upstream-id" || res.Header().Get(Header) != "upstream-id" {
+		t.Fatalf("handler saw %q, response had %q", seen, res.Header().Get(Header))
+	}
+
+	spans := Default.Spans("upstream-id")
+	if len(spans) != 1 || spans[0].Name != "GET /games/:id" || spans[0].Kind != KindServer || spans[0].Process != "test" {
+		t.Fatalf("spans: %+v", spans)
+	}
+
+	// and one is made when there is none
+	req, _ = http.NewRequest("GET", "/games/8", nil)
+	res = httptest.NewRecorder()
+	m.ServeHTTP(res, req)
+
+	if seen == "" || seen == "upstream-id" || res.Header().Get(Header) != seen {
+		t.Fatalf("generated id %q, response had %q", seen, res.Header().Get(Header))
+	}
+}
@@ -0,0 +1,302 @@
+package validation
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// this package checks player chosen names and reduces them to a key used for
+// uniqueness. two names with the same key are the same name: the key folds
+// case, fullwidth forms and common cross script look-alikes, so "Alice",
+// "ALICE", "Ａｌｉｃｅ" and "Аlice" (cyrillic А) all collide.
+//
+// names are normalized to NFC first, so precomposed and decomposed spellings
+// of "zoë" are the same name. combining marks that remain after that are
+// allowed on a letter, a few at a time, for the scripts that need them.
+// format and control characters are rejected.
+
+const ReservedNamesPath string = "config/reserved-names.txt"
+const ProfanityPath string = "config/profanity.txt"
+
+type NameRules struct {
+	MinLength   int
+	MaxLength   int
+	AllowSpaces bool
+}
+
+var UsernameRules = NameRules{MinLength: 3, MaxLength: 20, AllowSpaces: false}
+var CharacterNameRules = NameRules{MinLength: 3, MaxLength: 16, AllowSpaces: true}
+
+type NameError struct {
+	Reason string
+}
+
+func (e *NameError) Error() string {
+
+	return "validation: " + e.Reason
+}
+
+var ErrNameTooShort = &NameError{"name too short"}
+var ErrNameTooLong = &NameError{"name too long"}
+var ErrNameCharacters = &NameError{"name contains invalid characters"}
+var ErrNameSeparators = &NameError{"name must start and end with a letter or digit"}
+var ErrNameReserved = &NameError{"name is reserved"}
+var ErrNameProfane = &NameError{"name is not allowed"}
+
+// more combining marks than this on one letter is decoration, not spelling
+const maxCombiningMarks int = 3
+
+var wordsMu sync.RWMutex
+var reservedNames = map[string]bool{}
+var profanity = []string{}
+
+// LoadWordLists reads the reserved name and profanity lists. missing files
+// leave the corresponding list empty.
+func LoadWordLists(reservedPath string, profanityPath string) error {
+
+	reserved, err := readWordList(reservedPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	profane, err := readWordList(profanityPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	wordsMu.Lock()
+	defer wordsMu.Unlock()
+
+	reservedNames = make(map[string]bool, len(reserved))
+	for _, w := range reserved {
+		reservedNames[squash(Key(w))] = true
+	}
+
+	profanity = make([]string, 0, len(profane))
+	for _, w := range profane {
+		profanity = append(profanity, squash(Key(w)))
+	}
+
+	return nil
+}
+
+// Username checks a username and returns its display form and uniqueness key.
+func Username(raw string) (string, string, error) {
+
+	return checkName(raw, UsernameRules)
+}
+
+// CharacterName checks a character name and returns its display form and uniqueness key.
+func CharacterName(raw string) (string, string, error) {
+
+	return checkName(raw, CharacterNameRules)
+}
+
+// Normalize returns the display form of raw: NFC, surrounding space trimmed,
+// inner runs of space collapsed and fullwidth forms mapped to ascii.
+func Normalize(raw string) string {
+
+	var b bytes.Buffer
+	space := false
+
+	for _, r := range norm.NFC.String(strings.TrimSpace(raw)) {
+
+		r = widen(r)
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteRune(' ')
+		}
+		space = false
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Key reduces a name to the form compared for uniqueness.
+func Key(raw string) string {
+
+	var b bytes.Buffer
+
+	for _, r := range Normalize(raw) {
+
+		r = fold(r)
+		if mapped, ok := confusables[r]; ok {
+			r = mapped
+		}
+
+		// separators are interchangeable: the_king, the-king and the king collide
+		if r == '-' || r == ' ' {
+			r = '_'
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func checkName(raw string, rules NameRules) (string, string, error) {
+
+	name := Normalize(raw)
+
+	n := utf8.RuneCountInString(name)
+	if n < rules.MinLength {
+		return "", "", ErrNameTooShort
+	}
+	if n > rules.MaxLength {
+		return "", "", ErrNameTooLong
+	}
+
+	// marks follow a letter or another mark
+	marks := 0
+	letter := false
+	for _, r := range name {
+		switch {
+		case unicode.Is(unicode.M, r):
+			marks++
+			if !letter || marks > maxCombiningMarks {
+				return "", "", ErrNameCharacters
+			}
+			continue
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r), r == '_', r == '-':
+			letter = false
+		case r == ' ' && rules.AllowSpaces:
+			letter = false
+		default:
+			return "", "", ErrNameCharacters
+		}
+		marks = 0
+	}
+
+	first, _ := utf8.DecodeRuneInString(name)
+	last, _ := utf8.DecodeLastRuneInString(name)
+	if isSeparator(first) || isSeparator(last) {
+		return "", "", ErrNameSeparators
+	}
+
+	key := Key(name)
+	squashed := squash(key)
+
+	wordsMu.RLock()
+	defer wordsMu.RUnlock()
+
+	if reservedNames[stripDigits(squashed)] {
+		return "", "", ErrNameReserved
+	}
+
+	for _, w := range profanity {
+		if w != "" && strings.Contains(squashed, w) {
+			return "", "", ErrNameProfane
+		}
+	}
+
+	return name, key, nil
+}
+
+func isSeparator(r rune) bool {
+
+	return r == '_' || r == '-' || r == ' '
+}
+
+// squash drops separators so "a_d_m_i_n" can't dodge the word lists
+func squash(key string) string {
+
+	return strings.Map(func(r rune) rune {
+		if isSeparator(r) {
+			return -1
+		}
+		return r
+	}, key)
+}
+
+func stripDigits(s string) string {
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// widen maps fullwidth ascii and the ideographic space to plain ascii
+func widen(r rune) rune {
+
+	switch {
+	case r >= 0xFF01 && r <= 0xFF5E:
+		return r - 0xFEE0
+	case r == 0x3000:
+		return ' '
+	}
+
+	return r
+}
+
+// fold picks one case for every rune in a case orbit, so K, k and the kelvin
+// sign all fold to k
+func fold(r rune) rune {
+
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+
+	return unicode.ToLower(min)
+}
+
+// lowercase cyrillic and greek letters that render like latin ones. keys are
+// already case folded when this is applied.
+var confusables = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ɡ': 'g',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ζ': 'z',
+	'ı': 'i',
+}
+
+func readWordList(path string) ([]string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+
+	return words, scanner.Err()
+}
+
+// IsNameError reports whether err came from a name check.
+func IsNameError(err error) bool {
+
+	_, ok := err.(*NameError)
+	return ok
+}
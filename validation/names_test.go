@@ -0,0 +1,78 @@
+package validation
+
+import "testing"
+
+func TestKeyFoldsLookAlikes(t *testing.T) {
+
+	same := []string{"Alice", "ALICE", "alice", "Ａｌｉｃｅ", "Аlice", "  alice "}
+	for _, name := range same {
+		if k := Key(name); k != "alice" {
+			t.Errorf("Key(%q) = %q, want alice", name, k)
+		}
+	}
+
+	if Key("the_king") != Key("The-King") || Key("the king") != Key("the_king") {
+		t.Error("separators should not distinguish names")
+	}
+
+	if Key("bob1") == Key("bobl") {
+		t.Error("digits should not fold into letters")
+	}
+}
+
+func TestUsernameRules(t *testing.T) {
+
+	LoadWordLists("../"+ReservedNamesPath, "../"+ProfanityPath)
+
+	valid := []string{"alice", "Bob_99", "zoë", "zoe\u0308", "李小龍", "x-ray", "नमस्ते"}
+	for _, name := range valid {
+		if _, _, err := Username(name); err != nil {
+			t.Errorf("Username(%q) rejected: %s", name, err)
+		}
+	}
+
+	invalid := map[string]error{
+		"al":                                ErrNameTooShort,
+		"abcdefghijklmnopqrstu":             ErrNameTooLong,
+		"al ice":                            ErrNameCharacters,
+		"alice%":                            ErrNameCharacters,
+		"a_lic­e":                           ErrNameCharacters, // soft hyphen
+		"\u0308zoe":                         ErrNameCharacters, // a mark on nothing
+		"zo_\u0308e":                        ErrNameCharacters, // a mark on a separator
+		"zoe\u0308\u0308\u0308\u0308\u0308": ErrNameCharacters, // stacked marks
+		"_alice":                            ErrNameSeparators,
+		"Admin":                             ErrNameReserved,
+		"ad_min42":                          ErrNameReserved,
+		"аdmin":                             ErrNameReserved, // cyrillic а
+	}
+	for name, want := range invalid {
+		if _, _, err := Username(name); err != want {
+			t.Errorf("Username(%q) = %v, want %v", name, err, want)
+		}
+	}
+}
+
+// decomposed input is the same name as precomposed
+func TestNamesAreNFC(t *testing.T) {
+
+	name, key, err := Username("Zoe\u0308")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "Zo\u00eb" || key != Key("zo\u00eb") {
+		t.Fatalf("got %q / %q", name, key)
+	}
+}
+
+func TestCharacterNameAllowsSingleSpaces(t *testing.T) {
+
+	name, key, err := CharacterName("  Sir   Lancelot ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "Sir Lancelot" || key != "sir_lancelot" {
+		t.Fatalf("got %q / %q", name, key)
+	}
+}
@@ -0,0 +1,192 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// request bodies declare their own rules in a validate tag:
+//
+//	Port int `json:"serviceListenPort" validate:"required,min=1,max=65535"`
+//
+// required rejects the zero value, min and max bound numbers or the length
+// of strings and lists, and oneof=a|b limits a string to the values listed.
+// the openapi package reads the same tags, so the published spec and the
+// checks the servers make can't disagree.
+
+type Rule struct {
+	Name string
+	Arg  string
+}
+
+// FieldError names the json field that broke a rule.
+type FieldError struct {
+	Field string
+	Rule  Rule
+}
+
+func (e *FieldError) Error() string {
+
+	switch e.Rule.Name {
+	case "required":
+		return e.Field + " is required"
+	case "min":
+		return e.Field + " must be at least " + e.Rule.Arg
+	case "max":
+		return e.Field + " must be at most " + e.Rule.Arg
+	case "oneof":
+		return e.Field + " must be one of " + strings.Replace(e.Rule.Arg, "|", ", ", -1)
+	}
+
+	return e.Field + " is invalid"
+}
+
+// Rules parses a field's validate tag. it panics on a rule it doesn't know,
+// which is a mistake in the type rather than in a request.
+func Rules(f reflect.StructField) []Rule {
+
+	tag := f.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+
+	var rules []Rule
+	for _, part := range strings.Split(tag, ",") {
+		var r Rule
+		if i := strings.Index(part, "="); i >= 0 {
+			r = Rule{Name: part[:i], Arg: part[i+1:]}
+		} else {
+			r = Rule{Name: part}
+		}
+
+		switch r.Name {
+		case "required":
+		case "min", "max":
+			_, err := strconv.ParseFloat(r.Arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validation: bad %s on %s: %q", r.Name, f.Name, r.Arg))
+			}
+		case "oneof":
+			if r.Arg == "" {
+				panic("validation: empty oneof on " + f.Name)
+			}
+		default:
+			panic(fmt.Sprintf("validation: unknown rule %q on %s", r.Name, f.Name))
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules
+}
+
+// JSONName returns the name a field has in json, and false for fields json
+// skips.
+func JSONName(f reflect.StructField) (string, bool) {
+
+	if f.PkgPath != "" && !f.Anonymous {
+		return "", false
+	}
+
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		name = f.Name
+	}
+
+	return name, true
+}
+
+// Struct checks v, a struct or a pointer to one, against its validate tags
+// and returns the first *FieldError.
+func Struct(v interface{}) error {
+
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := JSONName(f)
+		if !ok {
+			continue
+		}
+
+		fv := val.Field(i)
+		for _, r := range Rules(f) {
+			if !check(fv, r) {
+				return &FieldError{Field: name, Rule: r}
+			}
+		}
+	}
+
+	return nil
+}
+
+func check(v reflect.Value, r Rule) bool {
+
+	switch r.Name {
+	case "required":
+		return !isZero(v)
+	case "oneof":
+		// an empty string is left to required
+		if v.Kind() != reflect.String || v.String() == "" {
+			return true
+		}
+		for _, option := range strings.Split(r.Arg, "|") {
+			if v.String() == option {
+				return true
+			}
+		}
+		return false
+	}
+
+	bound, _ := strconv.ParseFloat(r.Arg, 64)
+
+	var n float64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		n = float64(v.Len())
+	default:
+		return true
+	}
+
+	if r.Name == "min" {
+		return n >= bound
+	}
+
+	return n <= bound
+}
+
+func isZero(v reflect.Value) bool {
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return v.IsZero()
+}
@@ -0,0 +1,76 @@
+package validation
+
+import "testing"
+
+type registerPort struct {
+	Port   int      `json:"serviceListenPort" validate:"required,min=1,max=65535"`
+	Name   string   `json:"name" validate:"max=4"`
+	Kind   string   `json:"kind" validate:"oneof=ban|chat"`
+	Games  []int    `json:"games" validate:"min=1"`
+	Note   *string  `json:"note,omitempty" validate:"required"`
+	hidden int      `validate:"required"`
+	Skip   string   `json:"-" validate:"required"`
+	Ratio  float64  `validate:"min=0"`
+	Extra  []string `json:"extra,omitempty"`
+}
+
+func TestStruct(t *testing.T) {
+
+	// hidden and Skip are zero, but json never sees them so neither does Struct
+	note := "n"
+	valid := registerPort{Port: 6961, Name: "abcd", Kind: "chat", Games: []int{1}, Note: &note}
+	if err := Struct(&valid); err != nil {
+		t.Fatalf("valid struct refused: %s", err)
+	}
+
+	valid.Kind = ""
+	if err := Struct(valid); err != nil {
+		t.Fatalf("empty oneof should be left to required: %s", err)
+	}
+
+	cases := []struct {
+		change func(*registerPort)
+		field  string
+		msg    string
+	}{
+		{func(r *registerPort) { r.Port = 0 }, "serviceListenPort", "serviceListenPort is required"},
+		{func(r *registerPort) { r.Port = 70000 }, "serviceListenPort", "serviceListenPort must be at most 65535"},
+		{func(r *registerPort) { r.Port = -1 }, "serviceListenPort", "serviceListenPort must be at least 1"},
+		{func(r *registerPort) { r.Name = "abcde" }, "name", "name must be at most 4"},
+		{func(r *registerPort) { r.Kind = "mute" }, "kind", "kind must be one of ban, chat"},
+		{func(r *registerPort) { r.Games = nil }, "games", "games must be at least 1"},
+		{func(r *registerPort) { r.Note = nil }, "note", "note is required"},
+		{func(r *registerPort) { r.Ratio = -0.5 }, "Ratio", "Ratio must be at least 0"},
+	}
+
+	for _, c := range cases {
+		r := valid
+		c.change(&r)
+
+		err := Struct(&r)
+		fieldErr, ok := err.(*FieldError)
+		if !ok {
+			t.Errorf("want a *FieldError for %s, got %v", c.field, err)
+			continue
+		}
+
+		if fieldErr.Field != c.field || fieldErr.Error() != c.msg {
+			t.Errorf("got %q on %s, want %q", fieldErr.Error(), fieldErr.Field, c.msg)
+		}
+	}
+}
+
+func TestRulesPanicsOnBadTags(t *testing.T) {
+
+	type bad struct {
+		A int `validate:"between=1"`
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("an unknown rule should panic")
+		}
+	}()
+
+	Struct(bad{})
+}